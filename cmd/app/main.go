@@ -15,6 +15,7 @@ import (
 func main() {
 	utils.InitFirebase()
 	zerolog.InitLogger()
+	utils.InitTokens()
 
 	app := fiber.New()
 
@@ -29,6 +30,14 @@ func main() {
 		panic(err)
 	}
 
+	if viper.GetString("WS_BROKER") == "postgres" {
+		broker, err := utils.NewPostgresBroker(db)
+		if err != nil {
+			panic(err)
+		}
+		utils.SetShuttleBroker(broker)
+	}
+
 	routes.Route(app, db)
 
 	if err := app.Listen(viper.GetString("BASE_URL")); err != nil {
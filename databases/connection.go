@@ -2,7 +2,9 @@ package databases
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"shuttle/logger"
 	"sync"
 	"time"
@@ -19,17 +21,24 @@ var postgresDB *sqlx.DB
 var mongoClient *mongo.Client
 var once sync.Once
 
+// Settings come from .env when there is one, otherwise from the environment
 func init() {
+	viper.AutomaticEnv()
 	viper.SetConfigFile(".env")
 	err := viper.ReadInConfig()
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		panic(err)
 	}
 }
 
+// Connection string for the configured database, also used by dedicated connections such as LISTEN/NOTIFY listeners
+func PostgresURI() string {
+	return "postgres://" + viper.GetString("DB_USER") + ":" + viper.GetString("DB_PASSWORD") + "@" + viper.GetString("DB_HOST") + ":" + viper.GetString("DB_PORT") + "/" + viper.GetString("DB_NAME") + "?sslmode=disable"
+}
+
 func PostgresConnection() (*sqlx.DB, error) {
 	once.Do(func() {
-		dbURI := "postgres://" + viper.GetString("DB_USER") + ":" + viper.GetString("DB_PASSWORD") + "@" + viper.GetString("DB_HOST") + ":" + viper.GetString("DB_PORT") + "/" + viper.GetString("DB_NAME") + "?sslmode=disable"
+		dbURI := PostgresURI()
 
 		conn, err := sqlx.Connect("postgres", dbURI)
 		if err != nil {
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS shuttle_locations (
+	location_id BIGINT PRIMARY KEY,
+	shuttle_uuid UUID NOT NULL,
+	driver_uuid UUID NULL DEFAULT NULL,
+	latitude DOUBLE PRECISION NOT NULL,
+	longitude DOUBLE PRECISION NOT NULL,
+	speed DOUBLE PRECISION NULL DEFAULT NULL,
+	heading DOUBLE PRECISION NULL DEFAULT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (driver_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE SET NULL
+);
+
+CREATE INDEX idx_shuttle_locations_shuttle_recorded ON shuttle_locations (shuttle_uuid, recorded_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS shuttle_locations;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS shuttle_last_locations (
+	shuttle_uuid UUID PRIMARY KEY,
+	location_id BIGINT NOT NULL,
+	driver_uuid UUID NULL DEFAULT NULL,
+	latitude DOUBLE PRECISION NOT NULL,
+	longitude DOUBLE PRECISION NOT NULL,
+	speed DOUBLE PRECISION NULL DEFAULT NULL,
+	heading DOUBLE PRECISION NULL DEFAULT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY (driver_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE SET NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS shuttle_last_locations;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS shuttle_status_history (
+	history_id BIGINT PRIMARY KEY,
+	shuttle_uuid UUID NOT NULL,
+	from_status shuttle_status NULL DEFAULT NULL,
+	to_status shuttle_status NOT NULL,
+	changed_by_uuid UUID NULL DEFAULT NULL,
+	changed_by VARCHAR(255) NULL DEFAULT NULL,
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (changed_by_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE SET NULL
+);
+
+CREATE INDEX idx_shuttle_status_history_shuttle ON shuttle_status_history (shuttle_uuid, changed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS shuttle_status_history;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE shuttle_status_history
+	ADD COLUMN latitude DOUBLE PRECISION NULL DEFAULT NULL,
+	ADD COLUMN longitude DOUBLE PRECISION NULL DEFAULT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE shuttle_status_history
+	DROP COLUMN IF EXISTS latitude,
+	DROP COLUMN IF EXISTS longitude;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_devices (
+	device_id BIGINT PRIMARY KEY,
+	user_uuid UUID NOT NULL,
+	device_token TEXT NOT NULL UNIQUE,
+	device_platform VARCHAR(20) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+	FOREIGN KEY (user_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_devices_user ON user_devices (user_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_devices;
+-- +goose StatementEnd
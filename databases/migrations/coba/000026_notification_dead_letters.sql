@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS notification_dead_letters (
+	dead_letter_id BIGINT PRIMARY KEY,
+	user_uuid UUID NULL DEFAULT NULL,
+	device_token TEXT NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	body TEXT NOT NULL,
+	data JSONB NULL DEFAULT NULL,
+	attempts INT NOT NULL,
+	last_error TEXT NULL DEFAULT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS notification_dead_letters;
+-- +goose StatementEnd
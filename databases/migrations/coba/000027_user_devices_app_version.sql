@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE user_devices
+	ADD COLUMN app_version VARCHAR(50) NULL DEFAULT NULL,
+	ADD COLUMN last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user_devices
+	DROP COLUMN IF EXISTS app_version,
+	DROP COLUMN IF EXISTS last_seen_at;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_user_uuid_key;
+
+ALTER TABLE refresh_tokens
+	ADD COLUMN family_uuid UUID NOT NULL DEFAULT gen_random_uuid(),
+	ADD COLUMN is_used BOOLEAN NOT NULL DEFAULT 'false';
+ALTER TABLE refresh_tokens ALTER COLUMN family_uuid DROP DEFAULT;
+
+UPDATE refresh_tokens SET is_revoked = 'false' WHERE is_revoked IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN is_revoked SET NOT NULL;
+
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens (user_uuid);
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_refresh_tokens_family;
+DROP INDEX IF EXISTS idx_refresh_tokens_user;
+
+DELETE FROM refresh_tokens WHERE is_used OR is_revoked;
+DELETE FROM refresh_tokens r
+USING refresh_tokens newer
+WHERE r.user_uuid = newer.user_uuid AND r.issued_at < newer.issued_at;
+
+ALTER TABLE refresh_tokens ALTER COLUMN is_revoked DROP NOT NULL;
+ALTER TABLE refresh_tokens
+	DROP COLUMN IF EXISTS family_uuid,
+	DROP COLUMN IF EXISTS is_used;
+ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_user_uuid_key UNIQUE (user_uuid);
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE refresh_tokens
+	ADD COLUMN device_name VARCHAR(255) NULL DEFAULT NULL,
+	ADD COLUMN ip_address VARCHAR(45) NULL DEFAULT NULL,
+	ADD COLUMN user_agent TEXT NULL DEFAULT NULL,
+	ADD COLUMN session_created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE refresh_tokens SET session_created_at = issued_at WHERE issued_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE refresh_tokens
+	DROP COLUMN IF EXISTS device_name,
+	DROP COLUMN IF EXISTS ip_address,
+	DROP COLUMN IF EXISTS user_agent,
+	DROP COLUMN IF EXISTS session_created_at;
+-- +goose StatementEnd
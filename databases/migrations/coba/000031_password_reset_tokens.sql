@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+	reset_id BIGINT PRIMARY KEY,
+	user_uuid UUID NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expired_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ NULL DEFAULT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_password_reset_tokens_user ON password_reset_tokens (user_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS password_reset_tokens;
+-- +goose StatementEnd
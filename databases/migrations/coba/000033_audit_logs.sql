@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_logs (
+	audit_id BIGINT PRIMARY KEY,
+	actor_uuid UUID NULL DEFAULT NULL,
+	actor_name VARCHAR(255) NULL DEFAULT NULL,
+	action VARCHAR(100) NOT NULL,
+	target_type VARCHAR(50) NULL DEFAULT NULL,
+	target_id VARCHAR(320) NULL DEFAULT NULL,
+	details JSONB NULL DEFAULT NULL,
+	ip_address VARCHAR(45) NULL DEFAULT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_logs_created_at ON audit_logs (created_at);
+CREATE INDEX idx_audit_logs_target ON audit_logs (target_type, target_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_logs;
+-- +goose StatementEnd
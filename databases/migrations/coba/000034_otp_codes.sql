@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS otp_codes (
+	otp_id BIGINT PRIMARY KEY,
+	user_uuid UUID NOT NULL,
+	phone VARCHAR(50) NOT NULL,
+	code_hash VARCHAR(64) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	expired_at TIMESTAMPTZ NOT NULL,
+	consumed_at TIMESTAMPTZ NULL DEFAULT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_uuid) REFERENCES users (user_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_otp_codes_phone ON otp_codes (phone, created_at);
+
+-- Every code request, including ones for unknown numbers, so the rate limit doesn't reveal which numbers exist
+CREATE TABLE IF NOT EXISTS otp_requests (
+	otp_request_id BIGINT PRIMARY KEY,
+	phone VARCHAR(50) NOT NULL,
+	requested_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_otp_requests_phone ON otp_requests (phone, requested_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS otp_requests;
+DROP TABLE IF EXISTS otp_codes;
+-- +goose StatementEnd
@@ -0,0 +1,64 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS permissions (
+	permission_code VARCHAR(50) PRIMARY KEY,
+	permission_description VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_code VARCHAR(10) NOT NULL,
+	permission_code VARCHAR(50) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_by VARCHAR(255) NULL DEFAULT NULL,
+	PRIMARY KEY (role_code, permission_code),
+	FOREIGN KEY (permission_code) REFERENCES permissions (permission_code) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+INSERT INTO permissions (permission_code, permission_description) VALUES
+	('user:read', 'View super admins, school admins and drivers of every school'),
+	('user:write', 'Add and update users of every school'),
+	('user:delete', 'Delete users of every school'),
+	('user:unlock', 'Unlock accounts locked after failed logins'),
+	('school:read', 'View schools'),
+	('school:write', 'Add and update schools'),
+	('school:delete', 'Delete schools'),
+	('vehicle:read', 'View vehicles'),
+	('vehicle:write', 'Add and update vehicles'),
+	('vehicle:delete', 'Delete vehicles'),
+	('driver:read', 'View drivers of the own school'),
+	('driver:write', 'Add and update drivers of the own school'),
+	('driver:delete', 'Delete drivers of the own school'),
+	('student:read', 'View students and their parents'),
+	('student:write', 'Add and update students and their parents'),
+	('student:delete', 'Delete students'),
+	('route:read', 'View routes'),
+	('route:write', 'Add and update routes'),
+	('route:delete', 'Delete routes'),
+	('shuttle:read', 'Track shuttles and view their history'),
+	('shuttle:write', 'Start shuttles and update their status'),
+	('children:read', 'View own children'),
+	('children:write', 'Update own children'),
+	('device:write', 'Register devices for push notifications'),
+	('permission:manage', 'View and change role permissions');
+
+-- Same access the hard-coded role lists gave before
+INSERT INTO role_permissions (role_code, permission_code) VALUES
+	('SA', 'user:read'), ('SA', 'user:write'), ('SA', 'user:delete'), ('SA', 'user:unlock'),
+	('SA', 'school:read'), ('SA', 'school:write'), ('SA', 'school:delete'),
+	('SA', 'vehicle:read'), ('SA', 'vehicle:write'), ('SA', 'vehicle:delete'),
+	('SA', 'permission:manage'),
+	('AS', 'student:read'), ('AS', 'student:write'), ('AS', 'student:delete'),
+	('AS', 'driver:read'), ('AS', 'driver:write'), ('AS', 'driver:delete'),
+	('AS', 'vehicle:read'), ('AS', 'vehicle:write'), ('AS', 'vehicle:delete'),
+	('AS', 'route:read'), ('AS', 'route:write'), ('AS', 'route:delete'),
+	('AS', 'shuttle:read'),
+	('D', 'route:read'), ('D', 'shuttle:read'), ('D', 'shuttle:write'), ('D', 'device:write'),
+	('P', 'children:read'), ('P', 'children:write'), ('P', 'shuttle:read'), ('P', 'device:write');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+-- +goose StatementEnd
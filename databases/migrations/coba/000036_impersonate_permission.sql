@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+INSERT INTO permissions (permission_code, permission_description) VALUES
+	('user:impersonate', 'Act as a school admin, driver or parent')
+ON CONFLICT (permission_code) DO NOTHING;
+
+INSERT INTO role_permissions (role_code, permission_code) VALUES
+	('SA', 'user:impersonate')
+ON CONFLICT (role_code, permission_code) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE permission_code = 'user:impersonate';
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE route_assignment
+	ADD COLUMN IF NOT EXISTS route_name_uuid UUID NULL DEFAULT NULL,
+	ADD COLUMN IF NOT EXISTS student_order INT NULL DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_route_assignment_route_name ON route_assignment (route_name_uuid, driver_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_route_assignment_route_name;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS route_points (
+	route_point_id BIGINT PRIMARY KEY,
+	route_point_uuid UUID UNIQUE NOT NULL,
+	route_name_uuid UUID NOT NULL,
+	route_point_name VARCHAR(100) NOT NULL,
+	route_point_order INT NOT NULL,
+	route_point_latitude DOUBLE PRECISION NOT NULL,
+	route_point_longitude DOUBLE PRECISION NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_by VARCHAR(255) NULL DEFAULT NULL,
+	updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+	updated_by VARCHAR(255) NULL DEFAULT NULL,
+	deleted_at TIMESTAMPTZ NULL DEFAULT NULL,
+	deleted_by VARCHAR(255) NULL DEFAULT NULL,
+	FOREIGN KEY (route_name_uuid) REFERENCES routes (route_name_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_route_points_route ON route_points (route_name_uuid, route_point_order);
+
+ALTER TABLE route_assignment
+	ADD COLUMN IF NOT EXISTS route_point_uuid UUID NULL DEFAULT NULL
+	REFERENCES route_points (route_point_uuid) ON UPDATE NO ACTION ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE route_assignment DROP COLUMN IF EXISTS route_point_uuid;
+DROP TABLE IF EXISTS route_points;
+-- +goose StatementEnd
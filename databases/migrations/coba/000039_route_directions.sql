@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE routes
+	ADD COLUMN IF NOT EXISTS morning_departure_time TIME NULL DEFAULT NULL,
+	ADD COLUMN IF NOT EXISTS afternoon_departure_time TIME NULL DEFAULT NULL;
+
+ALTER TABLE route_points
+	ADD COLUMN IF NOT EXISTS route_point_direction VARCHAR(20) NOT NULL DEFAULT 'morning'
+	CHECK (route_point_direction IN ('morning', 'afternoon'));
+
+DROP INDEX IF EXISTS idx_route_points_route;
+CREATE INDEX idx_route_points_route ON route_points (route_name_uuid, route_point_direction, route_point_order);
+
+ALTER TABLE route_assignment
+	ADD COLUMN IF NOT EXISTS afternoon_student_order INT NULL DEFAULT NULL,
+	ADD COLUMN IF NOT EXISTS afternoon_route_point_uuid UUID NULL DEFAULT NULL
+	REFERENCES route_points (route_point_uuid) ON UPDATE NO ACTION ON DELETE SET NULL;
+
+-- Students are dropped off in the reverse of the order they were picked up in
+UPDATE route_assignment ra
+SET afternoon_student_order = ordered.position
+FROM (
+	SELECT route_id, ROW_NUMBER() OVER (
+		PARTITION BY route_name_uuid, driver_uuid
+		ORDER BY student_order DESC NULLS FIRST, created_at DESC
+	) AS position
+	FROM route_assignment
+	WHERE route_name_uuid IS NOT NULL
+) ordered
+WHERE ra.route_id = ordered.route_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE route_assignment
+	DROP COLUMN IF EXISTS afternoon_route_point_uuid,
+	DROP COLUMN IF EXISTS afternoon_student_order;
+
+DELETE FROM route_points WHERE route_point_direction = 'afternoon';
+DROP INDEX IF EXISTS idx_route_points_route;
+ALTER TABLE route_points DROP COLUMN IF EXISTS route_point_direction;
+CREATE INDEX idx_route_points_route ON route_points (route_name_uuid, route_point_order);
+
+ALTER TABLE routes
+	DROP COLUMN IF EXISTS afternoon_departure_time,
+	DROP COLUMN IF EXISTS morning_departure_time;
+-- +goose StatementEnd
@@ -0,0 +1,69 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS school_calendars (
+	school_uuid UUID PRIMARY KEY,
+	operating_weekdays SMALLINT[] NOT NULL DEFAULT '{1,2,3,4,5}', -- ISO weekdays, 1 is monday
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_by VARCHAR(255) NULL DEFAULT NULL,
+	updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+	updated_by VARCHAR(255) NULL DEFAULT NULL,
+	FOREIGN KEY (school_uuid) REFERENCES schools (school_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS school_terms (
+	term_id BIGINT PRIMARY KEY,
+	term_uuid UUID UNIQUE NOT NULL,
+	school_uuid UUID NOT NULL,
+	term_name VARCHAR(255) NOT NULL,
+	term_start_date DATE NOT NULL,
+	term_end_date DATE NOT NULL,
+	source_uid VARCHAR(255) NULL DEFAULT NULL, -- UID of the imported iCalendar event
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_by VARCHAR(255) NULL DEFAULT NULL,
+	updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+	updated_by VARCHAR(255) NULL DEFAULT NULL,
+	CHECK (term_end_date >= term_start_date),
+	UNIQUE (school_uuid, source_uid),
+	FOREIGN KEY (school_uuid) REFERENCES schools (school_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_school_terms_school ON school_terms (school_uuid, term_start_date);
+
+CREATE TABLE IF NOT EXISTS school_closures (
+	closure_id BIGINT PRIMARY KEY,
+	closure_uuid UUID UNIQUE NOT NULL,
+	school_uuid UUID NOT NULL,
+	closure_name VARCHAR(255) NOT NULL,
+	closure_type VARCHAR(20) NOT NULL DEFAULT 'holiday' CHECK (closure_type IN ('holiday', 'closure')),
+	closure_start_date DATE NOT NULL,
+	closure_end_date DATE NOT NULL,
+	source_uid VARCHAR(255) NULL DEFAULT NULL, -- UID of the imported iCalendar event
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_by VARCHAR(255) NULL DEFAULT NULL,
+	updated_at TIMESTAMPTZ NULL DEFAULT NULL,
+	updated_by VARCHAR(255) NULL DEFAULT NULL,
+	CHECK (closure_end_date >= closure_start_date),
+	UNIQUE (school_uuid, source_uid),
+	FOREIGN KEY (school_uuid) REFERENCES schools (school_uuid) ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+CREATE INDEX idx_school_closures_school ON school_closures (school_uuid, closure_start_date);
+
+INSERT INTO permissions (permission_code, permission_description) VALUES
+	('calendar:read', 'View the operating calendar and route schedules of the own school'),
+	('calendar:write', 'Change and import the operating calendar of the own school')
+ON CONFLICT (permission_code) DO NOTHING;
+
+INSERT INTO role_permissions (role_code, permission_code) VALUES
+	('AS', 'calendar:read'),
+	('AS', 'calendar:write')
+ON CONFLICT (role_code, permission_code) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE permission_code IN ('calendar:read', 'calendar:write');
+DROP TABLE IF EXISTS school_closures;
+DROP TABLE IF EXISTS school_terms;
+DROP TABLE IF EXISTS school_calendars;
+-- +goose StatementEnd
@@ -3,13 +3,19 @@ package handler
 import (
 	"fmt"
 	"log"
+	"math"
+	"shuttle/errors"
 	"shuttle/logger"
 	"shuttle/models/dto"
 	"shuttle/services"
 	"shuttle/utils"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type AuthHandlerInterface interface {
@@ -17,15 +23,28 @@ type AuthHandlerInterface interface {
 	Logout(c *fiber.Ctx) error
 	GetMyProfile(c *fiber.Ctx) error
 	IssueNewAccessToken(c *fiber.Ctx) error
+	GetMySessions(c *fiber.Ctx) error
+	RevokeMySession(c *fiber.Ctx) error
+	RevokeAllMySessions(c *fiber.Ctx) error
+	ChangePassword(c *fiber.Ctx) error
+	ForgotPassword(c *fiber.Ctx) error
+	ResetPassword(c *fiber.Ctx) error
+	UnlockAccount(c *fiber.Ctx) error
+	RequestOTP(c *fiber.Ctx) error
+	VerifyOTP(c *fiber.Ctx) error
+	JWKS(c *fiber.Ctx) error
+	Impersonate(c *fiber.Ctx) error
 }
 
 type authHandler struct {
-	authService services.AuthService
+	authService         services.AuthService
+	notificationService services.NotificationServiceInterface
 }
 
-func NewAuthHttpHandler(authService services.AuthService) AuthHandlerInterface {
+func NewAuthHttpHandler(authService services.AuthService, notificationService services.NotificationServiceInterface) AuthHandlerInterface {
 	return &authHandler{
-		authService: authService,
+		authService:         authService,
+		notificationService: notificationService,
 	}
 }
 
@@ -35,8 +54,17 @@ func (handler *authHandler) Login(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Invalid request data", nil)
 	}
 
-	userDataOnLogin, err := handler.authService.Login(loginRequest.Email, loginRequest.Password)
+	userDataOnLogin, err := handler.authService.Login(loginRequest.Email, loginRequest.Password, c.IP())
 	if err != nil {
+		if blocked, ok := err.(*services.LoginBlockedError); ok {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+			status := fiber.StatusTooManyRequests
+			if blocked.Locked {
+				status = fiber.StatusLocked
+			}
+			return utils.ErrorResponse(c, status, strings.ToUpper(blocked.Error()[:1])+blocked.Error()[1:], nil)
+		}
+
 		logger.LogError(err, "Failed to login", map[string]interface{}{
 			"email": loginRequest.Email,
 		})
@@ -48,13 +76,26 @@ func (handler *authHandler) Login(c *fiber.Ctx) error {
 		"email": loginRequest.Email,
 	})
 
+	responseData, err := issueLoginTokens(c, userDataOnLogin, loginRequest.DeviceName)
+	if err != nil {
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "User logged in successfully", responseData)
+}
+
+// Start a new session for a user that just logged in and return its access and refresh token
+func issueLoginTokens(c *fiber.Ctx, userDataOnLogin dto.UserDataOnLoginDTO, deviceName string) (map[string]interface{}, error) {
+	// Every login is a separate session, so several devices can stay logged in at once
+	sessionUUID := uuid.New()
+
 	// Access token (short expiration)
-	accessToken, err := utils.GenerateToken(fmt.Sprintf("%d", userDataOnLogin.UserID), userDataOnLogin.UserUUID, userDataOnLogin.Username, userDataOnLogin.RoleCode)
+	accessToken, err := utils.GenerateToken(fmt.Sprintf("%d", userDataOnLogin.UserID), userDataOnLogin.UserUUID, userDataOnLogin.Username, userDataOnLogin.RoleCode, sessionUUID.String())
 	if err != nil {
 		logger.LogError(err, "Failed to generate access token", map[string]interface{}{
 			"user_id": userDataOnLogin.UserID,
 		})
-		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		return nil, err
 	}
 
 	// Refresh token (long expiration)
@@ -63,21 +104,69 @@ func (handler *authHandler) Login(c *fiber.Ctx) error {
 		logger.LogError(err, "Failed to generate refresh token", map[string]interface{}{
 			"user_id": userDataOnLogin.UserID,
 		})
-		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		return nil, err
 	}
 
 	// Save refresh token in the database
-	err = utils.SaveRefreshToken(userDataOnLogin.UserUUID, refreshToken)
+	err = utils.SaveRefreshToken(userDataOnLogin.UserUUID, sessionUUID, refreshToken, sessionDevice(c, deviceName))
 	if err != nil {
 		logger.LogError(err, "Failed to save refresh token", map[string]interface{}{
 			"user_id": userDataOnLogin.UserID,
 		})
-		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		return nil, err
 	}
 
-	responseData := map[string]interface{}{
+	utils.TouchPresence(userDataOnLogin.UserUUID)
+
+	return map[string]interface{}{
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
+	}, nil
+}
+
+func (handler *authHandler) RequestOTP(c *fiber.Ctx) error {
+	otpReq := new(dto.OTPRequest)
+	if err := c.BodyParser(otpReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+
+	if err := utils.ValidateStruct(c, otpReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := handler.authService.RequestOTP(otpReq.Phone); err != nil {
+		if limited, ok := err.(*services.OTPRateLimitError); ok {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+			return utils.ErrorResponse(c, fiber.StatusTooManyRequests, strings.ToUpper(limited.Error()[:1])+limited.Error()[1:], nil)
+		}
+		return authErrorResponse(c, err, "Failed to request OTP code")
+	}
+
+	return utils.SuccessResponse(c, "If the phone number is registered, a login code has been sent", nil)
+}
+
+func (handler *authHandler) VerifyOTP(c *fiber.Ctx) error {
+	verifyReq := new(dto.OTPVerifyRequest)
+	if err := c.BodyParser(verifyReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+
+	if err := utils.ValidateStruct(c, verifyReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	userDataOnLogin, err := handler.authService.VerifyOTP(verifyReq.Phone, verifyReq.Code)
+	if err != nil {
+		return authErrorResponse(c, err, "Failed to verify OTP code")
+	}
+
+	logger.LogInfo("User logged in with OTP", map[string]interface{}{
+		"id": userDataOnLogin.UserID,
+	})
+
+	responseData, err := issueLoginTokens(c, userDataOnLogin, verifyReq.DeviceName)
+	if err != nil {
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
 	return utils.SuccessResponse(c, "User logged in successfully", responseData)
@@ -92,6 +181,15 @@ func (handler *authHandler) Logout(c *fiber.Ctx) error {
 	}
 	log.Printf("UserUUID retrieved: %s\n", userUUID)
 
+	// The user's own sessions, devices and status are left alone
+	if actorUUID, ok := c.Locals("actorUUID").(string); ok && actorUUID != "" {
+		if err := utils.InvalidateToken(c.Get("Authorization")); err != nil {
+			log.Printf("Failed to invalidate impersonation token for user %s: %v\n", userUUID, err)
+			return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		}
+		return utils.SuccessResponse(c, "Impersonation ended successfully", nil)
+	}
+
 	// Delete WebSocket connection if exists
 	conn, exists := utils.GetConnection(userUUID)
 	if exists {
@@ -101,17 +199,37 @@ func (handler *authHandler) Logout(c *fiber.Ctx) error {
 		log.Printf("WebSocket connection for user %s closed and removed\n", userUUID)
 	}
 
-	err := handler.authService.DeleteRefreshTokenOnLogout(c.Context(), userUUID)
+	sessionID, _ := c.Locals("sessionID").(string)
+
+	err := handler.authService.DeleteRefreshTokenOnLogout(c.Context(), userUUID, sessionID)
 	if err != nil {
 		log.Printf("Failed to delete refresh token for user %s: %v\n", userUUID, err)
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 	log.Printf("Refresh token for user %s deleted\n", userUUID)
 
-	utils.InvalidateToken(c.Get("Authorization"))
+	if err := utils.InvalidateToken(c.Get("Authorization")); err != nil {
+		log.Printf("Failed to invalidate access token for user %s: %v\n", userUUID, err)
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
 	log.Println("Access token invalidated")
 
-	err = handler.authService.UpdateUserStatus(userUUID, "offline", time.Now())
+	// Stop pushing notifications to the device being logged out, or to every device once no session is left
+	var logoutReq struct {
+		DeviceToken string `json:"device_token"`
+	}
+	_ = c.BodyParser(&logoutReq)
+	if logoutReq.DeviceToken != "" {
+		err = handler.notificationService.UnregisterDevice(userUUID, logoutReq.DeviceToken)
+	} else if sessions, sessionErr := handler.authService.GetSessions(userUUID, ""); sessionErr == nil && len(sessions) == 0 {
+		err = handler.notificationService.UnregisterAllDevices(userUUID)
+	}
+	if err != nil {
+		log.Printf("Failed to remove device tokens for user %s: %v\n", userUUID, err)
+	}
+
+	utils.ForgetPresence(userUUID)
+	err = handler.authService.UpdateUserStatus(userUUID, utils.PresenceOffline, time.Now())
 	if err != nil {
 		log.Printf("Failed to update user status for user %s: %v\n", userUUID, err)
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
@@ -171,29 +289,34 @@ func (handler *authHandler) IssueNewAccessToken(c *fiber.Ctx) error {
 
 	userID := claims["sub"].(string)
 	userUUID := claims["user_uuid"].(string)
+	username := claims["user_name"].(string)
+	roleCode := claims["role_code"].(string)
 
-	tokenErr := handler.authService.CheckStoredRefreshToken(userUUID, refreshToken)
-	if tokenErr != nil {
-		logger.LogError(tokenErr, "Failed to get stored refresh token", map[string]interface{}{
+	newRefreshToken, err := utils.GenerateRefreshToken(userID, userUUID, username, roleCode)
+	if err != nil {
+		logger.LogError(err, "Failed to generate refresh token", map[string]interface{}{
 			"user_id": userID,
-			"token":   refreshToken,
 		})
 		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
-	username := claims["user_name"].(string)
-	roleCode := claims["role_code"].(string)
-
-	err = handler.authService.UpdateRefreshToken(userUUID, refreshToken)
+	sessionID, err := handler.authService.RotateRefreshToken(userUUID, refreshToken, newRefreshToken, time.Now().Add(utils.RefreshTokenTTL), sessionDevice(c, ""))
 	if err != nil {
-		logger.LogError(err, "Failed to update refresh token", map[string]interface{}{
+		if _, ok := err.(*errors.CustomError); ok {
+			logger.LogWarn("Refresh token rejected", map[string]interface{}{
+				"user_uuid": userUUID,
+				"error":     err.Error(),
+			})
+			return utils.UnauthorizedResponse(c, "Your session has expired or revoked, please login again", nil)
+		}
+		logger.LogError(err, "Failed to rotate refresh token", map[string]interface{}{
 			"user_uuid": userUUID,
 		})
-		return utils.UnauthorizedResponse(c, "Your session has expired or revoked, please login again", nil)
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
 	}
 
 	// Generate new access token
-	accessToken, err := utils.GenerateToken(userID, userUUID, username, roleCode)
+	accessToken, err := utils.GenerateToken(userID, userUUID, username, roleCode, sessionID)
 	if err != nil {
 		logger.LogError(err, "Failed to generate access token", map[string]interface{}{
 			"user_id": userID,
@@ -203,5 +326,226 @@ func (handler *authHandler) IssueNewAccessToken(c *fiber.Ctx) error {
 
 	return utils.SuccessResponse(c, "Access token refreshed", map[string]interface{}{
 		"reissued_access_token": accessToken,
+		"refresh_token":         newRefreshToken,
 	})
 }
+
+func (handler *authHandler) GetMySessions(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+	}
+	sessionID, _ := c.Locals("sessionID").(string)
+
+	sessions, err := handler.authService.GetSessions(userUUID, sessionID)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch sessions", map[string]interface{}{
+			"user_uuid": userUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Sessions retrieved successfully", sessions)
+}
+
+func (handler *authHandler) RevokeMySession(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+	}
+	sessionID := c.Params("id")
+
+	if err := handler.authService.RevokeSession(userUUID, sessionID); err != nil {
+		return authErrorResponse(c, err, "Failed to revoke session")
+	}
+
+	if err := utils.RevokeSession(sessionID, userUUID); err != nil {
+		logger.LogError(err, "Failed to revoke access tokens of session", map[string]interface{}{
+			"user_uuid": userUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Session revoked successfully", nil)
+}
+
+// Log out everywhere, including the session making the request
+func (handler *authHandler) RevokeAllMySessions(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+	}
+	currentSessionID, _ := c.Locals("sessionID").(string)
+
+	sessionIDs, err := handler.authService.RevokeAllSessions(userUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to revoke sessions", map[string]interface{}{
+			"user_uuid": userUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+	if currentSessionID != "" && !slices.Contains(sessionIDs, currentSessionID) {
+		sessionIDs = append(sessionIDs, currentSessionID)
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := utils.RevokeSession(sessionID, userUUID); err != nil {
+			logger.LogError(err, "Failed to revoke access tokens of session", map[string]interface{}{
+				"user_uuid": userUUID,
+			})
+			return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+		}
+	}
+
+	if err := handler.notificationService.UnregisterAllDevices(userUUID); err != nil {
+		log.Printf("Failed to remove device tokens for user %s: %v\n", userUUID, err)
+	}
+
+	return utils.SuccessResponse(c, "Logged out from all sessions successfully", nil)
+}
+
+func (handler *authHandler) ChangePassword(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok {
+		return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+	sessionID, _ := c.Locals("sessionID").(string)
+
+	passwordReq := new(dto.ChangePasswordRequest)
+	if err := c.BodyParser(passwordReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+
+	if err := utils.ValidateStruct(c, passwordReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	revokedSessions, err := handler.authService.ChangePassword(userUUID, username, sessionID, *passwordReq)
+	if err != nil {
+		return authErrorResponse(c, err, "Failed to change password")
+	}
+
+	handler.revokeSessionAccessTokens(userUUID, revokedSessions)
+
+	return utils.SuccessResponse(c, "Password changed successfully", nil)
+}
+
+func (handler *authHandler) ForgotPassword(c *fiber.Ctx) error {
+	forgotReq := new(dto.ForgotPasswordRequest)
+	if err := c.BodyParser(forgotReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+
+	if err := utils.ValidateStruct(c, forgotReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := handler.authService.RequestPasswordReset(forgotReq.Email); err != nil {
+		logger.LogError(err, "Failed to request password reset", nil)
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "If the email is registered, a password reset link has been sent", nil)
+}
+
+func (handler *authHandler) ResetPassword(c *fiber.Ctx) error {
+	resetReq := new(dto.ResetPasswordRequest)
+	if err := c.BodyParser(resetReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request data", nil)
+	}
+
+	if err := utils.ValidateStruct(c, resetReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	userUUID, revokedSessions, err := handler.authService.ResetPassword(*resetReq)
+	if err != nil {
+		return authErrorResponse(c, err, "Failed to reset password")
+	}
+
+	handler.revokeSessionAccessTokens(userUUID, revokedSessions)
+
+	return utils.SuccessResponse(c, "Password has been reset, please login again", nil)
+}
+
+func (handler *authHandler) UnlockAccount(c *fiber.Ctx) error {
+	actorUUID, _ := c.Locals("userUUID").(string)
+	actorName, _ := c.Locals("user_name").(string)
+
+	userUUID := c.Params("id")
+	if _, err := uuid.Parse(userUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid user UUID format", nil)
+	}
+
+	if err := handler.authService.UnlockAccount(userUUID, actorUUID, actorName, c.IP()); err != nil {
+		return authErrorResponse(c, err, "Failed to unlock account")
+	}
+
+	return utils.SuccessResponse(c, "Account unlocked successfully", nil)
+}
+
+func (handler *authHandler) Impersonate(c *fiber.Ctx) error {
+	actorUUID, _ := c.Locals("userUUID").(string)
+	actorName, _ := c.Locals("user_name").(string)
+
+	userDataOnLogin, err := handler.authService.Impersonate(c.Params("id"), actorUUID, actorName, c.IP())
+	if err != nil {
+		return authErrorResponse(c, err, "Failed to impersonate user")
+	}
+
+	accessToken, err := utils.GenerateImpersonationToken(fmt.Sprintf("%d", userDataOnLogin.UserID), userDataOnLogin.UserUUID, userDataOnLogin.Username, userDataOnLogin.RoleCode, actorUUID, actorName)
+	if err != nil {
+		logger.LogError(err, "Failed to generate impersonation token", map[string]interface{}{
+			"user_uuid":  userDataOnLogin.UserUUID,
+			"actor_uuid": actorUUID,
+		})
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	responseData := map[string]interface{}{
+		"access_token": accessToken,
+		"expires_in":   int(utils.ImpersonationTokenTTL.Seconds()),
+	}
+
+	return utils.SuccessResponse(c, "Impersonation token issued successfully", responseData)
+}
+
+// Served as a plain JWK Set instead of the usual response envelope, that's what JWT libraries expect
+func (handler *authHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(utils.JWKS())
+}
+
+// Access tokens of revoked sessions stay valid until they expire unless their session is revoked too
+func (handler *authHandler) revokeSessionAccessTokens(userUUID string, sessionIDs []string) {
+	for _, sessionID := range sessionIDs {
+		if err := utils.RevokeSession(sessionID, userUUID); err != nil {
+			logger.LogError(err, "Failed to revoke access tokens of session", map[string]interface{}{
+				"user_uuid":  userUUID,
+				"session_id": sessionID,
+			})
+		}
+	}
+}
+
+func authErrorResponse(c *fiber.Ctx, err error, fallbackMessage string) error {
+	if customErr, ok := err.(*errors.CustomError); ok {
+		return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(customErr.Message[:1])+customErr.Message[1:], nil)
+	}
+	logger.LogError(err, fallbackMessage, nil)
+	return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+}
+
+// Session metadata from the request, the device name is whatever the app chose to send
+func sessionDevice(c *fiber.Ctx, deviceName string) dto.SessionDevice {
+	if len(deviceName) > 255 {
+		deviceName = deviceName[:255]
+	}
+
+	return dto.SessionDevice{
+		DeviceName: deviceName,
+		IPAddress:  c.IP(),
+		UserAgent:  c.Get("User-Agent"),
+	}
+}
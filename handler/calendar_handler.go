@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"shuttle/models/dto"
+	"shuttle/services"
+	"shuttle/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type CalendarHandler struct {
+	CalendarService services.CalendarServiceInterface
+}
+
+func NewCalendarHandler(calendarService services.CalendarServiceInterface) *CalendarHandler {
+	return &CalendarHandler{
+		CalendarService: calendarService,
+	}
+}
+
+func (h *CalendarHandler) GetSchoolCalendar(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	calendar, err := h.CalendarService.GetSchoolCalendar(schoolUUID)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch calendar")
+	}
+
+	return utils.SuccessResponse(c, "Calendar fetched successfully", calendar)
+}
+
+func (h *CalendarHandler) UpdateOperatingWeekdays(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	req := new(dto.SchoolCalendarWeekdaysRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := h.CalendarService.UpdateOperatingWeekdays(schoolUUID, username, *req); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to update operating weekdays")
+	}
+
+	return utils.SuccessResponse(c, "Operating weekdays updated successfully", nil)
+}
+
+func (h *CalendarHandler) AddSchoolTerm(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	req := new(dto.SchoolTermRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	termUUID, err := h.CalendarService.AddSchoolTerm(schoolUUID, username, *req)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to add term")
+	}
+
+	return utils.SuccessResponse(c, "Term added successfully", fiber.Map{"term_uuid": termUUID})
+}
+
+func (h *CalendarHandler) DeleteSchoolTerm(c *fiber.Ctx) error {
+	termUUID := c.Params("id")
+	if _, err := uuid.Parse(termUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid term UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	if err := h.CalendarService.DeleteSchoolTerm(termUUID, schoolUUID); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to delete term")
+	}
+
+	return utils.SuccessResponse(c, "Term deleted successfully", nil)
+}
+
+func (h *CalendarHandler) AddSchoolClosure(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	req := new(dto.SchoolClosureRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	closureUUID, err := h.CalendarService.AddSchoolClosure(schoolUUID, username, *req)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to add closure")
+	}
+
+	return utils.SuccessResponse(c, "Closure added successfully", fiber.Map{"closure_uuid": closureUUID})
+}
+
+func (h *CalendarHandler) DeleteSchoolClosure(c *fiber.Ctx) error {
+	closureUUID := c.Params("id")
+	if _, err := uuid.Parse(closureUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid closure UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	if err := h.CalendarService.DeleteSchoolClosure(closureUUID, schoolUUID); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to delete closure")
+	}
+
+	return utils.SuccessResponse(c, "Closure deleted successfully", nil)
+}
+
+// The calendar is either uploaded as the "file" field of a multipart form or sent as the raw body
+func (h *CalendarHandler) ImportSchoolCalendar(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	var reader io.Reader
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return utils.BadRequestResponse(c, "Calendar file is required", nil)
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return utils.BadRequestResponse(c, "Failed to read calendar file", nil)
+		}
+		defer file.Close()
+		reader = file
+	} else {
+		if len(c.Body()) == 0 {
+			return utils.BadRequestResponse(c, "Calendar file is required", nil)
+		}
+		reader = bytes.NewReader(c.Body())
+	}
+
+	result, err := h.CalendarService.ImportSchoolCalendar(schoolUUID, username, reader)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to import calendar")
+	}
+
+	return utils.SuccessResponse(c, "Calendar imported successfully", result)
+}
+
+// Schedules of every route of the school, or of the one in the path
+func (h *CalendarHandler) ExportRouteSchedules(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if routenameUUID != "" {
+		if _, err := uuid.Parse(routenameUUID); err != nil {
+			return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+		}
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	calendar, err := h.CalendarService.ExportRouteSchedules(schoolUUID, routenameUUID, c.QueryInt("days", 0))
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to export route schedules")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="route-schedule.ics"`)
+	return c.SendString(calendar)
+}
+
+func (h *CalendarHandler) GetNextPickups(c *fiber.Ctx) error {
+	parentUUID, ok := c.Locals("userUUID").(string)
+	if !ok || parentUUID == "" {
+		return utils.UnauthorizedResponse(c, "User UUID is missing or invalid", nil)
+	}
+
+	pickups, err := h.CalendarService.GetNextPickupsByParent(parentUUID)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch next pickups")
+	}
+
+	return utils.SuccessResponse(c, "Next pickups fetched successfully", pickups)
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"shuttle/models/dto"
+	"shuttle/services"
+	"shuttle/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type NotificationHandler struct {
+	NotificationService services.NotificationServiceInterface
+}
+
+func NewNotificationHandler(notificationService services.NotificationServiceInterface) *NotificationHandler {
+	return &NotificationHandler{
+		NotificationService: notificationService,
+	}
+}
+
+func (h *NotificationHandler) RegisterDevice(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+
+	deviceReq := new(dto.DeviceRequest)
+	if err := c.BodyParser(deviceReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+
+	if err := utils.ValidateStruct(c, deviceReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := h.NotificationService.RegisterDevice(userUUID, *deviceReq); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to register device")
+	}
+
+	return utils.SuccessResponse(c, "Device registered successfully", nil)
+}
+
+func (h *NotificationHandler) UnregisterDevice(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+
+	deviceReq := new(dto.UnregisterDeviceRequest)
+	if err := c.BodyParser(deviceReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+
+	if err := utils.ValidateStruct(c, deviceReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := h.NotificationService.UnregisterDevice(userUUID, deviceReq.DeviceToken); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to unregister device")
+	}
+
+	return utils.SuccessResponse(c, "Device unregistered successfully", nil)
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"shuttle/models/dto"
+	"shuttle/services"
+	"shuttle/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type PermissionHandler struct {
+	PermissionService services.PermissionServiceInterface
+}
+
+func NewPermissionHandler(permissionService services.PermissionServiceInterface) *PermissionHandler {
+	return &PermissionHandler{
+		PermissionService: permissionService,
+	}
+}
+
+func (h *PermissionHandler) GetAllPermissions(c *fiber.Ctx) error {
+	permissions, err := h.PermissionService.GetPermissions()
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch permissions")
+	}
+
+	return utils.SuccessResponse(c, "Permissions fetched successfully", permissions)
+}
+
+func (h *PermissionHandler) GetAllRolePermissions(c *fiber.Ctx) error {
+	rolePermissions, err := h.PermissionService.GetRolePermissions()
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch role permissions")
+	}
+
+	return utils.SuccessResponse(c, "Role permissions fetched successfully", rolePermissions)
+}
+
+func (h *PermissionHandler) UpdateRolePermissions(c *fiber.Ctx) error {
+	actorUUID, _ := c.Locals("userUUID").(string)
+	actorName, _ := c.Locals("user_name").(string)
+
+	permissionReq := new(dto.RolePermissionsRequest)
+	if err := c.BodyParser(permissionReq); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+
+	if err := utils.ValidateStruct(c, permissionReq); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := h.PermissionService.UpdateRolePermissions(c.Params("role"), permissionReq.Permissions, actorUUID, actorName, c.IP()); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to update role permissions")
+	}
+
+	return utils.SuccessResponse(c, "Role permissions updated successfully", nil)
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"shuttle/models/dto"
+	"shuttle/models/entity"
 	"shuttle/services"
 	"shuttle/utils"
 
@@ -16,6 +17,7 @@ type RouteHandlerInterface interface {
 	AddRoute(c *fiber.Ctx) error
 	UpdateRoute(c *fiber.Ctx) error
 	DeleteRoute(c *fiber.Ctx) error
+	OptimizeRoute(c *fiber.Ctx) error
 }
 
 type routeHandler struct {
@@ -61,11 +63,15 @@ func (handler *routeHandler) GetAllRoutesByDriver(c *fiber.Ctx) error {
 	if _, err := uuid.Parse(driverUUID); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
 	}
-	routes, err := handler.routeService.GetAllRoutesByDriver(driverUUID)
+	direction := c.Query("direction")
+	if direction != "" && direction != entity.RouteDirectionMorning && direction != entity.RouteDirectionAfternoon {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Direction must be morning or afternoon"})
+	}
+	route, err := handler.routeService.GetAllRoutesByDriver(driverUUID, direction)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch routes"})
 	}
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"routes": routes})
+	return c.Status(fiber.StatusOK).JSON(route)
 }
 
 func (handler *routeHandler) AddRoute(c *fiber.Ctx) error {
@@ -139,4 +145,41 @@ func (handler *routeHandler) DeleteRoute(c *fiber.Ctx) error {
 		return utils.InternalServerErrorResponse(c, err.Error(), nil)
 	}
 	return utils.SuccessResponse(c, "Route deleted successfully", nil)
-}
\ No newline at end of file
+}
+
+// Reorders the pickups of a route by distance, ?dry_run=true only shows the result
+func (handler *routeHandler) OptimizeRoute(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if _, err := uuid.Parse(routenameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, ok := c.Locals("user_name").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain username", nil)
+	}
+
+	direction := c.Query("direction", entity.RouteDirectionMorning)
+	if direction != entity.RouteDirectionMorning && direction != entity.RouteDirectionAfternoon {
+		return utils.BadRequestResponse(c, "Direction must be morning or afternoon", nil)
+	}
+
+	result, err := handler.routeService.OptimizeRoute(routenameUUID, schoolUUID, username, direction, c.QueryBool("dry_run", false))
+	if err != nil {
+		if err.Error() == "route not found" {
+			return utils.NotFoundResponse(c, "Route not found", nil)
+		}
+		if err.Error() == "school point is not set" {
+			return utils.BadRequestResponse(c, "School point is not set", nil)
+		}
+		return utils.InternalServerErrorResponse(c, err.Error(), nil)
+	}
+
+	if result.DryRun {
+		return utils.SuccessResponse(c, "Route optimization previewed successfully", result)
+	}
+	return utils.SuccessResponse(c, "Route optimized successfully", result)
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"shuttle/models/dto"
+	"shuttle/services"
+	"shuttle/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type RoutePointHandler struct {
+	RoutePointService services.RoutePointServiceInterface
+}
+
+func NewRoutePointHandler(routePointService services.RoutePointServiceInterface) *RoutePointHandler {
+	return &RoutePointHandler{
+		RoutePointService: routePointService,
+	}
+}
+
+func (h *RoutePointHandler) GetRoutePoints(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if _, err := uuid.Parse(routenameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	points, err := h.RoutePointService.GetRoutePoints(routenameUUID, schoolUUID, c.Query("direction"))
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch route points")
+	}
+
+	return utils.SuccessResponse(c, "Route points fetched successfully", points)
+}
+
+func (h *RoutePointHandler) AddRoutePoint(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if _, err := uuid.Parse(routenameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	req := new(dto.RoutePointRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	pointUUID, err := h.RoutePointService.AddRoutePoint(routenameUUID, schoolUUID, username, *req)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to add route point")
+	}
+
+	return utils.SuccessResponse(c, "Route point added successfully", fiber.Map{"route_point_uuid": pointUUID})
+}
+
+func (h *RoutePointHandler) UpdateRoutePoint(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if _, err := uuid.Parse(routenameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+	pointUUID := c.Params("point_id")
+	if _, err := uuid.Parse(pointUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route point UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	req := new(dto.RoutePointRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := h.RoutePointService.UpdateRoutePoint(routenameUUID, pointUUID, schoolUUID, username, *req); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to update route point")
+	}
+
+	return utils.SuccessResponse(c, "Route point updated successfully", nil)
+}
+
+func (h *RoutePointHandler) DeleteRoutePoint(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if _, err := uuid.Parse(routenameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+	pointUUID := c.Params("point_id")
+	if _, err := uuid.Parse(pointUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route point UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	if err := h.RoutePointService.DeleteRoutePoint(routenameUUID, pointUUID, schoolUUID, username); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to delete route point")
+	}
+
+	return utils.SuccessResponse(c, "Route point deleted successfully", nil)
+}
+
+func (h *RoutePointHandler) ReorderRoutePoints(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if _, err := uuid.Parse(routenameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	req := new(dto.RoutePointOrderRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := h.RoutePointService.ReorderRoutePoints(routenameUUID, schoolUUID, username, req.Direction, req.RoutePointUUIDs); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to reorder route points")
+	}
+
+	return utils.SuccessResponse(c, "Route points reordered successfully", nil)
+}
+
+func (h *RoutePointHandler) AssignRoutePointStudents(c *fiber.Ctx) error {
+	routenameUUID := c.Params("id")
+	if _, err := uuid.Parse(routenameUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route UUID format", nil)
+	}
+	pointUUID := c.Params("point_id")
+	if _, err := uuid.Parse(pointUUID); err != nil {
+		return utils.BadRequestResponse(c, "Invalid route point UUID format", nil)
+	}
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok {
+		return utils.InternalServerErrorResponse(c, "Token does not contain schoolUUID", nil)
+	}
+
+	req := new(dto.RoutePointStudentsRequestDTO)
+	if err := c.BodyParser(req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid request body", nil)
+	}
+	if err := utils.ValidateStruct(c, req); err != nil {
+		return utils.BadRequestResponse(c, err.Error(), nil)
+	}
+
+	if err := h.RoutePointService.AssignRoutePointStudents(routenameUUID, pointUUID, schoolUUID, req.StudentUUIDs); err != nil {
+		return shuttleErrorResponse(c, err, "Failed to assign students to route point")
+	}
+
+	return utils.SuccessResponse(c, "Route point students updated successfully", nil)
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	customErrors "shuttle/errors"
 	"shuttle/models/dto"
 	"shuttle/services"
 	"shuttle/utils"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -55,6 +57,11 @@ func (h *ShuttleHandler) GetShuttleTrackByParent(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(shuttles)
 }
 
+const (
+	defaultRecapDays = 30
+	maxRecapDays     = 31
+)
+
 func (h *ShuttleHandler) GetAllShuttleByParent(c *fiber.Ctx) error {
     userUUID, ok := c.Locals("userUUID").(string)
     if !ok || userUUID == "" {
@@ -69,7 +76,25 @@ func (h *ShuttleHandler) GetAllShuttleByParent(c *fiber.Ctx) error {
     // Debug log
     fmt.Println("ParentUUID:", parentUUID)
 
-    shuttles, err := h.ShuttleService.GetAllShuttleByParent(parentUUID)
+    // The recap covers whole days, the last 30 of them unless a range is asked for
+    now := time.Now()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+    lastDay, err := parseDateQuery(c, "to", today)
+    if err != nil {
+        return utils.BadRequestResponse(c, "Invalid 'to', use YYYY-MM-DD format", nil)
+    }
+    firstDay, err := parseDateQuery(c, "from", lastDay.AddDate(0, 0, -(defaultRecapDays-1)))
+    if err != nil {
+        return utils.BadRequestResponse(c, "Invalid 'from', use YYYY-MM-DD format", nil)
+    }
+    if lastDay.Before(firstDay) {
+        return utils.BadRequestResponse(c, "'to' must not be before 'from'", nil)
+    }
+    if lastDay.Sub(firstDay) >= maxRecapDays*24*time.Hour {
+        return utils.BadRequestResponse(c, fmt.Sprintf("The recap can cover at most %d days", maxRecapDays), nil)
+    }
+
+    shuttles, err := h.ShuttleService.GetAllShuttleByParent(parentUUID, firstDay, lastDay.AddDate(0, 0, 1))
     if err != nil {
         return utils.NotFoundResponse(c, "Shuttle data not found", nil)
     }
@@ -167,7 +192,7 @@ func (h *ShuttleHandler) AddShuttle(c *fiber.Ctx) error {
 	// Log: Attempt to add shuttle
 	if err := h.ShuttleService.AddShuttle(*shuttleReq, driverUUID.String(), username); err != nil {
 		log.Println("AddShuttle: Failed to add shuttle")
-		return utils.InternalServerErrorResponse(c, "Failed to add shuttle", nil)
+		return shuttleErrorResponse(c, err, "Failed to add shuttle")
 	}
 	log.Println("AddShuttle: Shuttle added successfully")
 
@@ -181,9 +206,7 @@ func (h *ShuttleHandler) EditShuttle(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Missing shuttleUUID in URL", nil)
 	}
 
-	var statusReq struct {
-		Status string `json:"status" validate:"required"`
-	}
+	var statusReq dto.ShuttleStatusRequest
 	if err := c.BodyParser(&statusReq); err != nil {
 		return utils.BadRequestResponse(c, "Invalid request body", nil)
 	}
@@ -192,12 +215,173 @@ func (h *ShuttleHandler) EditShuttle(c *fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Invalid status: "+err.Error(), nil)
 	}
 
-	if err := h.ShuttleService.EditShuttleStatus(id, statusReq.Status); err != nil {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+	username, _ := c.Locals("user_name").(string)
+
+	if (statusReq.Latitude == nil) != (statusReq.Longitude == nil) {
+		return utils.BadRequestResponse(c, "Latitude and longitude must be provided together", nil)
+	}
+
+	if err := h.ShuttleService.EditShuttleStatus(id, statusReq, userUUID, username); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return utils.NotFoundResponse(c, "Shuttle not found", nil)
 		}
-		return utils.InternalServerErrorResponse(c, "Failed to edit shuttle", nil)
+		return shuttleErrorResponse(c, err, "Failed to edit shuttle")
 	}
 
 	return utils.SuccessResponse(c, "Shuttle status updated successfully", nil)
 }
+
+// A trail covers at most one day of fixes, longer windows have to be fetched a day at a time
+const maxShuttleTrailWindow = 24 * time.Hour
+
+func (h *ShuttleHandler) GetShuttleTrail(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+	schoolUUID, _ := c.Locals("schoolUUID").(string)
+
+	shuttleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+	}
+
+	now := time.Now()
+	from, err := parseTimeQuery(c, "from", time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid 'from' time, use RFC3339 format", nil)
+	}
+	to, err := parseTimeQuery(c, "to", now)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid 'to' time, use RFC3339 format", nil)
+	}
+	if to.Before(from) {
+		return utils.BadRequestResponse(c, "'to' must be after 'from'", nil)
+	}
+	if to.Sub(from) > maxShuttleTrailWindow {
+		return utils.BadRequestResponse(c, "The time window between 'from' and 'to' can't be longer than 24 hours", nil)
+	}
+
+	trail, err := h.ShuttleService.GetShuttleTrail(shuttleUUID, userUUID, schoolUUID, from, to)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch shuttle trail")
+	}
+
+	return utils.SuccessResponse(c, "Shuttle trail retrieved successfully", trail)
+}
+
+func (h *ShuttleHandler) GetShuttleLastLocation(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+	schoolUUID, _ := c.Locals("schoolUUID").(string)
+
+	shuttleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+	}
+
+	location, err := h.ShuttleService.GetShuttleLastLocation(shuttleUUID, userUUID, schoolUUID)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch shuttle location")
+	}
+
+	return utils.SuccessResponse(c, "Shuttle location retrieved successfully", location)
+}
+
+func (h *ShuttleHandler) GetShuttleETA(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+	schoolUUID, _ := c.Locals("schoolUUID").(string)
+
+	shuttleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+	}
+
+	eta, err := h.ShuttleService.GetShuttleETA(shuttleUUID, userUUID, schoolUUID)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to estimate arrival")
+	}
+
+	return utils.SuccessResponse(c, "Shuttle ETA retrieved successfully", eta)
+}
+
+func (h *ShuttleHandler) GetShuttleTimeline(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+	schoolUUID, _ := c.Locals("schoolUUID").(string)
+
+	shuttleUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid shuttle UUID format", nil)
+	}
+
+	timeline, err := h.ShuttleService.GetShuttleTimeline(shuttleUUID, userUUID, schoolUUID)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch shuttle timeline")
+	}
+
+	return utils.SuccessResponse(c, "Shuttle timeline retrieved successfully", timeline)
+}
+
+func (h *ShuttleHandler) GetStudentTimeline(c *fiber.Ctx) error {
+	userUUID, ok := c.Locals("userUUID").(string)
+	if !ok || userUUID == "" {
+		return utils.BadRequestResponse(c, "Invalid or missing userUUID", nil)
+	}
+	schoolUUID, _ := c.Locals("schoolUUID").(string)
+
+	studentUUID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid student UUID format", nil)
+	}
+
+	date := time.Now()
+	if value := c.Query("date"); value != "" {
+		date, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return utils.BadRequestResponse(c, "Invalid 'date', use YYYY-MM-DD format", nil)
+		}
+	}
+
+	timeline, err := h.ShuttleService.GetStudentTimeline(studentUUID, userUUID, schoolUUID, date)
+	if err != nil {
+		return shuttleErrorResponse(c, err, "Failed to fetch student timeline")
+	}
+
+	return utils.SuccessResponse(c, "Student timeline retrieved successfully", timeline)
+}
+
+func parseDateQuery(c *fiber.Ctx, key string, fallback time.Time) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+func parseTimeQuery(c *fiber.Ctx, key string, fallback time.Time) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func shuttleErrorResponse(c *fiber.Ctx, err error, fallbackMessage string) error {
+	if customErr, ok := err.(*customErrors.CustomError); ok {
+		return utils.ErrorResponse(c, customErr.StatusCode, strings.ToUpper(string(customErr.Message[0]))+customErr.Message[1:], nil)
+	}
+	log.Println(fallbackMessage+":", err)
+	return utils.InternalServerErrorResponse(c, fallbackMessage, nil)
+}
@@ -28,6 +28,7 @@ type UserHandlerInterface interface {
 	GetAllSuperAdmin(c *fiber.Ctx) error
 	GetAllSchoolAdmin(c *fiber.Ctx) error
 	GetAllPermittedDriver(c *fiber.Ctx) error
+	GetOnlineDrivers(c *fiber.Ctx) error
 
 	GetSpecSuperAdmin(c *fiber.Ctx) error
 	GetSpecSchoolAdmin(c *fiber.Ctx) error
@@ -367,6 +368,21 @@ func (handler *userHandler) GetSpecPermittedDriver(c *fiber.Ctx) error {
 	return utils.SuccessResponse(c, "User fetched successfully", user)
 }
 
+func (handler *userHandler) GetOnlineDrivers(c *fiber.Ctx) error {
+	schoolUUID, ok := c.Locals("schoolUUID").(string)
+	if !ok || schoolUUID == "" {
+		return utils.BadRequestResponse(c, "Token is invalid", nil)
+	}
+
+	drivers, err := handler.userService.GetOnlineDriversForPermittedSchool(schoolUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch online drivers", nil)
+		return utils.InternalServerErrorResponse(c, "Something went wrong, please try again later", nil)
+	}
+
+	return utils.SuccessResponse(c, "Online drivers fetched successfully", drivers)
+}
+
 func (handler *userHandler) GetSpecSuperAdmin(c *fiber.Ctx) error {
 	id := c.Params("id")
 	user, err := handler.userService.GetSpecSuperAdmin(id)
@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"errors"
+
 	"shuttle/logger"
+	"shuttle/models/dto"
 	"shuttle/utils"
 	"shuttle/services"
 
@@ -33,63 +36,56 @@ func AuthenticationMiddleware() fiber.Handler {
 			return utils.UnauthorizedResponse(c, "Missing token", nil)
 		}
 
-		const bearerPrefix = "Bearer "
-		if len(token) > len(bearerPrefix) && token[:len(bearerPrefix)] == bearerPrefix {
-			token = token[len(bearerPrefix):]
-		}
-
-		_, exists := utils.InvalidTokens[token]
-		if exists {
-			return utils.UnauthorizedResponse(c, "Invalid token or you have been logged out", nil)
-		}
-
-		claims, err := utils.ValidateToken(token)
+		claims, err := utils.ParseAccessToken(token)
 		if err != nil {
+			if errors.Is(err, utils.ErrTokenRevoked) {
+				return utils.UnauthorizedResponse(c, "Invalid token or you have been logged out", nil)
+			}
 			logger.LogWarn("Invalid token", map[string]interface{}{"error": err.Error()})
 			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
 		}
 
-		userID, ok := claims["sub"].(string)
-		if !ok || userID == "" {
-			logger.LogWarn("User ID is missing or invalid", map[string]interface{}{"claims": claims})
-			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+		c.Locals("userID", claims.UserID)
+		c.Locals("userUUID", claims.UserUUID)
+		c.Locals("role_code", claims.RoleCode)
+		c.Locals("user_name", claims.Username)
+		c.Locals("sessionID", claims.SessionID)
+		if claims.ActorUUID != "" {
+			c.Locals("actorUUID", claims.ActorUUID)
+			c.Locals("actorName", claims.ActorName)
+		} else {
+			// A super admin looking around as the user doesn't make the user online
+			utils.TouchPresence(claims.UserUUID)
 		}
 
-		userUUID, ok := claims["user_uuid"].(string)
-		if !ok || userUUID == "" {
-			logger.LogWarn("User UUID is missing or invalid", map[string]interface{}{"claims": claims})
-			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
-		}
+		return c.Next()
+	}
+}
 
-		role_code, ok := claims["role_code"].(string)
+func AuthorizationMiddleware(allowedRoles []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role_code, ok := c.Locals("role_code").(string)
 		if !ok || role_code == "" {
-			logger.LogWarn("Role code is missing or invalid", map[string]interface{}{"claims": claims})
-			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+			return utils.UnauthorizedResponse(c, "Role code is missing or invalid", nil)
 		}
 
-		user_name, ok := claims["user_name"].(string)
-		if !ok || user_name == "" {
-			logger.LogWarn("User name is missing or invalid", map[string]interface{}{"claims": claims})
-			return utils.UnauthorizedResponse(c, "Token is invalid", nil)
+		if !contains(allowedRoles, role_code) {
+			return utils.ForbiddenResponse(c, "You don't have permission to access this resource", nil)
 		}
 
-		c.Locals("userID", userID)
-		c.Locals("userUUID", userUUID)
-		c.Locals("role_code", role_code)
-		c.Locals("user_name", user_name)
-
 		return c.Next()
 	}
 }
 
-func AuthorizationMiddleware(allowedRoles []string) fiber.Handler {
+// Checks the role of the logged in user against the role permissions stored in the database
+func PermissionMiddleware(service services.PermissionServiceInterface, permission string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		role_code, ok := c.Locals("role_code").(string)
 		if !ok || role_code == "" {
 			return utils.UnauthorizedResponse(c, "Role code is missing or invalid", nil)
 		}
 
-		if !contains(allowedRoles, role_code) {
+		if !service.HasPermission(role_code, permission) {
 			return utils.ForbiddenResponse(c, "You don't have permission to access this resource", nil)
 		}
 
@@ -97,6 +93,52 @@ func AuthorizationMiddleware(allowedRoles []string) fiber.Handler {
 	}
 }
 
+// Records every write made with an impersonation token, reads are left out
+func ImpersonationAuditMiddleware(auditService services.AuditServiceInterface) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		actorUUID, ok := c.Locals("actorUUID").(string)
+		if !ok || actorUUID == "" || c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead || c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if fiberErr, ok := err.(*fiber.Error); ok {
+			status = fiberErr.Code
+		}
+
+		actorName, _ := c.Locals("actorName").(string)
+		userUUID, _ := c.Locals("userUUID").(string)
+		auditService.Record(dto.AuditEntry{
+			ActorUUID:  actorUUID,
+			ActorName:  actorName,
+			Action:     services.AuditActionImpersonatedRequest,
+			TargetType: "user",
+			TargetID:   userUUID,
+			IPAddress:  c.IP(),
+			Details: map[string]interface{}{
+				"method": c.Method(),
+				"path":   c.Path(),
+				"status": status,
+			},
+		})
+
+		return err
+	}
+}
+
+// For actions only the account owner may take, like changing the password
+func DenyImpersonationMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if actorUUID, ok := c.Locals("actorUUID").(string); ok && actorUUID != "" {
+			return utils.ForbiddenResponse(c, "This action is not allowed while impersonating a user", nil)
+		}
+
+		return c.Next()
+	}
+}
+
 func contains(slice []string, item string) bool {
 	for _, a := range slice {
 		if a == item {
@@ -0,0 +1,11 @@
+package dto
+
+type AuditEntry struct {
+	ActorUUID  string
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   string
+	IPAddress  string
+	Details    map[string]interface{}
+}
@@ -1,8 +1,26 @@
 package dto
 
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
+	Email      string `json:"email" validate:"required,email"`
+	Password   string `json:"password" validate:"required"`
+	DeviceName string `json:"device_name"`
+}
+
+// Where a session was started or last refreshed from
+type SessionDevice struct {
+	DeviceName string
+	IPAddress  string
+	UserAgent  string
+}
+
+type SessionResponse struct {
+	SessionUUID string  `json:"session_uuid"`
+	DeviceName  *string `json:"device_name"`
+	IPAddress   *string `json:"ip_address"`
+	UserAgent   *string `json:"user_agent"`
+	CreatedAt   string  `json:"created_at"`
+	LastUsedAt  string  `json:"last_used_at"`
+	Current     bool    `json:"current"`
 }
 
 type UserDataOnLoginDTO struct {
@@ -12,3 +30,27 @@ type UserDataOnLoginDTO struct {
 	RoleCode  string `json:"user_role_code"`
 	Password  string `json:"user_password"`
 }
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,password,nefield=CurrentPassword"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,password"`
+}
+
+type OTPRequest struct {
+	Phone string `json:"phone" validate:"required,phone"`
+}
+
+type OTPVerifyRequest struct {
+	Phone      string `json:"phone" validate:"required,phone"`
+	Code       string `json:"code" validate:"required,len=6,numeric"`
+	DeviceName string `json:"device_name"`
+}
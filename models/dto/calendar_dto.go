@@ -0,0 +1,57 @@
+package dto
+
+type SchoolCalendarWeekdaysRequestDTO struct {
+	OperatingWeekdays []int `json:"operating_weekdays" validate:"required,min=1,max=7,dive,min=1,max=7"`
+}
+
+type SchoolTermRequestDTO struct {
+	Name      string `json:"term_name" validate:"required,max=255"`
+	StartDate string `json:"term_start_date" validate:"required,datetime=2006-01-02"`
+	EndDate   string `json:"term_end_date" validate:"required,datetime=2006-01-02"`
+}
+
+type SchoolClosureRequestDTO struct {
+	Name      string `json:"closure_name" validate:"required,max=255"`
+	Type      string `json:"closure_type" validate:"omitempty,oneof=holiday closure"`
+	StartDate string `json:"closure_start_date" validate:"required,datetime=2006-01-02"`
+	EndDate   string `json:"closure_end_date" validate:"omitempty,datetime=2006-01-02"` // same day as the start when empty
+}
+
+type SchoolTermResponseDTO struct {
+	TermUUID  string `json:"term_uuid"`
+	Name      string `json:"term_name"`
+	StartDate string `json:"term_start_date"`
+	EndDate   string `json:"term_end_date"`
+}
+
+type SchoolClosureResponseDTO struct {
+	ClosureUUID string `json:"closure_uuid"`
+	Name        string `json:"closure_name"`
+	Type        string `json:"closure_type"`
+	StartDate   string `json:"closure_start_date"`
+	EndDate     string `json:"closure_end_date"`
+}
+
+type SchoolCalendarResponseDTO struct {
+	OperatingWeekdays []int                      `json:"operating_weekdays"`
+	Terms             []SchoolTermResponseDTO    `json:"terms"`
+	Closures          []SchoolClosureResponseDTO `json:"closures"`
+}
+
+type CalendarImportResponseDTO struct {
+	Terms    int `json:"terms"`
+	Closures int `json:"closures"`
+	Skipped  int `json:"skipped"`
+}
+
+// The next time a child is picked up, at home in the morning or at school in the afternoon
+type NextPickupResponseDTO struct {
+	StudentUUID      string  `json:"student_uuid"`
+	StudentFirstName string  `json:"student_first_name"`
+	StudentLastName  string  `json:"student_last_name"`
+	RouteNameUUID    *string `json:"route_name_uuid"`
+	RouteName        *string `json:"route_name"`
+	Direction        *string `json:"direction"`
+	Date             *string `json:"date"`
+	DepartureTime    *string `json:"departure_time"`
+}
@@ -0,0 +1,11 @@
+package dto
+
+type DeviceRequest struct {
+	DeviceToken string `json:"device_token" validate:"required,max=4096"`
+	Platform    string `json:"platform" validate:"required,oneof=android ios web"`
+	AppVersion  string `json:"app_version" validate:"omitempty,max=50"`
+}
+
+type UnregisterDeviceRequest struct {
+	DeviceToken string `json:"device_token" validate:"required"`
+}
@@ -0,0 +1,15 @@
+package dto
+
+type PermissionResponse struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+type RolePermissionsResponse struct {
+	RoleCode    string   `json:"role_code"`
+	Permissions []string `json:"permissions"`
+}
+
+type RolePermissionsRequest struct {
+	Permissions []string `json:"permissions" validate:"required"`
+}
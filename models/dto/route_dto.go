@@ -13,11 +13,13 @@ type StudentDTO struct {
 	StudentLastName  	string `json:"student_last_name"`
 	StudentStatus	  	string `json:"student_status"`
 	StudentOrder 		string  `json:"student_order"`
+	AfternoonStudentOrder string `json:"afternoon_student_order"`
 }
 
 type StudentReqDTO struct {
 	StudentUUID 		uuid.UUID `json:"student_uuid"`
 	StudentOrder 		string  `json:"student_order"`
+	AfternoonStudentOrder string `json:"afternoon_student_order"` // reverse of the morning order when empty
 }
 
 type RouteAssignmentResponseDTO struct {
@@ -33,6 +35,8 @@ type RoutesResponseDTO struct {
 	RouteNameUUID     string                     `json:"route_name_uuid"`
 	RouteName         string                     `json:"route_name"`
 	RouteDescription  string                     `json:"route_description"`
+	MorningDepartureTime   string               `json:"morning_departure_time"`
+	AfternoonDepartureTime string               `json:"afternoon_departure_time"`
 	CreatedAt         string                    `json:"created_at,omitempty"`
 	CreatedBy         string                    `json:"created_by,omitempty"`
 	UpdatedAt         string                    `json:"updated_at,omitempty"`
@@ -44,6 +48,8 @@ type RoutesRequestDTO struct {
 	RouteNameUUID    uuid.UUID                   `json:"route_name_uuid"`
 	RouteName        string                     `json:"route_name" validate:"required"`
 	RouteDescription string                     `json:"route_description" validate:"required"`
+	MorningDepartureTime   string               `json:"morning_departure_time" validate:"omitempty,datetime=15:04"`
+	AfternoonDepartureTime string               `json:"afternoon_departure_time" validate:"omitempty,datetime=15:04"`
 	RouteAssignment  []RouteAssignmentRequestDTO `json:"route_assignment"`
 }
 
@@ -66,4 +72,87 @@ type RouteResponseByDriverDTO struct {
 	ShuttleStatus      sql.NullString `db:"shuttle_status" json:"shuttle_status"`
 	SchoolName         string         `json:"school_name,omitempty" db:"school_name"`
 	SchoolPoint        string         `json:"school_point,omitempty" db:"school_point"`
-}
\ No newline at end of file
+	RoutePointUUID     *string        `json:"route_point_uuid" db:"route_point_uuid"`
+	RoutePointName     *string        `json:"route_point_name" db:"route_point_name"`
+	RoutePointOrder    *int           `json:"route_point_order" db:"route_point_order"`
+	RoutePointLatitude  *float64      `json:"-" db:"route_point_latitude"`
+	RoutePointLongitude *float64      `json:"-" db:"route_point_longitude"`
+}
+
+type OptimizedStudentDTO struct {
+	StudentUUID      string `json:"student_uuid"`
+	StudentFirstName string `json:"student_first_name"`
+	StudentLastName  string `json:"student_last_name"`
+	PreviousOrder    int    `json:"previous_order"`
+	StudentOrder     int    `json:"student_order"`
+}
+
+type DriverRouteOptimizationDTO struct {
+	DriverUUID        string                `json:"driver_uuid"`
+	DistanceBeforeKm  float64               `json:"distance_before_km"`
+	DistanceAfterKm   float64               `json:"distance_after_km"`
+	Students          []OptimizedStudentDTO `json:"students"`
+	UnlocatedStudents []string              `json:"unlocated_students"`
+}
+
+type RouteOptimizationResponseDTO struct {
+	RouteNameUUID string                       `json:"route_name_uuid"`
+	Direction     string                       `json:"direction"`
+	DryRun        bool                         `json:"dry_run"`
+	Assignments   []DriverRouteOptimizationDTO `json:"assignments"`
+}
+
+/////////// ROUTE POINTS //////////////////////
+type RoutePointRequestDTO struct {
+	Name      string   `json:"route_point_name" validate:"required,max=100"`
+	Latitude  *float64 `json:"route_point_latitude" validate:"required,latitude"`
+	Longitude *float64 `json:"route_point_longitude" validate:"required,longitude"`
+	Order     int      `json:"route_point_order" validate:"omitempty,min=1"`
+	Direction string   `json:"route_point_direction" validate:"omitempty,oneof=morning afternoon"` // defaults to morning, fixed once the stop exists
+}
+
+type RoutePointOrderRequestDTO struct {
+	Direction       string      `json:"route_point_direction" validate:"omitempty,oneof=morning afternoon"`
+	RoutePointUUIDs []uuid.UUID `json:"route_point_uuids" validate:"required,min=1"`
+}
+
+type RoutePointStudentsRequestDTO struct {
+	StudentUUIDs []uuid.UUID `json:"student_uuids" validate:"required"`
+}
+
+type RoutePointStudentDTO struct {
+	StudentUUID      string `json:"student_uuid"`
+	StudentFirstName string `json:"student_first_name"`
+	StudentLastName  string `json:"student_last_name"`
+}
+
+type RoutePointResponseDTO struct {
+	RoutePointUUID string                 `json:"route_point_uuid"`
+	Direction      string                 `json:"route_point_direction"`
+	Name           string                 `json:"route_point_name"`
+	Order          int                    `json:"route_point_order"`
+	Latitude       float64                `json:"route_point_latitude"`
+	Longitude      float64                `json:"route_point_longitude"`
+	Students       []RoutePointStudentDTO `json:"students"`
+}
+
+// A stop of the driver's route with the students to collect there
+type DriverRouteStopDTO struct {
+	RoutePointUUID string                     `json:"route_point_uuid"`
+	Name           string                     `json:"route_point_name"`
+	Order          int                        `json:"route_point_order"`
+	Latitude       float64                    `json:"route_point_latitude"`
+	Longitude      float64                    `json:"route_point_longitude"`
+	Students       []RouteResponseByDriverDTO `json:"students"`
+}
+
+// The leg of the route the driver is currently on. Nothing is driven on days the school
+// doesn't operate.
+type DriverRouteResponseDTO struct {
+	OperatingDay  bool                       `json:"operating_day"`
+	ClosedReason  string                     `json:"closed_reason,omitempty"`
+	Direction     string                     `json:"direction"`
+	DepartureTime *string                    `json:"departure_time"`
+	Routes        []RouteResponseByDriverDTO `json:"routes"`
+	Stops         []DriverRouteStopDTO       `json:"stops"`
+}
@@ -7,9 +7,22 @@ type ShuttleRequest struct {
 	Status      string `json:"status" validate:"required"`
 }
 
+type ShuttleStatusRequest struct {
+	Status    string   `json:"status" validate:"required"`
+	Latitude  *float64 `json:"latitude" validate:"omitempty,latitude"`
+	Longitude *float64 `json:"longitude" validate:"omitempty,longitude"`
+}
+
+type ShuttleStudentEventRequest struct {
+	StudentUUID string   `json:"student_uuid"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+}
+
 type ShuttleResponse struct {
 	StudentUUID     string `db:"student_uuid" json:"student_uuid"`
 	ShuttleUUID     string `db:"shuttle_uuid" json:"shuttle_uuid"`
+	DriverUUID      string `db:"driver_uuid" json:"-"`
 	StudentFirstName string `db:"student_first_name" json:"student_first_name"`
 	StudentLastName string `db:"student_last_name" json:"student_last_name"`
 	ParentUUID      string `db:"parent_uuid" json:"parent_uuid"`
@@ -18,6 +31,7 @@ type ShuttleResponse struct {
 	ShuttleStatus   string `db:"shuttle_status" json:"shuttle_status"`
 	CreatedAt       string `db:"created_at" json:"created_at"`
 	CurrentDate     string `db:"current_date" json:"current_date"`
+	ETA             *StudentETAResponse `db:"-" json:"eta"`
 }
 
 type ShuttleAllResponse struct {
@@ -33,6 +47,11 @@ type ShuttleAllResponse struct {
 	SchoolName       string         `db:"school_name" json:"school_name"`
 	CreatedAt        string         `db:"created_at" json:"created_at"`
 	UpdatedAt        sql.NullString `db:"updated_at" json:"updated_at"`
+	// Pickups and drop-offs of the day from the status history, nil until they happened
+	PickedUpToSchool   *ShuttleTimelineEvent `db:"-" json:"picked_up_to_school"`
+	DroppedOffAtSchool *ShuttleTimelineEvent `db:"-" json:"dropped_off_at_school"`
+	PickedUpFromSchool *ShuttleTimelineEvent `db:"-" json:"picked_up_from_school"`
+	DroppedOffAtHome   *ShuttleTimelineEvent `db:"-" json:"dropped_off_at_home"`
 }
 
 type ShuttleSpecResponse struct {
@@ -58,4 +77,60 @@ type ShuttleSpecResponse struct {
 	ShuttleStatus      string `db:"shuttle_status" json:"shuttle_status"`
 	CreatedAt          string `db:"created_at" json:"created_at"`
 	CurrentDate        string `db:"current_date" json:"current_date"`
+	AllowedNextStatuses []string `db:"-" json:"allowed_next_statuses"`
+}
+
+type ShuttleLocationResponse struct {
+	Latitude   float64  `json:"latitude"`
+	Longitude  float64  `json:"longitude"`
+	Speed      *float64 `json:"speed"`
+	Heading    *float64 `json:"heading"`
+	RecordedAt string   `json:"recorded_at"`
+}
+
+type ShuttleTrailResponse struct {
+	ShuttleUUID string                    `json:"shuttle_uuid"`
+	From        string                    `json:"from"`
+	To          string                    `json:"to"`
+	Points      []ShuttleLocationResponse `json:"points"`
+}
+
+// Target is where the driver is expected next for this student: pickup, school or home
+type StudentETAResponse struct {
+	ShuttleUUID        string  `json:"shuttle_uuid"`
+	StudentUUID        string  `json:"student_uuid"`
+	ShuttleStatus      string  `json:"shuttle_status"`
+	Target             string  `json:"target"`
+	ETASeconds         int64   `json:"eta_seconds"`
+	DistanceKm         float64 `json:"distance_km"`
+	ArrivalAt          string  `json:"arrival_at"`
+	LocationAgeSeconds int64   `json:"location_age_seconds"`
+}
+
+type ShuttleLastLocationResponse struct {
+	ShuttleUUID string `json:"shuttle_uuid"`
+	ShuttleLocationResponse
+	AgeSeconds int64 `json:"age_seconds"`
+}
+
+type ShuttleTimelineEvent struct {
+	ShuttleUUID string   `json:"shuttle_uuid"`
+	FromStatus  *string  `json:"from_status"`
+	Status      string   `json:"status"`
+	DriverUUID  *string  `json:"driver_uuid"`
+	ChangedBy   *string  `json:"changed_by"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	ChangedAt   string   `json:"changed_at"`
+}
+
+type ShuttleTimelineResponse struct {
+	ShuttleUUID string                 `json:"shuttle_uuid"`
+	Events      []ShuttleTimelineEvent `json:"events"`
+}
+
+type StudentTimelineResponse struct {
+	StudentUUID string                 `json:"student_uuid"`
+	Date        string                 `json:"date"`
+	Events      []ShuttleTimelineEvent `json:"events"`
 }
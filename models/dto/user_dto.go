@@ -18,7 +18,7 @@ const (
 type UserRequestsDTO struct {
 	Username  string          `json:"username" validate:"required,username,min=5,max=30"`
 	Email     string          `json:"email" validate:"required,email"`
-	Password  string          `json:"password" validate:"required,min=8"`
+	Password  string          `json:"password" validate:"required,password"`
 	Role      Role            `json:"role" validate:"required,role"`
 	RoleCode  string          `json:"role_code"`
 	Picture   string          `json:"picture"`
@@ -97,3 +97,14 @@ type DriverDetailsResponseDTO struct {
 	Address       string `json:"user_address,omitempty"`
 	LicenseNumber string `json:"license_number"`
 }
+
+type DriverPresenceResponseDTO struct {
+	UUID        string `json:"user_uuid"`
+	Username    string `json:"user_username"`
+	FirstName   string `json:"user_first_name"`
+	LastName    string `json:"user_last_name"`
+	Phone       string `json:"user_phone"`
+	VehicleUUID string `json:"vehicle_uuid,omitempty"`
+	Status      string `json:"user_status"`
+	LastActive  string `json:"user_last_active"`
+}
@@ -0,0 +1,18 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+)
+
+type AuditLog struct {
+	AuditID    int64          `db:"audit_id"`
+	ActorUUID  sql.NullString `db:"actor_uuid"`
+	ActorName  sql.NullString `db:"actor_name"`
+	Action     string         `db:"action"`
+	TargetType sql.NullString `db:"target_type"`
+	TargetID   sql.NullString `db:"target_id"`
+	Details    sql.NullString `db:"details"` // JSON
+	IPAddress  sql.NullString `db:"ip_address"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
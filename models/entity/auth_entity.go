@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,9 +18,58 @@ type UserDataOnLogin struct {
 type RefreshToken struct {
 	ID           int64     `db:"id"`
 	UserUUID     uuid.UUID `db:"user_uuid"`
+	FamilyUUID   uuid.UUID `db:"family_uuid"` // every token rotated from the same login
 	RefreshToken string    `db:"refresh_token"`
 	IssuedAt     time.Time `db:"issued_at"`
 	ExpiredAt    time.Time `db:"expired_at"`
 	Revoked      bool      `db:"is_revoked"`
+	Used         bool      `db:"is_used"`
 	LastUsedAt   *time.Time `db:"last_used_at"`
+
+	DeviceName       sql.NullString `db:"device_name"`
+	IPAddress        sql.NullString `db:"ip_address"`
+	UserAgent        sql.NullString `db:"user_agent"`
+	SessionCreatedAt time.Time      `db:"session_created_at"` // when the family was started by a login
+}
+
+type RevokedToken struct {
+	JTI       string         `db:"jti"`
+	UserUUID  sql.NullString `db:"user_uuid"`
+	ExpiredAt time.Time      `db:"expired_at"`
+	RevokedAt time.Time      `db:"revoked_at"`
+}
+
+type PasswordResetToken struct {
+	ID        int64        `db:"reset_id"`
+	UserUUID  uuid.UUID    `db:"user_uuid"`
+	TokenHash string       `db:"token_hash"` // sha256 of the token sent by mail, the token itself is never stored
+	ExpiredAt time.Time    `db:"expired_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+// Failed logins for one key, either "email:<address>" or "ip:<address>"
+type LoginAttempt struct {
+	AttemptKey    string       `db:"attempt_key"`
+	FailureCount  int          `db:"failure_count"`
+	LastFailureAt time.Time    `db:"last_failure_at"`
+	BlockedUntil  sql.NullTime `db:"blocked_until"`
+	LockedUntil   sql.NullTime `db:"locked_until"`
+}
+
+type OTPCode struct {
+	ID         int64        `db:"otp_id"`
+	UserUUID   uuid.UUID    `db:"user_uuid"`
+	Phone      string       `db:"phone"`
+	CodeHash   string       `db:"code_hash"`
+	Attempts   int          `db:"attempts"`
+	ExpiredAt  time.Time    `db:"expired_at"`
+	ConsumedAt sql.NullTime `db:"consumed_at"`
+	CreatedAt  time.Time    `db:"created_at"`
+}
+
+type OTPRequest struct {
+	ID          int64     `db:"otp_request_id"`
+	Phone       string    `db:"phone"`
+	RequestedAt time.Time `db:"requested_at"`
 }
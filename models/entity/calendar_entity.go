@@ -0,0 +1,71 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	ClosureTypeHoliday = "holiday"
+	ClosureTypeClosure = "closure" // one-off, e.g. a strike or bad weather
+)
+
+// Schools without a calendar run their routes monday to friday all year
+var DefaultOperatingWeekdays = []int64{1, 2, 3, 4, 5}
+
+type SchoolCalendar struct {
+	SchoolUUID        uuid.UUID      `db:"school_uuid"`
+	OperatingWeekdays pq.Int64Array  `db:"operating_weekdays"` // ISO weekdays, 1 is monday
+	CreatedAt         sql.NullTime   `db:"created_at"`
+	CreatedBy         sql.NullString `db:"created_by"`
+	UpdatedAt         sql.NullTime   `db:"updated_at"`
+	UpdatedBy         sql.NullString `db:"updated_by"`
+}
+
+type SchoolTerm struct {
+	TermID     int64          `db:"term_id"`
+	TermUUID   uuid.UUID      `db:"term_uuid"`
+	SchoolUUID uuid.UUID      `db:"school_uuid"`
+	Name       string         `db:"term_name"`
+	StartDate  time.Time      `db:"term_start_date"`
+	EndDate    time.Time      `db:"term_end_date"` // inclusive
+	SourceUID  sql.NullString `db:"source_uid"`
+	CreatedAt  sql.NullTime   `db:"created_at"`
+	CreatedBy  sql.NullString `db:"created_by"`
+}
+
+type SchoolClosure struct {
+	ClosureID   int64          `db:"closure_id"`
+	ClosureUUID uuid.UUID      `db:"closure_uuid"`
+	SchoolUUID  uuid.UUID      `db:"school_uuid"`
+	Name        string         `db:"closure_name"`
+	Type        string         `db:"closure_type"`
+	StartDate   time.Time      `db:"closure_start_date"`
+	EndDate     time.Time      `db:"closure_end_date"` // inclusive
+	SourceUID   sql.NullString `db:"source_uid"`
+	CreatedAt   sql.NullTime   `db:"created_at"`
+	CreatedBy   sql.NullString `db:"created_by"`
+}
+
+// A route with its departure times, what a schedule export is built from
+type RouteSchedule struct {
+	RouteNameUUID          uuid.UUID      `db:"route_name_uuid"`
+	RouteName              string         `db:"route_name"`
+	MorningDepartureTime   sql.NullString `db:"morning_departure_time"`
+	AfternoonDepartureTime sql.NullString `db:"afternoon_departure_time"`
+}
+
+// A parent's child with the route it rides
+type ChildRouteSchedule struct {
+	StudentUUID            uuid.UUID      `db:"student_uuid"`
+	StudentFirstName       string         `db:"student_first_name"`
+	StudentLastName        string         `db:"student_last_name"`
+	SchoolUUID             uuid.UUID      `db:"school_uuid"`
+	RouteNameUUID          uuid.NullUUID  `db:"route_name_uuid"`
+	RouteName              sql.NullString `db:"route_name"`
+	MorningDepartureTime   sql.NullString `db:"morning_departure_time"`
+	AfternoonDepartureTime sql.NullString `db:"afternoon_departure_time"`
+}
@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserDevice struct {
+	DeviceID       int64          `db:"device_id"`
+	UserUUID       uuid.UUID      `db:"user_uuid"`
+	DeviceToken    string         `db:"device_token"`
+	DevicePlatform string         `db:"device_platform"`
+	AppVersion     sql.NullString `db:"app_version"`
+	LastSeenAt     time.Time      `db:"last_seen_at"`
+	CreatedAt      sql.NullTime   `db:"created_at"`
+	UpdatedAt      sql.NullTime   `db:"updated_at"`
+}
+
+type NotificationDeadLetter struct {
+	DeadLetterID int64          `db:"dead_letter_id"`
+	UserUUID     sql.NullString `db:"user_uuid"`
+	DeviceToken  string         `db:"device_token"`
+	Title        string         `db:"title"`
+	Body         string         `db:"body"`
+	Data         sql.NullString `db:"data"` // JSON
+	Attempts     int            `db:"attempts"`
+	LastError    sql.NullString `db:"last_error"`
+	CreatedAt    time.Time      `db:"created_at"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Permission struct {
+	Code        string    `db:"permission_code"`
+	Description string    `db:"permission_description"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type RolePermission struct {
+	RoleCode       string         `db:"role_code"`
+	PermissionCode string         `db:"permission_code"`
+	CreatedAt      time.Time      `db:"created_at"`
+	CreatedBy      sql.NullString `db:"created_by"`
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type RouteAssignment struct {
@@ -19,10 +20,13 @@ type RouteAssignment struct {
     StudentLastName   string
 	StudentStatus	string
 	StudentOrder	string
+	AfternoonStudentOrder string
 	StudentName		string			`json:"student_name"`
 	SchoolUUID       uuid.UUID      `db:"school_uuid"`
 	RouteName        string         `db:"route_name"`
 	RouteDescription string         `db:"route_description"`
+	MorningDepartureTime   string
+	AfternoonDepartureTime string
 	CreatedAt        sql.NullTime   `db:"created_at"`
 	CreatedBy        sql.NullString `db:"created_by"`
 	UpdatedAt        sql.NullTime   `db:"updated_at"`
@@ -31,16 +35,73 @@ type RouteAssignment struct {
 	DeletedBy        sql.NullString `db:"deleted_by"`
 }
 
+// A route is driven twice a day: from the students' homes to school in the morning
+// and back home in the afternoon, each leg with its own stops and order
+const (
+	RouteDirectionMorning   = "morning"
+	RouteDirectionAfternoon = "afternoon"
+)
+
+var RouteDirections = []string{
+	RouteDirectionMorning,
+	RouteDirectionAfternoon,
+}
+
 type Routes struct {
 	RouteID          		int64          `db:"route_id"`
 	RouteNameUUID           uuid.UUID      `db:"route_name_uuid"`
 	SchoolUUID       		uuid.UUID      `db:"school_uuid"`
 	RouteName      			string         `db:"route_name"`
 	RouteDescription     	string         `db:"route_description"`
+	MorningDepartureTime    sql.NullString `db:"morning_departure_time"`   // HH:MM
+	AfternoonDepartureTime  sql.NullString `db:"afternoon_departure_time"` // HH:MM
 	CreatedAt          		sql.NullTime   `db:"created_at"`
 	CreatedBy           	sql.NullString `db:"created_by"`
 	UpdatedAt           	sql.NullTime   `db:"updated_at"`
 	UpdatedBy           	sql.NullString `db:"updated_by"`
 	DeletedAt           	sql.NullTime   `db:"deleted_at"`
 	DeletedBy           	sql.NullString `db:"deleted_by"`
-}
\ No newline at end of file
+}
+
+// A student's stop on a route, with the points the pickup order is optimized on
+type RouteStop struct {
+	DriverUUID       uuid.UUID      `db:"driver_uuid"`
+	StudentUUID      uuid.UUID      `db:"student_uuid"`
+	StudentFirstName sql.NullString `db:"student_first_name"`
+	StudentLastName  sql.NullString `db:"student_last_name"`
+	StudentOrder     sql.NullInt64  `db:"student_order"`
+	PickupPoint      sql.NullString `db:"student_pickup_point"` // JSON
+	SchoolPoint      sql.NullString `db:"school_point"`         // JSON
+}
+
+type RoutePoint struct {
+	RoutePointID   int64          `db:"route_point_id"`
+	RoutePointUUID uuid.UUID      `db:"route_point_uuid"`
+	RouteNameUUID  uuid.UUID      `db:"route_name_uuid"`
+	Direction      string         `db:"route_point_direction"`
+	Name           string         `db:"route_point_name"`
+	Order          int            `db:"route_point_order"`
+	Latitude       float64        `db:"route_point_latitude"`
+	Longitude      float64        `db:"route_point_longitude"`
+	CreatedAt      sql.NullTime   `db:"created_at"`
+	CreatedBy      sql.NullString `db:"created_by"`
+	UpdatedAt      sql.NullTime   `db:"updated_at"`
+	UpdatedBy      sql.NullString `db:"updated_by"`
+}
+
+type RoutePointStudent struct {
+	RoutePointUUID   uuid.UUID      `db:"route_point_uuid"`
+	StudentUUID      uuid.UUID      `db:"student_uuid"`
+	StudentFirstName sql.NullString `db:"student_first_name"`
+	StudentLastName  sql.NullString `db:"student_last_name"`
+}
+
+// The route a driver is assigned to with today's shuttle statuses of its students,
+// used to tell which leg the driver is on
+type DriverRouteSchedule struct {
+	RouteNameUUID          uuid.UUID      `db:"route_name_uuid"`
+	SchoolUUID             uuid.UUID      `db:"school_uuid"`
+	MorningDepartureTime   sql.NullString `db:"morning_departure_time"`
+	AfternoonDepartureTime sql.NullString `db:"afternoon_departure_time"`
+	ShuttleStatuses        pq.StringArray `db:"shuttle_statuses"`
+}
@@ -2,6 +2,8 @@ package entity
 
 import (
 	"database/sql"
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -18,3 +20,83 @@ type Shuttle struct {
 	DeletedAt    sql.NullTime   `db:"deleted_at"`
 	DeletedBy    sql.NullString `db:"deleted_by"`
 }
+
+type ShuttleAccess struct {
+	ShuttleUUID      uuid.UUID      `db:"shuttle_uuid"`
+	DriverUUID       uuid.UUID      `db:"driver_uuid"`
+	ParentUUID       sql.NullString `db:"parent_uuid"`
+	SchoolUUID       sql.NullString `db:"school_uuid"`
+	StudentFirstName sql.NullString `db:"student_first_name"`
+}
+
+type ShuttleLocation struct {
+	LocationID  int64           `db:"location_id"`
+	ShuttleUUID uuid.UUID       `db:"shuttle_uuid"`
+	DriverUUID  sql.NullString  `db:"driver_uuid"`
+	Latitude    float64         `db:"latitude"`
+	Longitude   float64         `db:"longitude"`
+	Speed       sql.NullFloat64 `db:"speed"`   // km/h
+	Heading     sql.NullFloat64 `db:"heading"` // degrees clockwise from north
+	RecordedAt  time.Time       `db:"recorded_at"`
+}
+
+// Values of the shuttle_status enum, in the order a shuttle goes through them during the day
+const (
+	ShuttleStatusHome                     = "home"
+	ShuttleStatusWaitingToBeTakenToSchool = "waiting_to_be_taken_to_school"
+	ShuttleStatusGoingToSchool            = "going_to_school"
+	ShuttleStatusAtSchool                 = "at_school"
+	ShuttleStatusWaitingToBeTakenToHome   = "waiting_to_be_taken_to_home"
+	ShuttleStatusGoingToHome              = "going_to_home"
+)
+
+var ShuttleStatuses = []string{
+	ShuttleStatusHome,
+	ShuttleStatusWaitingToBeTakenToSchool,
+	ShuttleStatusGoingToSchool,
+	ShuttleStatusAtSchool,
+	ShuttleStatusWaitingToBeTakenToHome,
+	ShuttleStatusGoingToHome,
+}
+
+// Events the driver reports for the student of a shuttle, each one moves the shuttle a step along its leg
+const (
+	ShuttleEventStudentPickedUp   = "student_picked_up"
+	ShuttleEventStudentDroppedOff = "student_dropped_off"
+)
+
+type ShuttleStatusHistory struct {
+	HistoryID     int64           `db:"history_id"`
+	ShuttleUUID   uuid.UUID       `db:"shuttle_uuid"`
+	FromStatus    sql.NullString  `db:"from_status"`
+	ToStatus      string          `db:"to_status"`
+	ChangedByUUID sql.NullString  `db:"changed_by_uuid"`
+	ChangedBy     sql.NullString  `db:"changed_by"`
+	Latitude      sql.NullFloat64 `db:"latitude"`
+	Longitude     sql.NullFloat64 `db:"longitude"`
+	ChangedAt     time.Time       `db:"changed_at"`
+}
+
+type StudentAccess struct {
+	StudentUUID uuid.UUID      `db:"student_uuid"`
+	ParentUUID  sql.NullString `db:"parent_uuid"`
+	SchoolUUID  sql.NullString `db:"school_uuid"`
+}
+
+// A student of the driver's trip today that still has to be picked up or dropped off,
+// with the stop of each leg when the route has one
+type TripStop struct {
+	ShuttleUUID           uuid.UUID       `db:"shuttle_uuid"`
+	StudentUUID           uuid.UUID       `db:"student_uuid"`
+	Status                string          `db:"status"`
+	StudentPickupPoint    sql.NullString  `db:"student_pickup_point"` // JSON
+	SchoolPoint           sql.NullString  `db:"school_point"`         // JSON
+	StudentOrder          sql.NullInt64   `db:"student_order"`
+	AfternoonStudentOrder sql.NullInt64   `db:"afternoon_student_order"`
+	MorningPointOrder     sql.NullInt64   `db:"morning_point_order"`
+	MorningLatitude       sql.NullFloat64 `db:"morning_latitude"`
+	MorningLongitude      sql.NullFloat64 `db:"morning_longitude"`
+	AfternoonPointOrder   sql.NullInt64   `db:"afternoon_point_order"`
+	AfternoonLatitude     sql.NullFloat64 `db:"afternoon_latitude"`
+	AfternoonLongitude    sql.NullFloat64 `db:"afternoon_longitude"`
+}
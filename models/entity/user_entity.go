@@ -82,3 +82,14 @@ type DriverDetails struct {
 	Address       string     `db:"user_address"`
 	LicenseNumber string     `db:"user_license_number"`
 }
+
+type DriverPresence struct {
+	UserUUID    uuid.UUID      `db:"user_uuid"`
+	Username    string         `db:"user_username"`
+	Status      string         `db:"user_status"`
+	LastActive  sql.NullTime   `db:"user_last_active"`
+	FirstName   sql.NullString `db:"user_first_name"`
+	LastName    sql.NullString `db:"user_last_name"`
+	Phone       sql.NullString `db:"user_phone"`
+	VehicleUUID sql.NullString `db:"vehicle_uuid"`
+}
@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"shuttle/models/entity"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type AuditRepositoryInterface interface {
+	SaveAuditLog(auditLog entity.AuditLog) error
+}
+
+type AuditRepository struct {
+	DB *sqlx.DB
+}
+
+func NewAuditRepository(DB *sqlx.DB) AuditRepositoryInterface {
+	return &AuditRepository{
+		DB: DB,
+	}
+}
+
+func (r *AuditRepository) SaveAuditLog(auditLog entity.AuditLog) error {
+	query := `
+		INSERT INTO audit_logs (audit_id, actor_uuid, actor_name, action, target_type, target_id, details, ip_address, created_at)
+		VALUES (:audit_id, :actor_uuid, :actor_name, :action, :target_type, :target_id, :details, :ip_address, :created_at)`
+
+	_, err := r.DB.NamedExec(query, auditLog)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -2,18 +2,44 @@ package repositories
 
 import (
 	"context"
+	"database/sql"
 	"shuttle/models/entity"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 type AuthRepositoryInterface interface {
 	Login(email string) (entity.UserDataOnLogin, error)
-	CheckRefreshTokenData(userUUID, token string) (entity.RefreshToken, error)
-	DeleteRefreshToken(ctx context.Context, userUUID string) error
+	BeginTransaction() (*sqlx.Tx, error)
+	FetchRefreshTokenForUpdate(tx *sqlx.Tx, userUUID, token string) (entity.RefreshToken, error)
+	SaveRotatedRefreshToken(tx *sqlx.Tx, refreshToken entity.RefreshToken) error
+	MarkRefreshTokenUsed(tx *sqlx.Tx, id int64) error
+	RevokeRefreshTokenFamily(tx *sqlx.Tx, familyUUID uuid.UUID) error
+	FetchActiveSessions(userUUID string) ([]entity.RefreshToken, error)
+	RevokeUserSession(userUUID string, familyUUID uuid.UUID) error
+	RevokeAllUserSessions(userUUID string) ([]uuid.UUID, error)
+	DeleteSessionRefreshTokens(ctx context.Context, userUUID string, familyUUID uuid.UUID) error
+	FetchUserPassword(userUUID string) (string, error)
+	UpdateUserPassword(tx *sqlx.Tx, userUUID uuid.UUID, hashedPassword, updatedBy string) error
+	RevokeOtherUserSessions(tx *sqlx.Tx, userUUID uuid.UUID, keepFamilyUUID uuid.UUID) ([]uuid.UUID, error)
+	SavePasswordResetToken(resetToken entity.PasswordResetToken) error
+	FetchPasswordResetTokenForUpdate(tx *sqlx.Tx, tokenHash string) (entity.PasswordResetToken, error)
+	MarkPasswordResetTokensUsed(tx *sqlx.Tx, userUUID uuid.UUID) error
+	FetchLoginAttempts(keys []string) ([]entity.LoginAttempt, error)
+	RecordLoginFailure(key string, window time.Duration) (int, error)
+	UpdateLoginBlock(key string, blockedUntil, lockedUntil sql.NullTime) (bool, error)
+	DeleteLoginAttempt(key string) error
+	FetchLoginUsersByPhone(phone string) ([]entity.UserDataOnLogin, error)
+	CountOTPRequestsSince(phone string, since time.Time) (int, error)
+	SaveOTPRequest(request entity.OTPRequest) error
+	SaveOTPCode(otp entity.OTPCode) error
+	FetchLatestOTPCodeForUpdate(tx *sqlx.Tx, phone string) (entity.OTPCode, error)
+	IncrementOTPAttempts(tx *sqlx.Tx, id int64) error
+	ConsumeOTPCode(tx *sqlx.Tx, id int64) error
+	FetchLoginUser(userUUID uuid.UUID) (entity.UserDataOnLogin, error)
 	UpdateUserStatus(userUUID, status string, lastActive time.Time) error
-	UpdateRefreshToken(userUUID, refreshToken string) (time.Time, error)
 }
 
 type authRepository struct {
@@ -44,15 +70,27 @@ func (r *authRepository) Login(email string) (entity.UserDataOnLogin, error) {
 	return user, nil
 }
 
-func (r *authRepository) CheckRefreshTokenData(userUUID, token string) (entity.RefreshToken, error) {
+func (r *authRepository) BeginTransaction() (*sqlx.Tx, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// Lock the token row so two refreshes with the same token can't both rotate it
+func (r *authRepository) FetchRefreshTokenForUpdate(tx *sqlx.Tx, userUUID, token string) (entity.RefreshToken, error) {
 	query := `
-		SELECT refresh_token, expired_at, is_revoked, last_used_at
-		FROM refresh_tokens 
+		SELECT id, user_uuid, family_uuid, refresh_token, issued_at, expired_at, is_revoked, is_used, last_used_at,
+			device_name, ip_address, user_agent, session_created_at
+		FROM refresh_tokens
 		WHERE user_uuid = $1 AND refresh_token = $2
+		FOR UPDATE
 	`
 
 	var tokenData entity.RefreshToken
-	err := r.DB.Get(&tokenData, query, userUUID, token)
+	err := tx.Get(&tokenData, query, userUUID, token)
 	if err != nil {
 		return tokenData, err
 	}
@@ -60,14 +98,69 @@ func (r *authRepository) CheckRefreshTokenData(userUUID, token string) (entity.R
 	return tokenData, nil
 }
 
+// A login starts a new token family, which is one session of the user
 func SaveRefreshToken(db sqlx.DB, refreshToken entity.RefreshToken) error {
 	query := `
-		INSERT INTO refresh_tokens (id, user_uuid, refresh_token, expired_at)
+		INSERT INTO refresh_tokens (id, user_uuid, family_uuid, refresh_token, expired_at, device_name, ip_address, user_agent, session_created_at)
+		VALUES (:id, :user_uuid, :family_uuid, :refresh_token, :expired_at, :device_name, :ip_address, :user_agent, :session_created_at)
+	`
+	_, err := db.NamedExec(query, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *authRepository) SaveRotatedRefreshToken(tx *sqlx.Tx, refreshToken entity.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, user_uuid, family_uuid, refresh_token, expired_at, device_name, ip_address, user_agent, session_created_at, last_used_at)
+		VALUES (:id, :user_uuid, :family_uuid, :refresh_token, :expired_at, :device_name, :ip_address, :user_agent, :session_created_at, :last_used_at)
+	`
+	_, err := tx.NamedExec(query, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *authRepository) MarkRefreshTokenUsed(tx *sqlx.Tx, id int64) error {
+	query := `
+		UPDATE refresh_tokens
+		SET is_used = true, last_used_at = NOW()
+		WHERE id = $1
+	`
+	_, err := tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *authRepository) RevokeRefreshTokenFamily(tx *sqlx.Tx, familyUUID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET is_revoked = true
+		WHERE family_uuid = $1
+	`
+	_, err := tx.Exec(query, familyUUID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Revoking the same token twice keeps the first revocation
+func SaveRevokedToken(db sqlx.DB, revokedToken entity.RevokedToken) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, user_uuid, expired_at, revoked_at)
 		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (user_uuid)
-		DO UPDATE SET refresh_token = $3, issued_at = CURRENT_TIMESTAMP, expired_at = $4, is_revoked = false 
+		ON CONFLICT (jti) DO NOTHING
 	`
-	_, err := db.Exec(query, refreshToken.ID, refreshToken.UserUUID, refreshToken.RefreshToken, refreshToken.ExpiredAt)
+	_, err := db.Exec(query, revokedToken.JTI, revokedToken.UserUUID, revokedToken.ExpiredAt, revokedToken.RevokedAt)
 	if err != nil {
 		return err
 	}
@@ -75,13 +168,124 @@ func SaveRefreshToken(db sqlx.DB, refreshToken entity.RefreshToken) error {
 	return nil
 }
 
-func (r *authRepository) DeleteRefreshToken(ctx context.Context, userUUID string) error {
+// Tokens revoked at or after `since` that have not expired yet
+func FetchRevokedTokensSince(db sqlx.DB, since time.Time) ([]entity.RevokedToken, error) {
+	query := `
+		SELECT jti, user_uuid, expired_at, revoked_at
+		FROM revoked_tokens
+		WHERE revoked_at >= $1 AND expired_at > NOW()
+	`
+
+	var revokedTokens []entity.RevokedToken
+	if err := db.Select(&revokedTokens, query, since); err != nil {
+		return nil, err
+	}
+
+	return revokedTokens, nil
+}
+
+func DeleteExpiredRevokedTokens(db sqlx.DB) (int64, error) {
+	query := `DELETE FROM revoked_tokens WHERE expired_at <= NOW()`
+
+	result, err := db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// The only unused, unrevoked token of each family stands for the session
+func (r *authRepository) FetchActiveSessions(userUUID string) ([]entity.RefreshToken, error) {
+	query := `
+		SELECT id, user_uuid, family_uuid, refresh_token, issued_at, expired_at, is_revoked, is_used, last_used_at,
+			device_name, ip_address, user_agent, session_created_at
+		FROM refresh_tokens
+		WHERE user_uuid = $1 AND is_used = false AND is_revoked = false AND expired_at > NOW()
+		ORDER BY COALESCE(last_used_at, issued_at) DESC
+	`
+
+	var sessions []entity.RefreshToken
+	if err := r.DB.Select(&sessions, query, userUUID); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *authRepository) RevokeUserSession(userUUID string, familyUUID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET is_revoked = true
+		WHERE user_uuid = $1 AND family_uuid = $2 AND is_revoked = false
+	`
+
+	result, err := r.DB.Exec(query, userUUID, familyUUID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *authRepository) RevokeAllUserSessions(userUUID string) ([]uuid.UUID, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET is_revoked = true
+		WHERE user_uuid = $1 AND is_revoked = false
+		RETURNING family_uuid
+	`
+
+	var families []uuid.UUID
+	if err := r.DB.Select(&families, query, userUUID); err != nil {
+		return nil, err
+	}
+
+	return uniqueFamilies(families), nil
+}
+
+// Revoke every session of the user except keepFamilyUUID (uuid.Nil keeps none)
+func (r *authRepository) RevokeOtherUserSessions(tx *sqlx.Tx, userUUID uuid.UUID, keepFamilyUUID uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET is_revoked = true
+		WHERE user_uuid = $1 AND family_uuid <> $2 AND is_revoked = false
+		RETURNING family_uuid
+	`
+
+	var families []uuid.UUID
+	if err := tx.Select(&families, query, userUUID, keepFamilyUUID); err != nil {
+		return nil, err
+	}
+
+	return uniqueFamilies(families), nil
+}
+
+func uniqueFamilies(families []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]struct{}, len(families))
+	unique := families[:0]
+	for _, family := range families {
+		if _, exists := seen[family]; !exists {
+			seen[family] = struct{}{}
+			unique = append(unique, family)
+		}
+	}
+
+	return unique
+}
+
+func (r *authRepository) DeleteSessionRefreshTokens(ctx context.Context, userUUID string, familyUUID uuid.UUID) error {
 	query := `
 		DELETE FROM refresh_tokens
-		WHERE user_uuid = $1
+		WHERE user_uuid = $1 AND family_uuid = $2
 	`
 
-	_, err := r.DB.ExecContext(ctx, query, userUUID)
+	_, err := r.DB.ExecContext(ctx, query, userUUID, familyUUID)
 	if err != nil {
 		return err
 	}
@@ -89,44 +293,283 @@ func (r *authRepository) DeleteRefreshToken(ctx context.Context, userUUID string
 	return nil
 }
 
-func (r *authRepository) UpdateUserStatus(userUUID, status string, lastActive time.Time) error {
+func (r *authRepository) FetchUserPassword(userUUID string) (string, error) {
+	query := `
+		SELECT user_password
+		FROM users
+		WHERE user_uuid = $1 AND deleted_at IS NULL
+	`
+
+	var password string
+	if err := r.DB.Get(&password, query, userUUID); err != nil {
+		return "", err
+	}
+
+	return password, nil
+}
+
+func (r *authRepository) UpdateUserPassword(tx *sqlx.Tx, userUUID uuid.UUID, hashedPassword, updatedBy string) error {
 	query := `
 		UPDATE users
-		SET user_status = $1, user_last_active = $2
-		WHERE user_uuid = $3
+		SET user_password = $1, updated_at = NOW(), updated_by = $2
+		WHERE user_uuid = $3 AND deleted_at IS NULL
 	`
 
-	_, err := r.DB.Exec(query, status, lastActive, userUUID)
+	result, err := tx.Exec(query, hashedPassword, updatedBy, userUUID)
 	if err != nil {
 		return err
 	}
 
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
-func (r *authRepository) UpdateRefreshToken(userUUID, refreshToken string) (time.Time, error) {
-	var lastUsedAt time.Time
-	
-	querySelect := `
-		SELECT last_used_at 
-		FROM refresh_tokens 
-		WHERE user_uuid = $1 AND refresh_token = $2
+func (r *authRepository) SavePasswordResetToken(resetToken entity.PasswordResetToken) error {
+	query := `
+		INSERT INTO password_reset_tokens (reset_id, user_uuid, token_hash, expired_at, created_at)
+		VALUES (:reset_id, :user_uuid, :token_hash, :expired_at, :created_at)
 	`
-	err := r.DB.QueryRow(querySelect, userUUID, refreshToken).Scan(&lastUsedAt)
+
+	_, err := r.DB.NamedExec(query, resetToken)
 	if err != nil {
-		return time.Time{}, err
+		return err
 	}
 
-	// Query untuk update last_used_at ke NOW()
-	queryUpdate := `
-		UPDATE refresh_tokens
-		SET last_used_at = NOW()
-		WHERE user_uuid = $1 AND refresh_token = $2
+	return nil
+}
+
+func (r *authRepository) FetchPasswordResetTokenForUpdate(tx *sqlx.Tx, tokenHash string) (entity.PasswordResetToken, error) {
+	query := `
+		SELECT reset_id, user_uuid, token_hash, expired_at, used_at, created_at
+		FROM password_reset_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
 	`
-	_, err = r.DB.Exec(queryUpdate, userUUID, refreshToken)
+
+	var resetToken entity.PasswordResetToken
+	if err := tx.Get(&resetToken, query, tokenHash); err != nil {
+		return entity.PasswordResetToken{}, err
+	}
+
+	return resetToken, nil
+}
+
+// Using one reset token invalidates every other token the user requested
+func (r *authRepository) MarkPasswordResetTokensUsed(tx *sqlx.Tx, userUUID uuid.UUID) error {
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = NOW()
+		WHERE user_uuid = $1 AND used_at IS NULL
+	`
+
+	_, err := tx.Exec(query, userUUID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *authRepository) FetchLoginAttempts(keys []string) ([]entity.LoginAttempt, error) {
+	query, args, err := sqlx.In(`
+		SELECT attempt_key, failure_count, last_failure_at, blocked_until, locked_until
+		FROM login_attempts
+		WHERE attempt_key IN (?)
+	`, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var attempts []entity.LoginAttempt
+	if err := r.DB.Select(&attempts, r.DB.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// Count a failed login and return the number of failures in a row. Failures older
+// than the window are forgotten, so the count starts again from one.
+func (r *authRepository) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (attempt_key, failure_count, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (attempt_key) DO UPDATE
+		SET failure_count = CASE
+				WHEN login_attempts.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+				ELSE login_attempts.failure_count + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failure_count
+	`
+
+	var failureCount int
+	if err := r.DB.Get(&failureCount, query, key, window.Seconds()); err != nil {
+		return 0, err
+	}
+
+	return failureCount, nil
+}
+
+// A lock is only ever extended here, unlocking goes through DeleteLoginAttempt
+// Reports whether lockedUntil started a new lock, i.e. the key wasn't locked before
+func (r *authRepository) UpdateLoginBlock(key string, blockedUntil, lockedUntil sql.NullTime) (bool, error) {
+	query := `
+		UPDATE login_attempts a
+		SET blocked_until = $2, locked_until = GREATEST(a.locked_until, $3)
+		FROM (SELECT locked_until FROM login_attempts WHERE attempt_key = $1 FOR UPDATE) previous
+		WHERE a.attempt_key = $1
+		RETURNING $3::TIMESTAMPTZ IS NOT NULL AND (previous.locked_until IS NULL OR previous.locked_until <= NOW())
+	`
+
+	var newlyLocked bool
+	if err := r.DB.Get(&newlyLocked, query, key, blockedUntil, lockedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return newlyLocked, nil
+}
+
+func (r *authRepository) DeleteLoginAttempt(key string) error {
+	query := `DELETE FROM login_attempts WHERE attempt_key = $1`
+
+	_, err := r.DB.Exec(query, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Parents and drivers whose phone matches, ignoring spaces and dashes in the stored number
+func (r *authRepository) FetchLoginUsersByPhone(phone string) ([]entity.UserDataOnLogin, error) {
+	query := `
+		SELECT u.user_id, u.user_uuid, u.user_username, u.user_role_code, u.user_password
+		FROM users u
+		LEFT JOIN parent_details pd ON pd.user_uuid = u.user_uuid AND u.user_role_code = 'P'
+		LEFT JOIN driver_details dd ON dd.user_uuid = u.user_uuid AND u.user_role_code = 'D'
+		WHERE u.deleted_at IS NULL
+			AND u.user_role_code IN ('P', 'D')
+			AND regexp_replace(COALESCE(pd.user_phone, dd.user_phone, ''), '[^0-9+]', '', 'g') = $1
+	`
+
+	var users []entity.UserDataOnLogin
+	if err := r.DB.Select(&users, query, phone); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *authRepository) FetchLoginUser(userUUID uuid.UUID) (entity.UserDataOnLogin, error) {
+	query := `
+		SELECT user_id, user_uuid, user_username, user_role_code, user_password
+		FROM users
+		WHERE user_uuid = $1 AND deleted_at IS NULL
+	`
+
+	var user entity.UserDataOnLogin
+	if err := r.DB.Get(&user, query, userUUID); err != nil {
+		return entity.UserDataOnLogin{}, err
+	}
+
+	return user, nil
+}
+
+func (r *authRepository) CountOTPRequestsSince(phone string, since time.Time) (int, error) {
+	query := `SELECT COUNT(otp_request_id) FROM otp_requests WHERE phone = $1 AND requested_at >= $2`
+
+	var count int
+	if err := r.DB.Get(&count, query, phone, since); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *authRepository) SaveOTPRequest(request entity.OTPRequest) error {
+	query := `
+		INSERT INTO otp_requests (otp_request_id, phone, requested_at)
+		VALUES (:otp_request_id, :phone, :requested_at)
+	`
+
+	_, err := r.DB.NamedExec(query, request)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *authRepository) SaveOTPCode(otp entity.OTPCode) error {
+	query := `
+		INSERT INTO otp_codes (otp_id, user_uuid, phone, code_hash, expired_at, created_at)
+		VALUES (:otp_id, :user_uuid, :phone, :code_hash, :expired_at, :created_at)
+	`
+
+	_, err := r.DB.NamedExec(query, otp)
 	if err != nil {
-		return time.Time{}, err
+		return err
 	}
 
-	return lastUsedAt, nil
-}
\ No newline at end of file
+	return nil
+}
+
+// Only the most recent code of a phone can be used, requesting a new one replaces the old
+func (r *authRepository) FetchLatestOTPCodeForUpdate(tx *sqlx.Tx, phone string) (entity.OTPCode, error) {
+	query := `
+		SELECT otp_id, user_uuid, phone, code_hash, attempts, expired_at, consumed_at, created_at
+		FROM otp_codes
+		WHERE phone = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+		FOR UPDATE
+	`
+
+	var otp entity.OTPCode
+	if err := tx.Get(&otp, query, phone); err != nil {
+		return entity.OTPCode{}, err
+	}
+
+	return otp, nil
+}
+
+func (r *authRepository) IncrementOTPAttempts(tx *sqlx.Tx, id int64) error {
+	_, err := tx.Exec(`UPDATE otp_codes SET attempts = attempts + 1 WHERE otp_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *authRepository) ConsumeOTPCode(tx *sqlx.Tx, id int64) error {
+	_, err := tx.Exec(`UPDATE otp_codes SET consumed_at = NOW() WHERE otp_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *authRepository) UpdateUserStatus(userUUID, status string, lastActive time.Time) error {
+	query := `
+		UPDATE users
+		SET user_status = $1, user_last_active = $2
+		WHERE user_uuid = $3
+	`
+
+	_, err := r.DB.Exec(query, status, lastActive, userUUID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
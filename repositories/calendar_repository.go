@@ -0,0 +1,219 @@
+package repositories
+
+import (
+	"shuttle/models/entity"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type CalendarRepositoryInterface interface {
+	BeginTransaction() (*sqlx.Tx, error)
+	FetchSchoolCalendar(schoolUUID string) (entity.SchoolCalendar, error)
+	SaveOperatingWeekdays(calendar entity.SchoolCalendar) error
+	FetchSchoolTerms(schoolUUID string) ([]entity.SchoolTerm, error)
+	FetchSchoolClosures(schoolUUID string) ([]entity.SchoolClosure, error)
+	SaveSchoolTerm(tx *sqlx.Tx, term entity.SchoolTerm) error
+	SaveSchoolClosure(tx *sqlx.Tx, closure entity.SchoolClosure) error
+	DeleteSchoolTerm(termUUID, schoolUUID string) (bool, error)
+	DeleteSchoolClosure(closureUUID, schoolUUID string) (bool, error)
+	FetchRouteSchedules(schoolUUID, routenameUUID string) ([]entity.RouteSchedule, error)
+	FetchChildRouteSchedules(parentUUID string) ([]entity.ChildRouteSchedule, error)
+}
+
+type CalendarRepository struct {
+	DB *sqlx.DB
+}
+
+func NewCalendarRepository(DB *sqlx.DB) CalendarRepositoryInterface {
+	return &CalendarRepository{
+		DB: DB,
+	}
+}
+
+func (r *CalendarRepository) BeginTransaction() (*sqlx.Tx, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (r *CalendarRepository) FetchSchoolCalendar(schoolUUID string) (entity.SchoolCalendar, error) {
+	query := `
+		SELECT school_uuid, operating_weekdays, created_at, created_by, updated_at, updated_by
+		FROM school_calendars
+		WHERE school_uuid = $1
+	`
+
+	var calendar entity.SchoolCalendar
+	if err := r.DB.Get(&calendar, query, schoolUUID); err != nil {
+		return entity.SchoolCalendar{}, err
+	}
+	return calendar, nil
+}
+
+func (r *CalendarRepository) SaveOperatingWeekdays(calendar entity.SchoolCalendar) error {
+	query := `
+		INSERT INTO school_calendars (school_uuid, operating_weekdays, created_at, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (school_uuid) DO UPDATE
+		SET operating_weekdays = EXCLUDED.operating_weekdays,
+			updated_at = EXCLUDED.created_at,
+			updated_by = EXCLUDED.created_by
+	`
+
+	_, err := r.DB.Exec(query, calendar.SchoolUUID, calendar.OperatingWeekdays, calendar.CreatedAt, calendar.CreatedBy)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *CalendarRepository) FetchSchoolTerms(schoolUUID string) ([]entity.SchoolTerm, error) {
+	query := `
+		SELECT term_id, term_uuid, school_uuid, term_name, term_start_date, term_end_date, source_uid, created_at, created_by
+		FROM school_terms
+		WHERE school_uuid = $1
+		ORDER BY term_start_date ASC
+	`
+
+	var terms []entity.SchoolTerm
+	if err := r.DB.Select(&terms, query, schoolUUID); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+func (r *CalendarRepository) FetchSchoolClosures(schoolUUID string) ([]entity.SchoolClosure, error) {
+	query := `
+		SELECT closure_id, closure_uuid, school_uuid, closure_name, closure_type, closure_start_date, closure_end_date,
+			source_uid, created_at, created_by
+		FROM school_closures
+		WHERE school_uuid = $1
+		ORDER BY closure_start_date ASC
+	`
+
+	var closures []entity.SchoolClosure
+	if err := r.DB.Select(&closures, query, schoolUUID); err != nil {
+		return nil, err
+	}
+	return closures, nil
+}
+
+// Dates are sent as text so the session time zone can't move them to another day.
+// An imported term replaces the one imported earlier from the same event.
+func (r *CalendarRepository) SaveSchoolTerm(tx *sqlx.Tx, term entity.SchoolTerm) error {
+	query := `
+		INSERT INTO school_terms (term_id, term_uuid, school_uuid, term_name, term_start_date, term_end_date,
+			source_uid, created_at, created_by)
+		VALUES ($1, $2, $3, $4, $5::DATE, $6::DATE, $7, $8, $9)
+		ON CONFLICT (school_uuid, source_uid) DO UPDATE
+		SET term_name = EXCLUDED.term_name,
+			term_start_date = EXCLUDED.term_start_date,
+			term_end_date = EXCLUDED.term_end_date,
+			updated_at = EXCLUDED.created_at,
+			updated_by = EXCLUDED.created_by
+	`
+
+	_, err := tx.Exec(query, term.TermID, term.TermUUID, term.SchoolUUID, term.Name,
+		term.StartDate.Format("2006-01-02"), term.EndDate.Format("2006-01-02"),
+		term.SourceUID, term.CreatedAt, term.CreatedBy)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *CalendarRepository) SaveSchoolClosure(tx *sqlx.Tx, closure entity.SchoolClosure) error {
+	query := `
+		INSERT INTO school_closures (closure_id, closure_uuid, school_uuid, closure_name, closure_type,
+			closure_start_date, closure_end_date, source_uid, created_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6::DATE, $7::DATE, $8, $9, $10)
+		ON CONFLICT (school_uuid, source_uid) DO UPDATE
+		SET closure_name = EXCLUDED.closure_name,
+			closure_type = EXCLUDED.closure_type,
+			closure_start_date = EXCLUDED.closure_start_date,
+			closure_end_date = EXCLUDED.closure_end_date,
+			updated_at = EXCLUDED.created_at,
+			updated_by = EXCLUDED.created_by
+	`
+
+	_, err := tx.Exec(query, closure.ClosureID, closure.ClosureUUID, closure.SchoolUUID, closure.Name, closure.Type,
+		closure.StartDate.Format("2006-01-02"), closure.EndDate.Format("2006-01-02"),
+		closure.SourceUID, closure.CreatedAt, closure.CreatedBy)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *CalendarRepository) DeleteSchoolTerm(termUUID, schoolUUID string) (bool, error) {
+	result, err := r.DB.Exec(`DELETE FROM school_terms WHERE term_uuid = $1 AND school_uuid = $2`, termUUID, schoolUUID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *CalendarRepository) DeleteSchoolClosure(closureUUID, schoolUUID string) (bool, error) {
+	result, err := r.DB.Exec(`DELETE FROM school_closures WHERE closure_uuid = $1 AND school_uuid = $2`, closureUUID, schoolUUID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// Every route of the school, or only the given one
+func (r *CalendarRepository) FetchRouteSchedules(schoolUUID, routenameUUID string) ([]entity.RouteSchedule, error) {
+	query := `
+		SELECT
+			route_name_uuid,
+			route_name,
+			TO_CHAR(morning_departure_time, 'HH24:MI') AS morning_departure_time,
+			TO_CHAR(afternoon_departure_time, 'HH24:MI') AS afternoon_departure_time
+		FROM routes
+		WHERE school_uuid = $1 AND ($2::TEXT = '' OR route_name_uuid::TEXT = $2)
+		ORDER BY route_name ASC
+	`
+
+	var schedules []entity.RouteSchedule
+	if err := r.DB.Select(&schedules, query, schoolUUID, routenameUUID); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (r *CalendarRepository) FetchChildRouteSchedules(parentUUID string) ([]entity.ChildRouteSchedule, error) {
+	query := `
+		SELECT
+			s.student_uuid,
+			s.student_first_name,
+			s.student_last_name,
+			s.school_uuid,
+			rt.route_name_uuid,
+			rt.route_name,
+			TO_CHAR(rt.morning_departure_time, 'HH24:MI') AS morning_departure_time,
+			TO_CHAR(rt.afternoon_departure_time, 'HH24:MI') AS afternoon_departure_time
+		FROM students s
+		LEFT JOIN route_assignment ra ON s.student_uuid = ra.student_uuid AND ra.deleted_at IS NULL
+		LEFT JOIN routes rt ON ra.route_name_uuid = rt.route_name_uuid
+		WHERE s.parent_uuid = $1
+		ORDER BY s.student_first_name ASC
+	`
+
+	var children []entity.ChildRouteSchedule
+	if err := r.DB.Select(&children, query, parentUUID); err != nil {
+		return nil, err
+	}
+	return children, nil
+}
@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"database/sql"
+	"shuttle/models/entity"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type NotificationRepositoryInterface interface {
+	SaveUserDevice(device entity.UserDevice) error
+	FetchUserDevices(userUUID uuid.UUID) ([]entity.UserDevice, error)
+	DeleteUserDevice(userUUID uuid.UUID, deviceToken string) error
+	DeleteUserDevices(userUUID uuid.UUID) error
+	DeleteDeviceToken(deviceToken string) error
+	SaveNotificationDeadLetter(deadLetter entity.NotificationDeadLetter) error
+}
+
+type NotificationRepository struct {
+	DB *sqlx.DB
+}
+
+func NewNotificationRepository(DB *sqlx.DB) NotificationRepositoryInterface {
+	return &NotificationRepository{
+		DB: DB,
+	}
+}
+
+// A token belongs to one physical device, so registering it again moves it to the current user
+func (r *NotificationRepository) SaveUserDevice(device entity.UserDevice) error {
+	query := `
+		INSERT INTO user_devices (device_id, user_uuid, device_token, device_platform, app_version, last_seen_at, created_at)
+		VALUES (:device_id, :user_uuid, :device_token, :device_platform, :app_version, :last_seen_at, :created_at)
+		ON CONFLICT (device_token) DO UPDATE
+		SET user_uuid = EXCLUDED.user_uuid,
+			device_platform = EXCLUDED.device_platform,
+			app_version = EXCLUDED.app_version,
+			last_seen_at = EXCLUDED.last_seen_at,
+			updated_at = NOW()`
+
+	_, err := r.DB.NamedExec(query, device)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) FetchUserDevices(userUUID uuid.UUID) ([]entity.UserDevice, error) {
+	query := `
+		SELECT device_id, user_uuid, device_token, device_platform, app_version, last_seen_at, created_at, updated_at
+		FROM user_devices
+		WHERE user_uuid = $1
+	`
+
+	var devices []entity.UserDevice
+	if err := r.DB.Select(&devices, query, userUUID); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+func (r *NotificationRepository) DeleteUserDevice(userUUID uuid.UUID, deviceToken string) error {
+	query := `DELETE FROM user_devices WHERE user_uuid = $1 AND device_token = $2`
+
+	result, err := r.DB.Exec(query, userUUID, deviceToken)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) DeleteUserDevices(userUUID uuid.UUID) error {
+	query := `DELETE FROM user_devices WHERE user_uuid = $1`
+
+	_, err := r.DB.Exec(query, userUUID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) DeleteDeviceToken(deviceToken string) error {
+	query := `DELETE FROM user_devices WHERE device_token = $1`
+
+	_, err := r.DB.Exec(query, deviceToken)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) SaveNotificationDeadLetter(deadLetter entity.NotificationDeadLetter) error {
+	query := `
+		INSERT INTO notification_dead_letters (dead_letter_id, user_uuid, device_token, title, body, data, attempts, last_error, created_at)
+		VALUES (:dead_letter_id, :user_uuid, :device_token, :title, :body, :data, :attempts, :last_error, :created_at)`
+
+	_, err := r.DB.NamedExec(query, deadLetter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
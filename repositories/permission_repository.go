@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"shuttle/models/entity"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PermissionRepositoryInterface interface {
+	BeginTransaction() (*sqlx.Tx, error)
+	SavePermission(permission entity.Permission) error
+	FetchAllPermissions() ([]entity.Permission, error)
+	FetchAllRolePermissions() ([]entity.RolePermission, error)
+	DeleteRolePermissions(tx *sqlx.Tx, roleCode string) error
+	SaveRolePermission(tx *sqlx.Tx, rolePermission entity.RolePermission) error
+}
+
+type PermissionRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPermissionRepository(DB *sqlx.DB) PermissionRepositoryInterface {
+	return &PermissionRepository{
+		DB: DB,
+	}
+}
+
+func (r *PermissionRepository) BeginTransaction() (*sqlx.Tx, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Registers a permission, or refreshes its description when it already exists
+func (r *PermissionRepository) SavePermission(permission entity.Permission) error {
+	query := `
+		INSERT INTO permissions (permission_code, permission_description, created_at)
+		VALUES (:permission_code, :permission_description, :created_at)
+		ON CONFLICT (permission_code) DO UPDATE SET permission_description = EXCLUDED.permission_description`
+
+	_, err := r.DB.NamedExec(query, permission)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PermissionRepository) FetchAllPermissions() ([]entity.Permission, error) {
+	query := `SELECT permission_code, permission_description, created_at FROM permissions ORDER BY permission_code`
+
+	var permissions []entity.Permission
+	if err := r.DB.Select(&permissions, query); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (r *PermissionRepository) FetchAllRolePermissions() ([]entity.RolePermission, error) {
+	query := `
+		SELECT role_code, permission_code, created_at, created_by
+		FROM role_permissions
+		ORDER BY role_code, permission_code`
+
+	var rolePermissions []entity.RolePermission
+	if err := r.DB.Select(&rolePermissions, query); err != nil {
+		return nil, err
+	}
+
+	return rolePermissions, nil
+}
+
+func (r *PermissionRepository) DeleteRolePermissions(tx *sqlx.Tx, roleCode string) error {
+	_, err := tx.Exec(`DELETE FROM role_permissions WHERE role_code = $1`, roleCode)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PermissionRepository) SaveRolePermission(tx *sqlx.Tx, rolePermission entity.RolePermission) error {
+	query := `
+		INSERT INTO role_permissions (role_code, permission_code, created_at, created_by)
+		VALUES (:role_code, :permission_code, :created_at, :created_by)`
+
+	_, err := tx.NamedExec(query, rolePermission)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
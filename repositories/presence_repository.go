@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Mark users online with the time they were last seen, in one statement
+func UpdateUsersLastActive(db sqlx.DB, lastActive map[string]time.Time) error {
+	if len(lastActive) == 0 {
+		return nil
+	}
+
+	userUUIDs := make([]string, 0, len(lastActive))
+	seenAt := make([]string, 0, len(lastActive))
+	for userUUID, at := range lastActive {
+		userUUIDs = append(userUUIDs, userUUID)
+		seenAt = append(seenAt, at.Format(time.RFC3339Nano))
+	}
+
+	query := `
+		UPDATE users u
+		SET user_status = 'online', user_last_active = GREATEST(COALESCE(u.user_last_active, v.last_active), v.last_active)
+		FROM unnest($1::uuid[], $2::timestamptz[]) AS v(user_uuid, last_active)
+		WHERE u.user_uuid = v.user_uuid AND u.deleted_at IS NULL
+	`
+	_, err := db.Exec(query, pq.Array(userUUIDs), pq.Array(seenAt))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Devices aren't tied to sessions, so activity of a user counts as activity on each of their devices
+func UpdateUserDevicesLastSeen(db sqlx.DB, lastSeen map[string]time.Time) error {
+	if len(lastSeen) == 0 {
+		return nil
+	}
+
+	userUUIDs := make([]string, 0, len(lastSeen))
+	seenAt := make([]string, 0, len(lastSeen))
+	for userUUID, at := range lastSeen {
+		userUUIDs = append(userUUIDs, userUUID)
+		seenAt = append(seenAt, at.Format(time.RFC3339Nano))
+	}
+
+	query := `
+		UPDATE user_devices d
+		SET last_seen_at = GREATEST(d.last_seen_at, v.last_seen)
+		FROM unnest($1::uuid[], $2::timestamptz[]) AS v(user_uuid, last_seen)
+		WHERE d.user_uuid = v.user_uuid
+	`
+	_, err := db.Exec(query, pq.Array(userUUIDs), pq.Array(seenAt))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Move users that have not been active since `before` from one status to another
+func UpdateStaleUsersStatus(db sqlx.DB, fromStatuses []string, toStatus string, before time.Time) (int64, error) {
+	query := `
+		UPDATE users
+		SET user_status = $1
+		WHERE user_status = ANY($2) AND (user_last_active IS NULL OR user_last_active < $3)
+	`
+	result, err := db.Exec(query, toStatus, pq.Array(fromStatuses), before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Move a single user to another status, only if the user is currently in one of fromStatuses
+func UpdateUserStatusFrom(db sqlx.DB, userUUID string, fromStatuses []string, toStatus string, lastActive time.Time) error {
+	query := `
+		UPDATE users
+		SET user_status = $1, user_last_active = GREATEST(COALESCE(user_last_active, $2), $2)
+		WHERE user_uuid = $3 AND user_status = ANY($4) AND deleted_at IS NULL
+	`
+	_, err := db.Exec(query, toStatus, lastActive, userUUID, pq.Array(fromStatuses))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package repositories
+
+import (
+	"time"
+
+	"shuttle/models/entity"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type RoutePointRepositoryInterface interface {
+	BeginTransaction() (*sqlx.Tx, error)
+	LockRoute(tx *sqlx.Tx, routenameUUID, schoolUUID string) (bool, error)
+	RouteExists(routenameUUID, schoolUUID string) (bool, error)
+	FetchRoutePoints(routenameUUID, direction string) ([]entity.RoutePoint, error)
+	FetchRoutePointsForUpdate(tx *sqlx.Tx, routenameUUID string) ([]entity.RoutePoint, error)
+	FetchRoutePointStudents(routenameUUID string) ([]entity.RoutePointStudent, error)
+	SaveRoutePoint(tx *sqlx.Tx, point entity.RoutePoint) error
+	UpdateRoutePoint(tx *sqlx.Tx, point entity.RoutePoint) error
+	UpdateRoutePointOrder(tx *sqlx.Tx, pointUUID uuid.UUID, order int, username string) error
+	DeleteRoutePoint(tx *sqlx.Tx, pointUUID uuid.UUID, username string) error
+	UnassignRoutePointStudents(tx *sqlx.Tx, direction string, pointUUID uuid.UUID) error
+	AssignStudentsToRoutePoint(tx *sqlx.Tx, routenameUUID, direction string, pointUUID uuid.UUID, studentUUIDs []uuid.UUID) (int64, error)
+}
+
+type RoutePointRepository struct {
+	DB *sqlx.DB
+}
+
+func NewRoutePointRepository(DB *sqlx.DB) RoutePointRepositoryInterface {
+	return &RoutePointRepository{
+		DB: DB,
+	}
+}
+
+func (r *RoutePointRepository) BeginTransaction() (*sqlx.Tx, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Locks the route so concurrent changes to its stops can't mix up the order
+func (r *RoutePointRepository) LockRoute(tx *sqlx.Tx, routenameUUID, schoolUUID string) (bool, error) {
+	query := `SELECT route_name_uuid FROM routes WHERE route_name_uuid = $1 AND school_uuid = $2 FOR UPDATE`
+
+	rows, err := tx.Query(query, routenameUUID, schoolUUID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
+}
+
+func (r *RoutePointRepository) RouteExists(routenameUUID, schoolUUID string) (bool, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM routes WHERE route_name_uuid = $1 AND school_uuid = $2`
+	if err := r.DB.Get(&count, query, routenameUUID, schoolUUID); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Stops of both legs unless a direction is given
+func (r *RoutePointRepository) FetchRoutePoints(routenameUUID, direction string) ([]entity.RoutePoint, error) {
+	query := `
+		SELECT route_point_id, route_point_uuid, route_name_uuid, route_point_direction, route_point_name, route_point_order,
+			route_point_latitude, route_point_longitude, created_at, created_by, updated_at, updated_by
+		FROM route_points
+		WHERE route_name_uuid = $1 AND ($2::TEXT = '' OR route_point_direction = $2) AND deleted_at IS NULL
+		ORDER BY route_point_direction DESC, route_point_order ASC
+	`
+
+	var points []entity.RoutePoint
+	if err := r.DB.Select(&points, query, routenameUUID, direction); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func (r *RoutePointRepository) FetchRoutePointsForUpdate(tx *sqlx.Tx, routenameUUID string) ([]entity.RoutePoint, error) {
+	query := `
+		SELECT route_point_id, route_point_uuid, route_name_uuid, route_point_direction, route_point_name, route_point_order,
+			route_point_latitude, route_point_longitude, created_at, created_by, updated_at, updated_by
+		FROM route_points
+		WHERE route_name_uuid = $1 AND deleted_at IS NULL
+		ORDER BY route_point_direction DESC, route_point_order ASC
+		FOR UPDATE
+	`
+
+	var points []entity.RoutePoint
+	if err := tx.Select(&points, query, routenameUUID); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func (r *RoutePointRepository) FetchRoutePointStudents(routenameUUID string) ([]entity.RoutePointStudent, error) {
+	query := `
+		SELECT ra.route_point_uuid, ra.student_uuid, s.student_first_name, s.student_last_name
+		FROM route_assignment ra
+		JOIN students s ON ra.student_uuid = s.student_uuid
+		WHERE ra.route_name_uuid = $1 AND ra.route_point_uuid IS NOT NULL AND ra.deleted_at IS NULL
+		ORDER BY ` + routeDirectionOrderBy("ra", entity.RouteDirectionMorning)
+
+	var students []entity.RoutePointStudent
+	if err := r.DB.Select(&students, query, routenameUUID); err != nil {
+		return nil, err
+	}
+
+	afternoonQuery := `
+		SELECT ra.afternoon_route_point_uuid AS route_point_uuid, ra.student_uuid, s.student_first_name, s.student_last_name
+		FROM route_assignment ra
+		JOIN students s ON ra.student_uuid = s.student_uuid
+		WHERE ra.route_name_uuid = $1 AND ra.afternoon_route_point_uuid IS NOT NULL AND ra.deleted_at IS NULL
+		ORDER BY ` + routeDirectionOrderBy("ra", entity.RouteDirectionAfternoon)
+
+	var afternoonStudents []entity.RoutePointStudent
+	if err := r.DB.Select(&afternoonStudents, afternoonQuery, routenameUUID); err != nil {
+		return nil, err
+	}
+	return append(students, afternoonStudents...), nil
+}
+
+func (r *RoutePointRepository) SaveRoutePoint(tx *sqlx.Tx, point entity.RoutePoint) error {
+	query := `
+		INSERT INTO route_points (route_point_id, route_point_uuid, route_name_uuid, route_point_direction, route_point_name,
+			route_point_order, route_point_latitude, route_point_longitude, created_at, created_by)
+		VALUES (:route_point_id, :route_point_uuid, :route_name_uuid, :route_point_direction, :route_point_name, :route_point_order,
+			:route_point_latitude, :route_point_longitude, :created_at, :created_by)
+	`
+
+	_, err := tx.NamedExec(query, point)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RoutePointRepository) UpdateRoutePoint(tx *sqlx.Tx, point entity.RoutePoint) error {
+	query := `
+		UPDATE route_points
+		SET route_point_name = :route_point_name,
+			route_point_latitude = :route_point_latitude,
+			route_point_longitude = :route_point_longitude,
+			updated_at = :updated_at,
+			updated_by = :updated_by
+		WHERE route_point_uuid = :route_point_uuid AND deleted_at IS NULL
+	`
+
+	_, err := tx.NamedExec(query, point)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RoutePointRepository) UpdateRoutePointOrder(tx *sqlx.Tx, pointUUID uuid.UUID, order int, username string) error {
+	query := `
+		UPDATE route_points
+		SET route_point_order = $1, updated_at = $2, updated_by = $3
+		WHERE route_point_uuid = $4
+	`
+
+	_, err := tx.Exec(query, order, time.Now(), username, pointUUID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RoutePointRepository) DeleteRoutePoint(tx *sqlx.Tx, pointUUID uuid.UUID, username string) error {
+	query := `UPDATE route_points SET deleted_at = $1, deleted_by = $2 WHERE route_point_uuid = $3`
+
+	_, err := tx.Exec(query, time.Now(), username, pointUUID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RoutePointRepository) UnassignRoutePointStudents(tx *sqlx.Tx, direction string, pointUUID uuid.UUID) error {
+	_, pointColumn := routeDirectionColumns(direction)
+	_, err := tx.Exec(`UPDATE route_assignment SET `+pointColumn+` = NULL WHERE `+pointColumn+` = $1`, pointUUID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Only students assigned to the route are moved, the number moved tells whether all of them were
+func (r *RoutePointRepository) AssignStudentsToRoutePoint(tx *sqlx.Tx, routenameUUID, direction string, pointUUID uuid.UUID, studentUUIDs []uuid.UUID) (int64, error) {
+	if len(studentUUIDs) == 0 {
+		return 0, nil
+	}
+
+	_, pointColumn := routeDirectionColumns(direction)
+	query, args, err := sqlx.In(`
+		UPDATE route_assignment
+		SET `+pointColumn+` = ?
+		WHERE route_name_uuid = ? AND deleted_at IS NULL AND student_uuid IN (?)
+	`, pointUUID, routenameUUID, studentUUIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(tx.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
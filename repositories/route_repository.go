@@ -13,7 +13,8 @@ import (
 type RouteRepositoryInterface interface {
 	FetchAllRoutesByAS(schoolUUID string) ([]dto.RoutesResponseDTO, error)
 	FetchSpecRouteByAS(route_name_UUID, driverUUID string) ([]entity.RouteAssignment, error)
-	FetchAllRoutesByDriver(driverUUID string) ([]dto.RouteResponseByDriverDTO, error)
+	FetchAllRoutesByDriver(driverUUID, direction string) ([]dto.RouteResponseByDriverDTO, error)
+	FetchDriverRouteSchedule(driverUUID string) (entity.DriverRouteSchedule, error)
 	AddRoutes(tx *sql.Tx, route entity.Routes) (string, error)
 	AddRouteAssignment(tx *sql.Tx, assignment entity.RouteAssignment) error
 	IsStudentAssigned(tx *sql.Tx, studentUUID string) (bool, error)
@@ -27,6 +28,8 @@ type RouteRepositoryInterface interface {
 	GetSchoolUUIDByUserUUID(userUUID string, schoolUUID *string) error
 	GetDriverUUIDByRouteName(routeNameUUID string) (string, error)
 	RouteExists(tx *sql.Tx, routenameUUID, schoolUUID string) (bool, error)
+	FetchRouteStops(routenameUUID, schoolUUID, direction string) ([]entity.RouteStop, error)
+	UpdateStudentOrder(tx *sql.Tx, routenameUUID, direction string, driverUUID, studentUUID uuid.UUID, order int, username string) error
 }
 
 type routeRepository struct {
@@ -49,6 +52,8 @@ func (r *routeRepository) FetchAllRoutesByAS(schoolUUID string) ([]dto.RoutesRes
 		route_name_uuid, 
 		route_name, 
 		route_description, 
+		TO_CHAR(morning_departure_time, 'HH24:MI'),
+		TO_CHAR(afternoon_departure_time, 'HH24:MI'),
 		created_at, 
 		created_by, 
 		updated_at, 
@@ -69,11 +74,14 @@ func (r *routeRepository) FetchAllRoutesByAS(schoolUUID string) ([]dto.RoutesRes
 		var route dto.RoutesResponseDTO
 		var createdAt, updatedAt sql.NullTime
 		var createdBy, updatedBy sql.NullString
+		var morningDeparture, afternoonDeparture sql.NullString
 
 		err := rows.Scan(
 			&route.RouteNameUUID,
 			&route.RouteName,
 			&route.RouteDescription,
+			&morningDeparture,
+			&afternoonDeparture,
 			&createdAt,
 			&createdBy,
 			&updatedAt,
@@ -83,6 +91,8 @@ func (r *routeRepository) FetchAllRoutesByAS(schoolUUID string) ([]dto.RoutesRes
 			return nil, err
 		}
 
+		route.MorningDepartureTime = morningDeparture.String
+		route.AfternoonDepartureTime = afternoonDeparture.String
 		if createdAt.Valid {
 			route.CreatedAt = createdAt.Time.Format("2006-01-02 15:04:05")
 		}
@@ -119,21 +129,24 @@ func (r *routeRepository) FetchSpecRouteByAS(routeNameUUID, driverUUID string) (
             r.route_name_uuid,
             r.route_name,
             r.route_description,
+            COALESCE(TO_CHAR(r.morning_departure_time, 'HH24:MI'), '') AS morning_departure_time,
+            COALESCE(TO_CHAR(r.afternoon_departure_time, 'HH24:MI'), '') AS afternoon_departure_time,
             ra.driver_uuid,
             COALESCE(d.user_first_name, '') AS driver_first_name,
             COALESCE(d.user_last_name, '') AS driver_last_name,
             s.student_uuid,
             COALESCE(s.student_first_name, '') AS student_first_name,
             COALESCE(s.student_last_name, '') AS student_last_name,
-			s.student_status
+			s.student_status,
             COALESCE(ra.student_order, 0) AS student_order,
+            COALESCE(ra.afternoon_student_order, 0) AS afternoon_student_order
         FROM routes r
         LEFT JOIN route_assignment ra ON r.route_name_uuid = ra.route_name_uuid
         LEFT JOIN driver_details d ON ra.driver_uuid = d.user_uuid
         LEFT JOIN students s ON ra.student_uuid = s.student_uuid
         WHERE r.route_name_uuid = $1
         AND (ra.driver_uuid = $2 OR ra.driver_uuid IS NULL)
-        ORDER BY ra.student_order desc
+        ORDER BY ra.student_order ASC NULLS LAST
     `
 
 	rows, err := r.DB.Query(query, routeNameUUID, driverUUIDParam)
@@ -149,6 +162,8 @@ func (r *routeRepository) FetchSpecRouteByAS(routeNameUUID, driverUUID string) (
 			&route.RouteNameUUID,
 			&route.RouteName,
 			&route.RouteDescription,
+			&route.MorningDepartureTime,
+			&route.AfternoonDepartureTime,
 			&route.DriverUUID,
 			&route.DriverFirstName,
 			&route.DriverLastName,
@@ -157,6 +172,7 @@ func (r *routeRepository) FetchSpecRouteByAS(routeNameUUID, driverUUID string) (
 			&route.StudentLastName,
 			&route.StudentStatus,
 			&route.StudentOrder,
+			&route.AfternoonStudentOrder,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan route data: %w", err)
 		}
@@ -167,7 +183,25 @@ func (r *routeRepository) FetchSpecRouteByAS(routeNameUUID, driverUUID string) (
 	return routes, nil
 }
 
-func (repo *routeRepository) FetchAllRoutesByDriver(driverUUID string) ([]dto.RouteResponseByDriverDTO, error) {
+// Columns of route_assignment holding the stop and order of a leg. Only these fixed names
+// ever end up in the query text.
+func routeDirectionColumns(direction string) (orderColumn, pointColumn string) {
+	if direction == entity.RouteDirectionAfternoon {
+		return "afternoon_student_order", "afternoon_route_point_uuid"
+	}
+	return "student_order", "route_point_uuid"
+}
+
+// Students without an afternoon order are dropped off in the reverse of their pickup order
+func routeDirectionOrderBy(alias, direction string) string {
+	if direction == entity.RouteDirectionAfternoon {
+		return fmt.Sprintf("%[1]s.afternoon_student_order ASC NULLS LAST, %[1]s.student_order DESC NULLS LAST, %[1]s.created_at DESC", alias)
+	}
+	return fmt.Sprintf("%[1]s.student_order ASC NULLS LAST, %[1]s.created_at ASC", alias)
+}
+
+func (repo *routeRepository) FetchAllRoutesByDriver(driverUUID, direction string) ([]dto.RouteResponseByDriverDTO, error) {
+	_, pointColumn := routeDirectionColumns(direction)
 	query := `
 		SELECT
 			r.route_uuid,
@@ -182,14 +216,19 @@ func (repo *routeRepository) FetchAllRoutesByDriver(driverUUID string) ([]dto.Ro
 			st.shuttle_uuid,
 			st.status AS shuttle_status,
 			sc.school_name,
-			sc.school_point
+			sc.school_point,
+			rp.route_point_uuid,
+			rp.route_point_name,
+			rp.route_point_order,
+			rp.route_point_latitude,
+			rp.route_point_longitude
 		FROM route_assignment r
 		LEFT JOIN students s ON r.student_uuid = s.student_uuid
 		LEFT JOIN schools sc ON r.school_uuid = sc.school_uuid
 		LEFT JOIN shuttle st ON r.student_uuid = st.student_uuid AND DATE(st.created_at) = CURRENT_DATE
+		LEFT JOIN route_points rp ON r.` + pointColumn + ` = rp.route_point_uuid AND rp.deleted_at IS NULL
 		WHERE r.driver_uuid = $1 AND s.student_status = 'present'
-		ORDER BY r.created_at ASC
-	`
+		ORDER BY rp.route_point_order ASC NULLS LAST, ` + routeDirectionOrderBy("r", direction)
 	var routes []dto.RouteResponseByDriverDTO
 	err := repo.DB.Select(&routes, query, driverUUID)
 	if err != nil {
@@ -198,6 +237,31 @@ func (repo *routeRepository) FetchAllRoutesByDriver(driverUUID string) ([]dto.Ro
 	return routes, nil
 }
 
+// A driver normally drives one route, if students of several are assigned the one with most of them wins
+func (repo *routeRepository) FetchDriverRouteSchedule(driverUUID string) (entity.DriverRouteSchedule, error) {
+	query := `
+		SELECT
+			rt.route_name_uuid,
+			rt.school_uuid,
+			TO_CHAR(rt.morning_departure_time, 'HH24:MI') AS morning_departure_time,
+			TO_CHAR(rt.afternoon_departure_time, 'HH24:MI') AS afternoon_departure_time,
+			COALESCE(ARRAY_AGG(st.status::TEXT) FILTER (WHERE st.status IS NOT NULL), '{}') AS shuttle_statuses
+		FROM route_assignment r
+		JOIN routes rt ON r.route_name_uuid = rt.route_name_uuid
+		LEFT JOIN shuttle st ON r.student_uuid = st.student_uuid AND DATE(st.created_at) = CURRENT_DATE
+		WHERE r.driver_uuid = $1 AND r.deleted_at IS NULL AND rt.deleted_at IS NULL
+		GROUP BY rt.route_name_uuid, rt.school_uuid, rt.morning_departure_time, rt.afternoon_departure_time
+		ORDER BY COUNT(DISTINCT r.student_uuid) DESC, rt.route_name_uuid ASC
+		LIMIT 1
+	`
+
+	var schedule entity.DriverRouteSchedule
+	if err := repo.DB.Get(&schedule, query, driverUUID); err != nil {
+		return entity.DriverRouteSchedule{}, err
+	}
+	return schedule, nil
+}
+
 func (r *routeRepository) ValidateDriverVehicle(driverUUID string) (bool, error) {
 	query := `
 		SELECT 
@@ -231,9 +295,11 @@ func (r *routeRepository) AddRoutes(tx *sql.Tx, route entity.Routes) (string, er
             school_uuid,
             route_name,
             route_description,
+            morning_departure_time,
+            afternoon_departure_time,
             created_at,
             created_by
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ) VALUES ($1, $2, $3, $4, $5, $6::TIME, $7::TIME, $8, $9)
         RETURNING route_name_uuid
     `
 
@@ -243,6 +309,8 @@ func (r *routeRepository) AddRoutes(tx *sql.Tx, route entity.Routes) (string, er
 		route.SchoolUUID,
 		route.RouteName,
 		route.RouteDescription,
+		route.MorningDepartureTime,
+		route.AfternoonDepartureTime,
 		route.CreatedAt.Time,
 		route.CreatedBy.String,
 	).Scan(&routeNameUUID)
@@ -278,11 +346,12 @@ func (r *routeRepository) AddRouteAssignment(tx *sql.Tx, assignment entity.Route
             driver_uuid,
             student_uuid,
             student_order,
+            afternoon_student_order,
             school_uuid,
             route_name_uuid,
             created_at,
             created_by
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ) VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::INT, $7, $8, $9, $10)
     `
 	_, err = tx.Exec(query,
 		assignment.RouteID,
@@ -290,6 +359,7 @@ func (r *routeRepository) AddRouteAssignment(tx *sql.Tx, assignment entity.Route
 		assignment.DriverUUID,
 		assignment.StudentUUID,
 		assignment.StudentOrder,
+		assignment.AfternoonStudentOrder,
 		assignment.SchoolUUID,
 		assignment.RouteNameUUID,
 		time.Now(),
@@ -370,14 +440,18 @@ func (r *routeRepository) UpdateRoute(tx *sql.Tx, route entity.Routes) error {
 		UPDATE routes
 		SET route_name = $1,
 		    route_description = $2,
-		    updated_at = $3,
-		    updated_by = $4
-		WHERE route_name_uuid = $5
+		    morning_departure_time = $3::TIME,
+		    afternoon_departure_time = $4::TIME,
+		    updated_at = $5,
+		    updated_by = $6
+		WHERE route_name_uuid = $7
 	`
 
 	_, err := tx.Exec(query,
 		route.RouteName,
 		route.RouteDescription,
+		route.MorningDepartureTime,
+		route.AfternoonDepartureTime,
 		route.UpdatedAt.Time,
 		route.UpdatedBy.String,
 		route.RouteNameUUID,
@@ -394,15 +468,17 @@ func (r *routeRepository) UpdateRouteAssignment(tx *sql.Tx, assignment entity.Ro
 		SET driver_uuid = $1,
 		    student_uuid = $2,
 		    student_order = $3,
-		    updated_at = $4,
-		    updated_by = $5
-		WHERE route_uuid = $6 AND driver_uuid = $7 AND student_uuid = $8
+		    afternoon_student_order = NULLIF($4, '')::INT,
+		    updated_at = $5,
+		    updated_by = $6
+		WHERE route_uuid = $7 AND driver_uuid = $8 AND student_uuid = $9
 	`
 
 	_, err := tx.Exec(query,
 		assignment.DriverUUID,
 		assignment.StudentUUID,
 		assignment.StudentOrder,
+		assignment.AfternoonStudentOrder,
 		time.Now(),
 		assignment.CreatedBy.String,
 		assignment.RouteUUID,
@@ -441,4 +517,46 @@ func (r *routeRepository) RouteExists(tx *sql.Tx, routenameUUID, schoolUUID stri
 		return false, fmt.Errorf("error checking route existence: %w", err)
 	}
 	return count > 0, nil
-}
\ No newline at end of file
+}
+
+// Assigned students of a route in their current order on the given leg
+func (r *routeRepository) FetchRouteStops(routenameUUID, schoolUUID, direction string) ([]entity.RouteStop, error) {
+	orderColumn, _ := routeDirectionColumns(direction)
+	query := `
+		SELECT
+			ra.driver_uuid,
+			ra.student_uuid,
+			s.student_first_name,
+			s.student_last_name,
+			ra.` + orderColumn + ` AS student_order,
+			s.student_pickup_point,
+			sc.school_point
+		FROM route_assignment ra
+		JOIN students s ON ra.student_uuid = s.student_uuid
+		JOIN schools sc ON ra.school_uuid = sc.school_uuid
+		WHERE ra.route_name_uuid = $1 AND ra.school_uuid = $2 AND ra.deleted_at IS NULL
+		ORDER BY ra.driver_uuid, ` + routeDirectionOrderBy("ra", direction)
+
+	var stops []entity.RouteStop
+	if err := r.DB.Select(&stops, query, routenameUUID, schoolUUID); err != nil {
+		return nil, fmt.Errorf("failed to fetch route stops: %w", err)
+	}
+	return stops, nil
+}
+
+func (r *routeRepository) UpdateStudentOrder(tx *sql.Tx, routenameUUID, direction string, driverUUID, studentUUID uuid.UUID, order int, username string) error {
+	orderColumn, _ := routeDirectionColumns(direction)
+	query := `
+		UPDATE route_assignment
+		SET ` + orderColumn + ` = $1,
+		    updated_at = $2,
+		    updated_by = $3
+		WHERE route_name_uuid = $4 AND driver_uuid = $5 AND student_uuid = $6
+	`
+
+	_, err := tx.Exec(query, order, time.Now(), username, routenameUUID, driverUUID, studentUUID)
+	if err != nil {
+		return fmt.Errorf("failed to update student order: %w", err)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -14,11 +15,25 @@ import (
 
 type ShuttleRepositoryInterface interface {
 	FetchShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.ShuttleResponse, error)
-	FetchAllShuttleByParent(parentUUID uuid.UUID) ([]dto.ShuttleAllResponse, error)
+	FetchAllShuttleByParent(parentUUID uuid.UUID, from, to time.Time) ([]dto.ShuttleAllResponse, error)
 	FetchAllShuttleByDriver(driverUUID uuid.UUID) ([]dto.ShuttleAllResponse, error)
 	GetSpecShuttle(shuttleUUID uuid.UUID) ([]dto.ShuttleSpecResponse, error)
-	SaveShuttle(shuttle entity.Shuttle) error
-	UpdateShuttleStatus(shuttleUUID uuid.UUID, status string) error
+	BeginTransaction() (*sqlx.Tx, error)
+	SaveShuttle(tx *sqlx.Tx, shuttle entity.Shuttle) error
+	FetchShuttleForUpdate(tx *sqlx.Tx, shuttleUUID uuid.UUID) (entity.Shuttle, error)
+	UpdateShuttleStatus(tx *sqlx.Tx, shuttleUUID uuid.UUID, status string) error
+	SaveShuttleStatusHistory(tx *sqlx.Tx, history entity.ShuttleStatusHistory) error
+	FetchShuttleStatusHistory(shuttleUUID uuid.UUID) ([]entity.ShuttleStatusHistory, error)
+	FetchStudentStatusHistory(studentUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleStatusHistory, error)
+	FetchParentShuttleStatusHistory(parentUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleStatusHistory, error)
+	FetchStudentAccess(studentUUID uuid.UUID) (entity.StudentAccess, error)
+	FetchShuttleAccess(shuttleUUID uuid.UUID) (entity.ShuttleAccess, error)
+	SaveShuttleLocations(locations []entity.ShuttleLocation) error
+	FetchShuttleTrail(shuttleUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleLocation, error)
+	UpsertShuttleLastLocations(locations []entity.ShuttleLocation) error
+	FetchShuttleLastLocation(shuttleUUID uuid.UUID) (entity.ShuttleLocation, error)
+	FetchDriverLastLocation(driverUUID string) (entity.ShuttleLocation, error)
+	FetchDriverTripStops(driverUUID string) ([]entity.TripStop, error)
 }
 
 type ShuttleRepository struct {
@@ -38,6 +53,7 @@ func (r *ShuttleRepository) FetchShuttleTrackByParent(parentUUID uuid.UUID) ([]d
 		SELECT 
 			st.student_uuid,
 			st.shuttle_uuid,
+			st.driver_uuid,
 			s.student_first_name,
 			s.student_last_name,
 			s.parent_uuid,
@@ -64,7 +80,7 @@ func (r *ShuttleRepository) FetchShuttleTrackByParent(parentUUID uuid.UUID) ([]d
 	return shuttles, nil
 }
 
-func (r *ShuttleRepository) FetchAllShuttleByParent(parentUUID uuid.UUID) ([]dto.ShuttleAllResponse, error) {
+func (r *ShuttleRepository) FetchAllShuttleByParent(parentUUID uuid.UUID, from, to time.Time) ([]dto.ShuttleAllResponse, error) {
 	query := `
 		SELECT
 			st.shuttle_uuid,
@@ -84,10 +100,11 @@ func (r *ShuttleRepository) FetchAllShuttleByParent(parentUUID uuid.UUID) ([]dto
 			ON st.student_uuid = s.student_uuid
 		LEFT JOIN schools sc 
 			ON s.school_uuid = sc.school_uuid
-		WHERE s.parent_uuid = $1 ORDER BY st.created_at ASC
+		WHERE s.parent_uuid = $1 AND st.created_at >= $2 AND st.created_at < $3
+		ORDER BY st.created_at ASC
 	`
 	var shuttles []dto.ShuttleAllResponse
-	err := r.DB.Select(&shuttles, query, parentUUID)
+	err := r.DB.Select(&shuttles, query, parentUUID, from, to)
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +204,16 @@ func (r *ShuttleRepository) GetSpecShuttle(shuttleUUID uuid.UUID) ([]dto.Shuttle
 	return shuttles, nil
 }
 
-func (r *ShuttleRepository) SaveShuttle(shuttle entity.Shuttle) error {
+func (r *ShuttleRepository) BeginTransaction() (*sqlx.Tx, error) {
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func (r *ShuttleRepository) SaveShuttle(tx *sqlx.Tx, shuttle entity.Shuttle) error {
 	// Log: Logging query execution details
 	log.Printf("SaveShuttle: Preparing to execute query for shuttleID %d", shuttle.ShuttleID)
 
@@ -199,7 +225,7 @@ func (r *ShuttleRepository) SaveShuttle(shuttle entity.Shuttle) error {
 	log.Printf("SaveShuttle: Shuttle details - shuttle_id: %d, shuttle_uuid: %s, student_uuid: %s, driver_uuid: %s, status: %s, created_at: %s",
 		shuttle.ShuttleID, shuttle.ShuttleUUID.String(), shuttle.StudentUUID.String(), shuttle.DriverUUID.String(), shuttle.Status, shuttle.CreatedAt.Time.String())
 
-	_, err := r.DB.NamedExec(query, shuttle)
+	_, err := tx.NamedExec(query, shuttle)
 	if err != nil {
 		// Log: Error executing query
 		log.Printf("SaveShuttle: Error executing query for shuttleID %d - %s", shuttle.ShuttleID, err.Error())
@@ -212,7 +238,23 @@ func (r *ShuttleRepository) SaveShuttle(shuttle entity.Shuttle) error {
 	return nil
 }
 
-func (r *ShuttleRepository) UpdateShuttleStatus(shuttleUUID uuid.UUID, status string) error {
+// Lock the shuttle row so concurrent status changes are applied one after another
+func (r *ShuttleRepository) FetchShuttleForUpdate(tx *sqlx.Tx, shuttleUUID uuid.UUID) (entity.Shuttle, error) {
+	query := `
+		SELECT shuttle_id, shuttle_uuid, student_uuid, driver_uuid, status, created_at, updated_at
+		FROM shuttle
+		WHERE shuttle_uuid = $1 AND deleted_at IS NULL
+		FOR UPDATE`
+
+	var shuttle entity.Shuttle
+	if err := tx.Get(&shuttle, query, shuttleUUID); err != nil {
+		return entity.Shuttle{}, err
+	}
+
+	return shuttle, nil
+}
+
+func (r *ShuttleRepository) UpdateShuttleStatus(tx *sqlx.Tx, shuttleUUID uuid.UUID, status string) error {
 	query := `
 		UPDATE shuttle
 		SET status = :status, updated_at = NOW()
@@ -223,7 +265,7 @@ func (r *ShuttleRepository) UpdateShuttleStatus(shuttleUUID uuid.UUID, status st
 		"shuttle_uuid": shuttleUUID,
 	}
 
-	result, err := r.DB.NamedExec(query, data)
+	result, err := tx.NamedExec(query, data)
 	if err != nil {
 		return err
 	}
@@ -235,3 +277,288 @@ func (r *ShuttleRepository) UpdateShuttleStatus(shuttleUUID uuid.UUID, status st
 
 	return nil
 }
+
+func (r *ShuttleRepository) SaveShuttleStatusHistory(tx *sqlx.Tx, history entity.ShuttleStatusHistory) error {
+	query := `
+		INSERT INTO shuttle_status_history (history_id, shuttle_uuid, from_status, to_status, changed_by_uuid, changed_by, latitude, longitude, changed_at)
+		VALUES (:history_id, :shuttle_uuid, :from_status, :to_status, :changed_by_uuid, :changed_by, :latitude, :longitude, :changed_at)`
+
+	_, err := tx.NamedExec(query, history)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *ShuttleRepository) FetchShuttleStatusHistory(shuttleUUID uuid.UUID) ([]entity.ShuttleStatusHistory, error) {
+	query := `
+		SELECT history_id, shuttle_uuid, from_status, to_status, changed_by_uuid, changed_by, latitude, longitude, changed_at
+		FROM shuttle_status_history
+		WHERE shuttle_uuid = $1
+		ORDER BY changed_at ASC, history_id ASC
+	`
+
+	var history []entity.ShuttleStatusHistory
+	if err := r.DB.Select(&history, query, shuttleUUID); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (r *ShuttleRepository) FetchStudentStatusHistory(studentUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleStatusHistory, error) {
+	query := `
+		SELECT
+			h.history_id,
+			h.shuttle_uuid,
+			h.from_status,
+			h.to_status,
+			h.changed_by_uuid,
+			h.changed_by,
+			h.latitude,
+			h.longitude,
+			h.changed_at
+		FROM shuttle_status_history h
+		INNER JOIN shuttle st
+			ON st.shuttle_uuid = h.shuttle_uuid
+		WHERE st.student_uuid = $1 AND st.deleted_at IS NULL
+			AND h.changed_at >= $2 AND h.changed_at < $3
+		ORDER BY h.changed_at ASC, h.history_id ASC
+	`
+
+	var history []entity.ShuttleStatusHistory
+	if err := r.DB.Select(&history, query, studentUUID, from, to); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// History of every shuttle of the parent's children, for the recap
+func (r *ShuttleRepository) FetchParentShuttleStatusHistory(parentUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleStatusHistory, error) {
+	query := `
+		SELECT
+			h.history_id,
+			h.shuttle_uuid,
+			h.from_status,
+			h.to_status,
+			h.changed_by_uuid,
+			h.changed_by,
+			h.latitude,
+			h.longitude,
+			h.changed_at
+		FROM shuttle_status_history h
+		INNER JOIN shuttle st
+			ON st.shuttle_uuid = h.shuttle_uuid
+		INNER JOIN students s
+			ON s.student_uuid = st.student_uuid
+		WHERE s.parent_uuid = $1 AND st.deleted_at IS NULL
+			AND st.created_at >= $2 AND st.created_at < $3
+		ORDER BY h.changed_at ASC, h.history_id ASC
+	`
+
+	var history []entity.ShuttleStatusHistory
+	if err := r.DB.Select(&history, query, parentUUID, from, to); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (r *ShuttleRepository) FetchStudentAccess(studentUUID uuid.UUID) (entity.StudentAccess, error) {
+	query := `
+		SELECT student_uuid, parent_uuid, school_uuid
+		FROM students
+		WHERE student_uuid = $1 AND deleted_at IS NULL
+	`
+
+	var access entity.StudentAccess
+	if err := r.DB.Get(&access, query, studentUUID); err != nil {
+		return entity.StudentAccess{}, err
+	}
+
+	return access, nil
+}
+
+func (r *ShuttleRepository) FetchShuttleAccess(shuttleUUID uuid.UUID) (entity.ShuttleAccess, error) {
+	query := `
+		SELECT
+			st.shuttle_uuid,
+			st.driver_uuid,
+			s.parent_uuid,
+			s.school_uuid,
+			s.student_first_name
+		FROM shuttle st
+		LEFT JOIN students s
+			ON s.student_uuid = st.student_uuid
+		WHERE st.shuttle_uuid = $1 AND st.deleted_at IS NULL
+		LIMIT 1
+	`
+
+	var access entity.ShuttleAccess
+	if err := r.DB.Get(&access, query, shuttleUUID); err != nil {
+		return entity.ShuttleAccess{}, err
+	}
+
+	return access, nil
+}
+
+func (r *ShuttleRepository) SaveShuttleLocations(locations []entity.ShuttleLocation) error {
+	if len(locations) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO shuttle_locations (location_id, shuttle_uuid, driver_uuid, latitude, longitude, speed, heading, recorded_at)
+		VALUES (:location_id, :shuttle_uuid, :driver_uuid, :latitude, :longitude, :speed, :heading, :recorded_at)`
+
+	_, err := r.DB.NamedExec(query, locations)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *ShuttleRepository) FetchShuttleTrail(shuttleUUID uuid.UUID, from, to time.Time) ([]entity.ShuttleLocation, error) {
+	query := `
+		SELECT
+			location_id,
+			shuttle_uuid,
+			driver_uuid,
+			latitude,
+			longitude,
+			speed,
+			heading,
+			recorded_at
+		FROM shuttle_locations
+		WHERE shuttle_uuid = $1 AND recorded_at BETWEEN $2 AND $3
+		ORDER BY recorded_at ASC
+	`
+
+	var locations []entity.ShuttleLocation
+	if err := r.DB.Select(&locations, query, shuttleUUID, from, to); err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+// Expects at most one location per shuttle, Postgres rejects an upsert touching the same row twice
+func (r *ShuttleRepository) UpsertShuttleLastLocations(locations []entity.ShuttleLocation) error {
+	if len(locations) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO shuttle_last_locations (shuttle_uuid, location_id, driver_uuid, latitude, longitude, speed, heading, recorded_at)
+		VALUES (:shuttle_uuid, :location_id, :driver_uuid, :latitude, :longitude, :speed, :heading, :recorded_at)
+		ON CONFLICT (shuttle_uuid) DO UPDATE SET
+			location_id = EXCLUDED.location_id,
+			driver_uuid = EXCLUDED.driver_uuid,
+			latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude,
+			speed = EXCLUDED.speed,
+			heading = EXCLUDED.heading,
+			recorded_at = EXCLUDED.recorded_at
+		WHERE shuttle_last_locations.recorded_at <= EXCLUDED.recorded_at`
+
+	_, err := r.DB.NamedExec(query, locations)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *ShuttleRepository) FetchShuttleLastLocation(shuttleUUID uuid.UUID) (entity.ShuttleLocation, error) {
+	query := `
+		SELECT
+			location_id,
+			shuttle_uuid,
+			driver_uuid,
+			latitude,
+			longitude,
+			speed,
+			heading,
+			recorded_at
+		FROM shuttle_last_locations
+		WHERE shuttle_uuid = $1
+	`
+
+	var location entity.ShuttleLocation
+	if err := r.DB.Get(&location, query, shuttleUUID); err != nil {
+		return entity.ShuttleLocation{}, err
+	}
+
+	return location, nil
+}
+
+// The driver publishes on one shuttle at a time, so the freshest fix of any of their shuttles is where they are
+func (r *ShuttleRepository) FetchDriverLastLocation(driverUUID string) (entity.ShuttleLocation, error) {
+	query := `
+		SELECT
+			location_id,
+			shuttle_uuid,
+			driver_uuid,
+			latitude,
+			longitude,
+			speed,
+			heading,
+			recorded_at
+		FROM shuttle_last_locations
+		WHERE driver_uuid = $1
+		ORDER BY recorded_at DESC
+		LIMIT 1
+	`
+
+	var location entity.ShuttleLocation
+	if err := r.DB.Get(&location, query, driverUUID); err != nil {
+		return entity.ShuttleLocation{}, err
+	}
+
+	return location, nil
+}
+
+// Today's shuttles of the driver that are still waiting for or on their way, whichever leg they are on
+func (r *ShuttleRepository) FetchDriverTripStops(driverUUID string) ([]entity.TripStop, error) {
+	query := `
+		SELECT
+			st.shuttle_uuid,
+			st.student_uuid,
+			st.status,
+			s.student_pickup_point,
+			sc.school_point,
+			ra.student_order,
+			ra.afternoon_student_order,
+			mp.route_point_order AS morning_point_order,
+			mp.route_point_latitude AS morning_latitude,
+			mp.route_point_longitude AS morning_longitude,
+			ap.route_point_order AS afternoon_point_order,
+			ap.route_point_latitude AS afternoon_latitude,
+			ap.route_point_longitude AS afternoon_longitude
+		FROM shuttle st
+		JOIN students s
+			ON s.student_uuid = st.student_uuid
+		JOIN schools sc
+			ON sc.school_uuid = s.school_uuid
+		LEFT JOIN route_assignment ra
+			ON ra.student_uuid = st.student_uuid AND ra.deleted_at IS NULL
+		LEFT JOIN route_points mp
+			ON mp.route_point_uuid = ra.route_point_uuid AND mp.deleted_at IS NULL
+		LEFT JOIN route_points ap
+			ON ap.route_point_uuid = ra.afternoon_route_point_uuid AND ap.deleted_at IS NULL
+		WHERE st.driver_uuid = $1
+			AND st.deleted_at IS NULL
+			AND DATE(st.created_at) = CURRENT_DATE
+			AND st.status IN ('waiting_to_be_taken_to_school', 'going_to_school', 'waiting_to_be_taken_to_home', 'going_to_home')
+	`
+
+	var stops []entity.TripStop
+	if err := r.DB.Select(&stops, query, driverUUID); err != nil {
+		return nil, err
+	}
+
+	return stops, nil
+}
@@ -19,6 +19,7 @@ type UserRepositoryInterface interface {
 	FetchPermittedSchoolAccess(userUUID string) (string, error)
 	FetchSpecDriverForPermittedSchool(userUUID, schoolUUID string) (entity.User, entity.School, entity.Vehicle, error)
 	CountAllPermittedDriver(schoolUUID string) (int, error)
+	FetchOnlineDriversForPermittedSchool(schoolUUID string) ([]entity.DriverPresence, error)
 
 	BeginTransaction() (*sqlx.Tx, error)
 	FetchSpecificUser(userUUID string) (entity.User, error)
@@ -248,6 +249,25 @@ func (r *userRepository) CountAllPermittedDriver(schoolUUID string) (int, error)
 }
 
 
+func (r *userRepository) FetchOnlineDriversForPermittedSchool(schoolUUID string) ([]entity.DriverPresence, error) {
+	query := `
+		SELECT u.user_uuid, u.user_username, u.user_status, u.user_last_active,
+			dd.user_first_name, dd.user_last_name, dd.user_phone, dd.vehicle_uuid
+		FROM users u
+		JOIN driver_details dd ON dd.user_uuid = u.user_uuid
+		WHERE u.user_role_code = 'D' AND u.deleted_at IS NULL
+			AND dd.school_uuid = $1 AND u.user_status = 'online'
+		ORDER BY u.user_last_active DESC
+	`
+
+	var drivers []entity.DriverPresence
+	if err := r.DB.Select(&drivers, query, schoolUUID); err != nil {
+		return nil, err
+	}
+
+	return drivers, nil
+}
+
 func (r *userRepository) FetchPermittedSchoolAccess(userUUID string) (string, error) {
 	query := `
 		SELECT asd.school_uuid
@@ -1,6 +1,8 @@
 package routes
 
 import (
+	"log"
+
 	"shuttle/handler"
 	"shuttle/middleware"
 	"shuttle/repositories"
@@ -10,6 +12,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
 )
 
 func Route(r *fiber.App, db *sqlx.DB) {
@@ -21,31 +24,57 @@ func Route(r *fiber.App, db *sqlx.DB) {
 	routeRepository := repositories.NewRouteRepository(db)
 	childernRepository := repositories.NewChildernRepository(db)
 	shuttleRepository := repositories.NewShuttleRepository(db)
+	notificationRepository := repositories.NewNotificationRepository(db)
+	auditRepository := repositories.NewAuditRepository(db)
+	permissionRepository := repositories.NewPermissionRepository(db)
+	routePointRepository := repositories.NewRoutePointRepository(db)
+	calendarRepository := repositories.NewCalendarRepository(db)
 	
 	userService := services.NewUserService(userRepository)
-	authService := services.NewAuthService(authRepository, userRepository)
+	auditService := services.NewAuditService(auditRepository)
+	authService := services.NewAuthService(authRepository, userRepository, auditService, newMailer(), newSMSSender(), utils.SessionTokenRevoker{})
 	schoolService := services.NewSchoolService(schoolRepository, userRepository)
 	vehicleService := services.NewVehicleService(vehicleRepository)
 	studentService := services.NewStudentService(studentRepository, &userService, userRepository)
-	routeService := services.NewRouteService(routeRepository)
+	calendarService := services.NewCalendarService(calendarRepository)
+	routeService := services.NewRouteService(routeRepository, calendarService)
+	routePointService := services.NewRoutePointService(routePointRepository)
 	childernService := services.NewChildernService(childernRepository)
-	shuttleService := services.NewShuttleService(shuttleRepository)
+	notificationDispatcher := utils.NewNotificationDispatcher(newNotifier(), notificationRepository)
+	etaEstimator := utils.NewETAEstimatorFromConfig(shuttleRepository)
+	shuttleService := services.NewShuttleService(shuttleRepository, notificationDispatcher, etaEstimator)
+	notificationService := services.NewNotificationService(notificationRepository)
+	permissionService := services.NewPermissionService(permissionRepository, auditService)
+	if err := permissionService.SyncRegistry(); err != nil {
+		log.Println("Failed to sync permission registry:", err)
+	}
 	
-	authHandler := handler.NewAuthHttpHandler(authService)
+	authHandler := handler.NewAuthHttpHandler(authService, notificationService)
 	userHandler := handler.NewUserHttpHandler(userService, schoolService, vehicleService)
 	schoolHandler := handler.NewSchoolHttpHandler(schoolService)
 	vehicleHandler := handler.NewVehicleHttpHandler(vehicleService)
 	studentHandler := handler.NewStudentHttpHandler(studentService)
 	routeHandler := handler.NewRouteHttpHandler(routeService)
+	routePointHandler := handler.NewRoutePointHandler(routePointService)
+	calendarHandler := handler.NewCalendarHandler(calendarService)
 	childernHandler := handler.NewChildernHandler(childernService)
 	shuttleHandler := handler.NewShuttleHandler(shuttleService)
+	notificationHandler := handler.NewNotificationHandler(notificationService)
+	permissionHandler := handler.NewPermissionHandler(permissionService)
 
-	wsService := utils.NewWebSocketService(userRepository, authRepository)
+	locationRecorder := utils.NewLocationRecorder(shuttleRepository)
+	utils.StartPresenceTracker()
+	wsService := utils.NewWebSocketService(userRepository, authRepository, shuttleRepository, locationRecorder, etaEstimator, shuttleService)
 	
 	////////////////////////////////////// PUBLIC //////////////////////////////////////
 
 	r.Post("login", authHandler.Login)
 	r.Post("/refresh-token", authHandler.IssueNewAccessToken)
+	r.Post("/forgot-password", authHandler.ForgotPassword)
+	r.Post("/reset-password", authHandler.ResetPassword)
+	r.Post("/otp/request", authHandler.RequestOTP)
+	r.Post("/otp/verify", authHandler.VerifyOTP)
+	r.Get("/.well-known/jwks.json", authHandler.JWKS)
 	r.Static("/assets", "./assets")
 
 	r.Use("/ws", func(c *fiber.Ctx) error {
@@ -54,16 +83,31 @@ func Route(r *fiber.App, db *sqlx.DB) {
 		}
 		return fiber.ErrUpgradeRequired
 	})
-	r.Get("/ws/:id", websocket.New(wsService.HandleWebSocketConnection))
+	r.Get("/ws/:id", websocket.New(wsService.HandleWebSocketConnection, websocket.Config{
+		Subprotocols: []string{utils.WebSocketTokenSubprotocol},
+	}))
 
 	////////////////////////////////////// AUTHENTICATED //////////////////////////////////////
 
 	protected := r.Group("/api")
 	protected.Use(middleware.AuthenticationMiddleware())
 	protected.Use(middleware.AuthorizationMiddleware([]string{"SA", "AS", "D", "P"}))
+	protected.Use(middleware.ImpersonationAuditMiddleware(auditService))
+
+	// What a role may do is stored in role_permissions, the role lists on the groups below only
+	// say which kind of account a group is for since its handlers scope data by that account
+	can := func(permission string) fiber.Handler {
+		return middleware.PermissionMiddleware(permissionService, permission)
+	}
 
 	protected.Get("/my/profile", authHandler.GetMyProfile)
 	protected.Post("/logout", authHandler.Logout)
+	protected.Post("/my/password", middleware.DenyImpersonationMiddleware(), authHandler.ChangePassword)
+	protected.Get("/my/sessions", authHandler.GetMySessions)
+	protected.Delete("/my/sessions", middleware.DenyImpersonationMiddleware(), authHandler.RevokeAllMySessions)
+	protected.Delete("/my/sessions/:id", middleware.DenyImpersonationMiddleware(), authHandler.RevokeMySession)
+	protected.Post("/my/devices", middleware.DenyImpersonationMiddleware(), can(services.PermissionDeviceWrite), notificationHandler.RegisterDevice)
+	protected.Delete("/my/devices", middleware.DenyImpersonationMiddleware(), can(services.PermissionDeviceWrite), notificationHandler.UnregisterDevice)
 
 	////////////////////////////////////// SUPER ADMIN //////////////////////////////////////
 	
@@ -76,31 +120,38 @@ func Route(r *fiber.App, db *sqlx.DB) {
 	protectedParent.Use(middleware.AuthorizationMiddleware([]string{"P"}))
 
 	// USER FOR SUPERADMIN
-	protectedSuperAdmin.Get("/user/sa/all", userHandler.GetAllSuperAdmin)
-	protectedSuperAdmin.Get("/user/as/all", userHandler.GetAllSchoolAdmin)
-	protectedSuperAdmin.Get("/user/driver/all", userHandler.GetAllPermittedDriver)
-	protectedSuperAdmin.Get("/user/sa/:id", userHandler.GetSpecSuperAdmin)
-	protectedSuperAdmin.Get("/user/as/:id", userHandler.GetSpecSchoolAdmin)
-	protectedSuperAdmin.Get("/user/driver/:id", userHandler.GetSpecPermittedDriver)
-	protectedSuperAdmin.Post("/user/add", userHandler.AddUser)
-	protectedSuperAdmin.Put("/user/update/:id", userHandler.UpdateUser)
-	protectedSuperAdmin.Delete("/user/sa/delete/:id", userHandler.DeleteSuperAdmin)
-	protectedSuperAdmin.Delete("/user/as/delete/:id", userHandler.DeleteSchoolAdmin)
-	protectedSuperAdmin.Delete("/user/driver/delete/:id", userHandler.DeleteDriver)
+	protectedSuperAdmin.Get("/user/sa/all", can(services.PermissionUserRead), userHandler.GetAllSuperAdmin)
+	protectedSuperAdmin.Get("/user/as/all", can(services.PermissionUserRead), userHandler.GetAllSchoolAdmin)
+	protectedSuperAdmin.Get("/user/driver/all", can(services.PermissionUserRead), userHandler.GetAllPermittedDriver)
+	protectedSuperAdmin.Get("/user/sa/:id", can(services.PermissionUserRead), userHandler.GetSpecSuperAdmin)
+	protectedSuperAdmin.Get("/user/as/:id", can(services.PermissionUserRead), userHandler.GetSpecSchoolAdmin)
+	protectedSuperAdmin.Get("/user/driver/:id", can(services.PermissionUserRead), userHandler.GetSpecPermittedDriver)
+	protectedSuperAdmin.Post("/user/add", can(services.PermissionUserWrite), userHandler.AddUser)
+	protectedSuperAdmin.Put("/user/update/:id", can(services.PermissionUserWrite), userHandler.UpdateUser)
+	protectedSuperAdmin.Delete("/user/sa/delete/:id", can(services.PermissionUserDelete), userHandler.DeleteSuperAdmin)
+	protectedSuperAdmin.Delete("/user/as/delete/:id", can(services.PermissionUserDelete), userHandler.DeleteSchoolAdmin)
+	protectedSuperAdmin.Delete("/user/driver/delete/:id", can(services.PermissionUserDelete), userHandler.DeleteDriver)
+	protectedSuperAdmin.Post("/user/unlock/:id", can(services.PermissionUserUnlock), authHandler.UnlockAccount)
+	protectedSuperAdmin.Post("/user/:id/impersonate", can(services.PermissionUserImpersonate), authHandler.Impersonate)
+
+	// PERMISSIONS FOR SUPERADMIN
+	protectedSuperAdmin.Get("/permissions", can(services.PermissionPermissionManage), permissionHandler.GetAllPermissions)
+	protectedSuperAdmin.Get("/roles/permissions", can(services.PermissionPermissionManage), permissionHandler.GetAllRolePermissions)
+	protectedSuperAdmin.Put("/roles/:role/permissions", can(services.PermissionPermissionManage), permissionHandler.UpdateRolePermissions)
 
 	// SCHOOL FOR SUPERADMIN
-	protectedSuperAdmin.Get("/school/all", schoolHandler.GetAllSchools)
-	protectedSuperAdmin.Get("/school/:id", schoolHandler.GetSpecSchool)
-	protectedSuperAdmin.Post("/school/add", schoolHandler.AddSchool)
-	protectedSuperAdmin.Put("/school/update/:id", schoolHandler.UpdateSchool)
-	protectedSuperAdmin.Delete("/school/delete/:id", schoolHandler.DeleteSchool)
+	protectedSuperAdmin.Get("/school/all", can(services.PermissionSchoolRead), schoolHandler.GetAllSchools)
+	protectedSuperAdmin.Get("/school/:id", can(services.PermissionSchoolRead), schoolHandler.GetSpecSchool)
+	protectedSuperAdmin.Post("/school/add", can(services.PermissionSchoolWrite), schoolHandler.AddSchool)
+	protectedSuperAdmin.Put("/school/update/:id", can(services.PermissionSchoolWrite), schoolHandler.UpdateSchool)
+	protectedSuperAdmin.Delete("/school/delete/:id", can(services.PermissionSchoolDelete), schoolHandler.DeleteSchool)
 	
 	// VEHICLE FOR SUPERADMIN
-	protectedSuperAdmin.Get("/vehicle/all", vehicleHandler.GetAllVehicles)
-	protectedSuperAdmin.Get("/vehicle/:id", vehicleHandler.GetSpecVehicle)
-	protectedSuperAdmin.Post("/vehicle/add", vehicleHandler.AddVehicle)
-	protectedSuperAdmin.Put("/vehicle/update/:id", vehicleHandler.UpdateVehicle)
-	protectedSuperAdmin.Delete("/vehicle/delete/:id", vehicleHandler.DeleteVehicle)
+	protectedSuperAdmin.Get("/vehicle/all", can(services.PermissionVehicleRead), vehicleHandler.GetAllVehicles)
+	protectedSuperAdmin.Get("/vehicle/:id", can(services.PermissionVehicleRead), vehicleHandler.GetSpecVehicle)
+	protectedSuperAdmin.Post("/vehicle/add", can(services.PermissionVehicleWrite), vehicleHandler.AddVehicle)
+	protectedSuperAdmin.Put("/vehicle/update/:id", can(services.PermissionVehicleWrite), vehicleHandler.UpdateVehicle)
+	protectedSuperAdmin.Delete("/vehicle/delete/:id", can(services.PermissionVehicleDelete), vehicleHandler.DeleteVehicle)
 
 
 	////////////////////////////////////// SCHOOL ADMIN //////////////////////////////////////
@@ -110,44 +161,125 @@ func Route(r *fiber.App, db *sqlx.DB) {
 	protectedSchoolAdmin.Use(middleware.SchoolAdminMiddleware(userService))
 
 	// STUDENT FOR SCHOOL ADMIN
-	protectedSchoolAdmin.Get("/student/all", studentHandler.GetAllStudentWithParents)
-	protectedSchoolAdmin.Get("/student/:id", studentHandler.GetSpecStudentWithParents)
-	protectedSchoolAdmin.Post("/student/add", studentHandler.AddSchoolStudentWithParents)
-	protectedSchoolAdmin.Put("/student/update/:id", studentHandler.UpdateSchoolStudentWithParents)
-	protectedSchoolAdmin.Delete("/student/delete/:id", studentHandler.DeleteSchoolStudentWithParentsIfNeccessary)
-
-	protectedSchoolAdmin.Get("/user/driver/all", userHandler.GetAllPermittedDriver)
-	protectedSchoolAdmin.Get("/user/driver/:id", userHandler.GetSpecPermittedDriver)
-	protectedSchoolAdmin.Post("/user/driver/add", userHandler.AddSchoolDriver)
-	protectedSchoolAdmin.Put("/user/driver/update/:id", userHandler.UpdateSchoolDriver)
-	protectedSchoolAdmin.Delete("/user/driver/delete/:id", userHandler.DeleteSchoolDriver)
+	protectedSchoolAdmin.Get("/student/all", can(services.PermissionStudentRead), studentHandler.GetAllStudentWithParents)
+	protectedSchoolAdmin.Get("/student/:id", can(services.PermissionStudentRead), studentHandler.GetSpecStudentWithParents)
+	protectedSchoolAdmin.Post("/student/add", can(services.PermissionStudentWrite), studentHandler.AddSchoolStudentWithParents)
+	protectedSchoolAdmin.Put("/student/update/:id", can(services.PermissionStudentWrite), studentHandler.UpdateSchoolStudentWithParents)
+	protectedSchoolAdmin.Delete("/student/delete/:id", can(services.PermissionStudentDelete), studentHandler.DeleteSchoolStudentWithParentsIfNeccessary)
+
+	protectedSchoolAdmin.Get("/user/driver/all", can(services.PermissionDriverRead), userHandler.GetAllPermittedDriver)
+	protectedSchoolAdmin.Get("/user/driver/online", can(services.PermissionDriverRead), userHandler.GetOnlineDrivers)
+	protectedSchoolAdmin.Get("/user/driver/:id", can(services.PermissionDriverRead), userHandler.GetSpecPermittedDriver)
+	protectedSchoolAdmin.Post("/user/driver/add", can(services.PermissionDriverWrite), userHandler.AddSchoolDriver)
+	protectedSchoolAdmin.Put("/user/driver/update/:id", can(services.PermissionDriverWrite), userHandler.UpdateSchoolDriver)
+	protectedSchoolAdmin.Delete("/user/driver/delete/:id", can(services.PermissionDriverDelete), userHandler.DeleteSchoolDriver)
 	
-	protectedSchoolAdmin.Get("/vehicle/all", vehicleHandler.GetAllVehiclesForPermittedSchool)
-	protectedSchoolAdmin.Get("/vehicle/:id", vehicleHandler.GetSpecVehicleForPermittedSchool)
-	protectedSchoolAdmin.Post("/vehicle/add", vehicleHandler.AddVehicleWithDriverSchool)
-	protectedSchoolAdmin.Put("/vehicle/update/:id", vehicleHandler.UpdateVehicle)
-	protectedSchoolAdmin.Delete("/vehicle/delete/:id", vehicleHandler.DeleteVehicle)
+	protectedSchoolAdmin.Get("/vehicle/all", can(services.PermissionVehicleRead), vehicleHandler.GetAllVehiclesForPermittedSchool)
+	protectedSchoolAdmin.Get("/vehicle/:id", can(services.PermissionVehicleRead), vehicleHandler.GetSpecVehicleForPermittedSchool)
+	protectedSchoolAdmin.Post("/vehicle/add", can(services.PermissionVehicleWrite), vehicleHandler.AddVehicleWithDriverSchool)
+	protectedSchoolAdmin.Put("/vehicle/update/:id", can(services.PermissionVehicleWrite), vehicleHandler.UpdateVehicle)
+	protectedSchoolAdmin.Delete("/vehicle/delete/:id", can(services.PermissionVehicleDelete), vehicleHandler.DeleteVehicle)
 
 	// ROUTE FOR SCHOOL ADMIN
-	protectedSchoolAdmin.Get("/routes/all", routeHandler.GetAllRoutesByAS)
-	protectedSchoolAdmin.Get("/route/:id", routeHandler.GetSpecRouteByAS)
-	protectedSchoolAdmin.Post("/route/add", routeHandler.AddRoute)
-	protectedSchoolAdmin.Put("/route/update/:id", routeHandler.UpdateRoute)
-	protectedSchoolAdmin.Delete("/route/delete/:id", routeHandler.DeleteRoute)
+	protectedSchoolAdmin.Get("/routes/all", can(services.PermissionRouteRead), routeHandler.GetAllRoutesByAS)
+	protectedSchoolAdmin.Get("/route/:id", can(services.PermissionRouteRead), routeHandler.GetSpecRouteByAS)
+	protectedSchoolAdmin.Post("/route/add", can(services.PermissionRouteWrite), routeHandler.AddRoute)
+	protectedSchoolAdmin.Put("/route/update/:id", can(services.PermissionRouteWrite), routeHandler.UpdateRoute)
+	protectedSchoolAdmin.Delete("/route/delete/:id", can(services.PermissionRouteDelete), routeHandler.DeleteRoute)
+	protectedSchoolAdmin.Post("/route/:id/optimize", can(services.PermissionRouteWrite), routeHandler.OptimizeRoute)
+	protectedSchoolAdmin.Get("/route/:id/points", can(services.PermissionRouteRead), routePointHandler.GetRoutePoints)
+	protectedSchoolAdmin.Post("/route/:id/points", can(services.PermissionRouteWrite), routePointHandler.AddRoutePoint)
+	protectedSchoolAdmin.Put("/route/:id/points/order", can(services.PermissionRouteWrite), routePointHandler.ReorderRoutePoints)
+	protectedSchoolAdmin.Put("/route/:id/points/:point_id", can(services.PermissionRouteWrite), routePointHandler.UpdateRoutePoint)
+	protectedSchoolAdmin.Delete("/route/:id/points/:point_id", can(services.PermissionRouteDelete), routePointHandler.DeleteRoutePoint)
+	protectedSchoolAdmin.Put("/route/:id/points/:point_id/students", can(services.PermissionRouteWrite), routePointHandler.AssignRoutePointStudents)
+
+	// CALENDAR FOR SCHOOL ADMIN
+	protectedSchoolAdmin.Get("/calendar", can(services.PermissionCalendarRead), calendarHandler.GetSchoolCalendar)
+	protectedSchoolAdmin.Put("/calendar/weekdays", can(services.PermissionCalendarWrite), calendarHandler.UpdateOperatingWeekdays)
+	protectedSchoolAdmin.Post("/calendar/terms", can(services.PermissionCalendarWrite), calendarHandler.AddSchoolTerm)
+	protectedSchoolAdmin.Delete("/calendar/terms/:id", can(services.PermissionCalendarWrite), calendarHandler.DeleteSchoolTerm)
+	protectedSchoolAdmin.Post("/calendar/closures", can(services.PermissionCalendarWrite), calendarHandler.AddSchoolClosure)
+	protectedSchoolAdmin.Delete("/calendar/closures/:id", can(services.PermissionCalendarWrite), calendarHandler.DeleteSchoolClosure)
+	protectedSchoolAdmin.Post("/calendar/import", can(services.PermissionCalendarWrite), calendarHandler.ImportSchoolCalendar)
+	protectedSchoolAdmin.Get("/routes/schedule.ics", can(services.PermissionCalendarRead), calendarHandler.ExportRouteSchedules)
+	protectedSchoolAdmin.Get("/route/:id/schedule.ics", can(services.PermissionCalendarRead), calendarHandler.ExportRouteSchedules)
+
+	// SHUTTLE FOR SCHOOL ADMIN
+	protectedSchoolAdmin.Get("/shuttle/:id/trail", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleTrail)
+	protectedSchoolAdmin.Get("/shuttle/:id/location", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleLastLocation)
+	protectedSchoolAdmin.Get("/shuttle/:id/eta", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleETA)
+	protectedSchoolAdmin.Get("/shuttle/:id/timeline", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleTimeline)
+	protectedSchoolAdmin.Get("/student/:id/timeline", can(services.PermissionShuttleRead), shuttleHandler.GetStudentTimeline)
 
 	//ROUTE FOR DRIVER
-	protectedDriver.Get("/route/all", routeHandler.GetAllRoutesByDriver)
-
-	protectedParent.Get("/my/childern/track", shuttleHandler.GetShuttleTrackByParent) //buat menu track
-	protectedParent.Get("/my/childern/all", childernHandler.GetAllChilderns) //buat menu apalah
-	protectedParent.Get("/my/childern/shuttle/:id", shuttleHandler.GetSpecShuttle) //buat menu opo jeneng e lali😂 (spec shutle)
-	protectedParent.Get("/my/childern/recap", shuttleHandler.GetAllShuttleByParent) //buat menu recap
-	protectedParent.Get("/my/childern/:id", childernHandler.GetSpecChildern) //nih katanya butuh spec
-	protectedParent.Put("/my/childern/update/:id", childernHandler.UpdateChildern) //menu update nih tampling
-	protectedParent.Put("/my/childern/status/update/:id", childernHandler.UpdateChildernStatus) //menu update nih tampling
-
-	protectedDriver.Get("/shuttle/all", shuttleHandler.GetAllShuttleByDriver)
-	protectedDriver.Post("/shuttle/add", shuttleHandler.AddShuttle)
-	protectedDriver.Get("/shuttle/:id", shuttleHandler.GetSpecShuttle)
-	protectedDriver.Put("/shuttle/update/:id", shuttleHandler.EditShuttle) 
-}
\ No newline at end of file
+	protectedDriver.Get("/route/all", can(services.PermissionRouteRead), routeHandler.GetAllRoutesByDriver)
+
+	protectedParent.Get("/my/childern/track", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleTrackByParent) //buat menu track
+	protectedParent.Get("/my/childern/all", can(services.PermissionChildrenRead), childernHandler.GetAllChilderns) //buat menu apalah
+	protectedParent.Get("/my/childern/shuttle/:id", can(services.PermissionShuttleRead), shuttleHandler.GetSpecShuttle) //buat menu opo jeneng e lali😂 (spec shutle)
+	protectedParent.Get("/my/childern/shuttle/:id/trail", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleTrail)
+	protectedParent.Get("/my/childern/shuttle/:id/location", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleLastLocation)
+	protectedParent.Get("/my/childern/shuttle/:id/eta", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleETA)
+	protectedParent.Get("/my/childern/shuttle/:id/timeline", can(services.PermissionShuttleRead), shuttleHandler.GetShuttleTimeline)
+	protectedParent.Get("/my/childern/:id/timeline", can(services.PermissionShuttleRead), shuttleHandler.GetStudentTimeline)
+	protectedParent.Get("/my/childern/recap", can(services.PermissionShuttleRead), shuttleHandler.GetAllShuttleByParent) //buat menu recap
+	protectedParent.Get("/my/childern/pickup/next", can(services.PermissionChildrenRead), calendarHandler.GetNextPickups)
+	protectedParent.Get("/my/childern/:id", can(services.PermissionChildrenRead), childernHandler.GetSpecChildern) //nih katanya butuh spec
+	protectedParent.Put("/my/childern/update/:id", can(services.PermissionChildrenWrite), childernHandler.UpdateChildern) //menu update nih tampling
+	protectedParent.Put("/my/childern/status/update/:id", can(services.PermissionChildrenWrite), childernHandler.UpdateChildernStatus) //menu update nih tampling
+
+	protectedDriver.Get("/shuttle/all", can(services.PermissionShuttleRead), shuttleHandler.GetAllShuttleByDriver)
+	protectedDriver.Post("/shuttle/add", can(services.PermissionShuttleWrite), shuttleHandler.AddShuttle)
+	protectedDriver.Get("/shuttle/:id", can(services.PermissionShuttleRead), shuttleHandler.GetSpecShuttle)
+	protectedDriver.Put("/shuttle/update/:id", can(services.PermissionShuttleWrite), shuttleHandler.EditShuttle) 
+}
+
+// Push notifications go through FCM when Firebase is configured, otherwise they are only logged
+func newNotifier() utils.Notifier {
+	if utils.FirebaseApp != nil {
+		notifier, err := utils.NewFCMNotifier(utils.FirebaseApp)
+		if err == nil {
+			return notifier
+		}
+		log.Println("Failed to initialize FCM, push notifications are disabled:", err)
+	}
+
+	return utils.NewLogNotifier()
+}
+
+// MAILER=smtp sends through SMTP_HOST, anything else writes mails to MAIL_DIR
+func newMailer() services.MailSender {
+	from := viper.GetString("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@shuttle.local"
+	}
+
+	if viper.GetString("MAILER") == "smtp" {
+		return utils.NewSMTPMailer(viper.GetString("SMTP_HOST"), viper.GetInt("SMTP_PORT"), viper.GetString("SMTP_USERNAME"), viper.GetString("SMTP_PASSWORD"), from)
+	}
+
+	dir := viper.GetString("MAIL_DIR")
+	if dir == "" {
+		dir = "./mail"
+	}
+	return utils.NewFileMailer(dir, from)
+}
+
+// SMS_SENDER=twilio sends through Twilio, console writes messages to the log and is only honoured
+// with APP_ENV=development since it logs login codes. Anything else disables OTP login.
+func newSMSSender() services.SMSSender {
+	switch viper.GetString("SMS_SENDER") {
+	case "twilio":
+		return utils.NewTwilioSMSSender(viper.GetString("TWILIO_ACCOUNT_SID"), viper.GetString("TWILIO_AUTH_TOKEN"), viper.GetString("TWILIO_FROM_NUMBER"))
+	case "console":
+		if viper.GetString("APP_ENV") == "development" {
+			return utils.NewConsoleSMSSender()
+		}
+		log.Println("SMS_SENDER=console is only allowed with APP_ENV=development, OTP login is disabled")
+	default:
+		log.Println("No SMS sender is configured, OTP login is disabled")
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditActionAccountLocked   = "account_locked"
+	AuditActionAccountUnlocked = "account_unlocked"
+
+	AuditActionImpersonationStarted = "impersonation_started"
+	AuditActionImpersonatedRequest  = "impersonated_request"
+)
+
+type AuditServiceInterface interface {
+	Record(entry dto.AuditEntry)
+}
+
+type AuditService struct {
+	auditRepository repositories.AuditRepositoryInterface
+}
+
+func NewAuditService(auditRepository repositories.AuditRepositoryInterface) AuditServiceInterface {
+	return &AuditService{
+		auditRepository: auditRepository,
+	}
+}
+
+// Audit logging never fails the action being audited, errors are only logged
+func (s *AuditService) Record(entry dto.AuditEntry) {
+	auditLog := entity.AuditLog{
+		AuditID:    time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		ActorUUID:  sql.NullString{String: entry.ActorUUID, Valid: entry.ActorUUID != ""},
+		ActorName:  sql.NullString{String: entry.ActorName, Valid: entry.ActorName != ""},
+		Action:     entry.Action,
+		TargetType: sql.NullString{String: entry.TargetType, Valid: entry.TargetType != ""},
+		TargetID:   sql.NullString{String: entry.TargetID, Valid: entry.TargetID != ""},
+		IPAddress:  sql.NullString{String: entry.IPAddress, Valid: entry.IPAddress != ""},
+		CreatedAt:  time.Now(),
+	}
+	if len(entry.Details) > 0 {
+		if details, err := json.Marshal(entry.Details); err == nil {
+			auditLog.Details = sql.NullString{String: string(details), Valid: true}
+		}
+	}
+
+	if err := s.auditRepository.SaveAuditLog(auditLog); err != nil {
+		logger.LogError(err, "Failed to save audit log", map[string]interface{}{
+			"action": entry.Action,
+		})
+	}
+}
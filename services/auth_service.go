@@ -2,13 +2,20 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"time"
 
 	"shuttle/errors"
 	"shuttle/logger"
 	"shuttle/models/dto"
+	"shuttle/models/entity"
 	"shuttle/repositories"
 
 	"github.com/google/uuid"
@@ -17,32 +24,66 @@ import (
 )
 
 type AuthServiceInterface interface {
-	Login(email, password string) (userDataa dto.UserDataOnLoginDTO, err error)
+	Login(email, password, ip string) (userDataa dto.UserDataOnLoginDTO, err error)
 	GetMyProfile(userUUID, roleCode string) (interface{}, error)
-	CheckStoredRefreshToken(userID string, refreshToken string) error
-	DeleteRefreshTokenOnLogout(ctx context.Context, userID string) error
+	RotateRefreshToken(userUUID, presentedToken, newToken string, expiresAt time.Time, device dto.SessionDevice) (string, error)
+	GetSessions(userUUID, currentSessionUUID string) ([]dto.SessionResponse, error)
+	RevokeSession(userUUID, sessionUUID string) error
+	RevokeAllSessions(userUUID string) ([]string, error)
+	DeleteRefreshTokenOnLogout(ctx context.Context, userID, sessionUUID string) error
+	ChangePassword(userUUID, username, currentSessionUUID string, req dto.ChangePasswordRequest) ([]string, error)
+	RequestPasswordReset(email string) error
+	ResetPassword(req dto.ResetPasswordRequest) (string, []string, error)
+	UnlockAccount(userUUID, actorUUID, actorName, ip string) error
+	RequestOTP(phone string) error
+	VerifyOTP(phone, code string) (dto.UserDataOnLoginDTO, error)
+	Impersonate(userUUID, actorUUID, actorName, ip string) (dto.UserDataOnLoginDTO, error)
 	UpdateUserStatus(userUUID, status string, lastActive time.Time) error
-	UpdateRefreshToken(userUUID, refreshToken string) error
 }
 
 type AuthService struct {
 	authRepository repositories.AuthRepositoryInterface
 	userRepository repositories.UserRepositoryInterface
+	auditService   AuditServiceInterface
+	mailer         MailSender
+	smsSender      SMSSender
+	sessionRevoker SessionRevoker
 }
 
-func NewAuthService(authRepository repositories.AuthRepositoryInterface, userRepository repositories.UserRepositoryInterface) AuthService {
+// Rejects the access tokens already issued for a session, implemented by utils.SessionTokenRevoker
+type SessionRevoker interface {
+	RevokeSession(sessionUUID, userUUID string) error
+}
+
+// Delivers plain text mail, implemented by utils.FileMailer and utils.SMTPMailer
+type MailSender interface {
+	Send(to, subject, body string) error
+}
+
+func NewAuthService(authRepository repositories.AuthRepositoryInterface, userRepository repositories.UserRepositoryInterface, auditService AuditServiceInterface, mailer MailSender, smsSender SMSSender, sessionRevoker SessionRevoker) AuthService {
 	return AuthService{
 		authRepository: authRepository,
 		userRepository: userRepository,
+		auditService:   auditService,
+		mailer:         mailer,
+		smsSender:      smsSender,
+		sessionRevoker: sessionRevoker,
 	}
 }
 
-func (service AuthService) Login(email, password string) (userData dto.UserDataOnLoginDTO, err error) {
+func (service AuthService) Login(email, password, ip string) (userData dto.UserDataOnLoginDTO, err error) {
+	if err := service.checkLoginAllowed(email, ip); err != nil {
+		return dto.UserDataOnLoginDTO{}, err
+	}
+
 	user, err := service.authRepository.Login(email)
 	if err != nil {
 		logger.LogError(err, "Failed to login", map[string]interface{}{
 			"email": email,
 		})
+		if err == sql.ErrNoRows {
+			service.recordLoginFailure(email, ip)
+		}
 		return dto.UserDataOnLoginDTO{}, errors.New("invalid email or password", 0)
 	}
 
@@ -55,9 +96,12 @@ func (service AuthService) Login(email, password string) (userData dto.UserDataO
 	}
 
 	if !validatePassword(password, userDataOnLogin.Password) {
+		service.recordLoginFailure(email, ip)
 		return dto.UserDataOnLoginDTO{}, errors.New("invalid email or password", 0)
 	}
 
+	service.clearLoginFailures(email)
+
 	return userDataOnLogin, nil
 }
 
@@ -198,25 +242,151 @@ func (service *AuthService) GetMyProfile(userUUID, roleCode string) (interface{}
 	return result, nil
 }
 
-func (service *AuthService) CheckStoredRefreshToken(userUUID string, refreshToken string) error {
-	refreshTokenEntity, err := service.authRepository.CheckRefreshTokenData(userUUID, refreshToken)
+// Returned with the session UUID when a refresh token is presented twice, the session is revoked by then
+var ErrRefreshTokenReused = errors.New("refresh token has already been used", 401)
+
+// Swap a refresh token for a new one of the same family (session) and return the session UUID.
+// A token can be used only once: presenting it again means it was copied, so the whole family
+// is revoked and the user has to log in again.
+func (service *AuthService) RotateRefreshToken(userUUID, presentedToken, newToken string, expiresAt time.Time, device dto.SessionDevice) (string, error) {
+	tx, err := service.authRepository.BeginTransaction()
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stored, err := service.authRepository.FetchRefreshTokenForUpdate(tx, userUUID, presentedToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("invalid refresh token", 401)
+		}
+		return "", err
+	}
+
+	if stored.Revoked {
+		return "", errors.New("refresh token has been revoked", 401)
+	}
+
+	if stored.Used {
+		if err := service.authRepository.RevokeRefreshTokenFamily(tx, stored.FamilyUUID); err != nil {
+			return "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", err
+		}
+
+		logger.LogWarn("Refresh token reuse detected, token family revoked", map[string]interface{}{
+			"user_uuid":   userUUID,
+			"family_uuid": stored.FamilyUUID.String(),
+		})
+		// Access tokens of the session are still out there, whoever copied the refresh token may hold one
+		if err := service.sessionRevoker.RevokeSession(stored.FamilyUUID.String(), userUUID); err != nil {
+			logger.LogError(err, "Failed to revoke access tokens of reused session", map[string]interface{}{
+				"user_uuid": userUUID,
+			})
+		}
+		return stored.FamilyUUID.String(), ErrRefreshTokenReused
+	}
+
+	if stored.ExpiredAt.Before(time.Now()) {
+		return "", errors.New("refresh token has expired", 401)
+	}
+
+	if err := service.authRepository.MarkRefreshTokenUsed(tx, stored.ID); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	rotated := entity.RefreshToken{
+		ID:               now.UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		UserUUID:         stored.UserUUID,
+		FamilyUUID:       stored.FamilyUUID,
+		RefreshToken:     newToken,
+		ExpiredAt:        expiresAt,
+		LastUsedAt:       &now,
+		DeviceName:       stored.DeviceName,
+		IPAddress:        sql.NullString{String: device.IPAddress, Valid: device.IPAddress != ""},
+		UserAgent:        sql.NullString{String: device.UserAgent, Valid: device.UserAgent != ""},
+		SessionCreatedAt: stored.SessionCreatedAt,
+	}
+	if err := service.authRepository.SaveRotatedRefreshToken(tx, rotated); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return stored.FamilyUUID.String(), nil
+}
+
+func (service *AuthService) GetSessions(userUUID, currentSessionUUID string) ([]dto.SessionResponse, error) {
+	sessions, err := service.authRepository.FetchActiveSessions(userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		lastUsedAt := session.IssuedAt
+		if session.LastUsedAt != nil {
+			lastUsedAt = *session.LastUsedAt
+		}
+
+		result = append(result, dto.SessionResponse{
+			SessionUUID: session.FamilyUUID.String(),
+			DeviceName:  nullStringPointer(session.DeviceName),
+			IPAddress:   nullStringPointer(session.IPAddress),
+			UserAgent:   nullStringPointer(session.UserAgent),
+			CreatedAt:   session.SessionCreatedAt.Format(time.RFC3339),
+			LastUsedAt:  lastUsedAt.Format(time.RFC3339),
+			Current:     session.FamilyUUID.String() == currentSessionUUID,
+		})
 	}
 
-	if refreshTokenEntity.RefreshToken != refreshToken {
-		return errors.New("invalid refresh token", 0)
+	return result, nil
+}
+
+func (service *AuthService) RevokeSession(userUUID, sessionUUID string) error {
+	familyUUID, err := uuid.Parse(sessionUUID)
+	if err != nil {
+		return errors.New("invalid session UUID format", 400)
 	}
 
-	if refreshTokenEntity.ExpiredAt.Before(time.Now()) {
-		return errors.New("refresh token has expired", 0)
+	if err := service.authRepository.RevokeUserSession(userUUID, familyUUID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("session not found", 404)
+		}
+		return err
 	}
 
 	return nil
 }
 
-func (service *AuthService) DeleteRefreshTokenOnLogout(ctx context.Context, userUUID string) error {
-	err := service.authRepository.DeleteRefreshToken(ctx, userUUID)
+// Revoke every session of the user and return their UUIDs
+func (service *AuthService) RevokeAllSessions(userUUID string) ([]string, error) {
+	families, err := service.authRepository.RevokeAllUserSessions(userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return familiesToStrings(families), nil
+}
+
+func nullStringPointer(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+func (service *AuthService) DeleteRefreshTokenOnLogout(ctx context.Context, userUUID, sessionUUID string) error {
+	familyUUID, err := uuid.Parse(sessionUUID)
+	if err != nil {
+		return err
+	}
+
+	err = service.authRepository.DeleteSessionRefreshTokens(ctx, userUUID, familyUUID)
 	if err != nil {
 		return err
 	}
@@ -233,24 +403,219 @@ func (service *AuthService) UpdateUserStatus(userUUID, status string, lastActive
 	return nil
 }
 
-func (service *AuthService) UpdateRefreshToken(userUUID, refreshToken string) error {
-	tokendata, err := service.authRepository.CheckRefreshTokenData(userUUID, refreshToken)
+const passwordResetTTL = 30 * time.Minute
+
+// Change the password of a logged in user and end every other session, returning their UUIDs
+func (service *AuthService) ChangePassword(userUUID, username, currentSessionUUID string, req dto.ChangePasswordRequest) ([]string, error) {
+	userUUIDParsed, err := uuid.Parse(userUUID)
 	if err != nil {
-		return err
+		return nil, errors.New("invalid user UUID format", 400)
+	}
+	currentFamily, err := uuid.Parse(currentSessionUUID)
+	if err != nil {
+		return nil, errors.New("invalid session", 401)
 	}
 
-	if tokendata.LastUsedAt != nil && time.Since(*tokendata.LastUsedAt) > time.Hour {
-		_, err := service.authRepository.UpdateRefreshToken(userUUID, refreshToken)
-		if err != nil {
-			return err
+	storedPassword, err := service.authRepository.FetchUserPassword(userUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user not found", 404)
 		}
-	} else {
-		return errors.New("cannot reissue a new access token yet", 0)
+		return nil, err
+	}
+
+	if !validatePassword(req.CurrentPassword, storedPassword) {
+		return nil, errors.New("current password is incorrect", 400)
+	}
+
+	hashedPassword, err := hashPassword(req.NewPassword)
+	if err != nil {
+		return nil, err
 	}
 
+	tx, err := service.authRepository.BeginTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := service.authRepository.UpdateUserPassword(tx, userUUIDParsed, hashedPassword, username); err != nil {
+		return nil, err
+	}
+
+	families, err := service.authRepository.RevokeOtherUserSessions(tx, userUUIDParsed, currentFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return familiesToStrings(families), nil
+}
+
+// Mail a single-use reset link. Unknown addresses are ignored silently so the
+// endpoint can't be used to find out which emails have an account.
+func (service *AuthService) RequestPasswordReset(email string) error {
+	user, err := service.authRepository.Login(email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	userUUID, err := uuid.Parse(user.UUID)
+	if err != nil {
+		return err
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	resetToken := entity.PasswordResetToken{
+		ID:        time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		UserUUID:  userUUID,
+		TokenHash: hashResetToken(token),
+		ExpiredAt: time.Now().Add(passwordResetTTL),
+		CreatedAt: time.Now(),
+	}
+	if err := service.authRepository.SavePasswordResetToken(resetToken); err != nil {
+		return err
+	}
+
+	link := token
+	if resetURL := viper.GetString("RESET_PASSWORD_URL"); resetURL != "" {
+		link = fmt.Sprintf(resetURL, token)
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse the link below to set a new password. It can be used once and expires in %d minutes.\n\n%s\n\nIf you didn't ask for a password reset, you can ignore this email.\n",
+		user.Username, int(passwordResetTTL.Minutes()), link)
+
+	// Sent in the background so the response time doesn't tell whether the email exists
+	go func() {
+		if err := service.mailer.Send(email, "Reset your password", body); err != nil {
+			logger.LogError(err, "Failed to send password reset email", map[string]interface{}{
+				"user_uuid": user.UUID,
+			})
+		}
+	}()
+
 	return nil
 }
 
+// Set a new password with a reset token and end every session of the user.
+// Returns the user UUID and the UUIDs of the revoked sessions.
+func (service *AuthService) ResetPassword(req dto.ResetPasswordRequest) (string, []string, error) {
+	invalidToken := errors.New("reset token is invalid or has expired", 400)
+
+	tx, err := service.authRepository.BeginTransaction()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	resetToken, err := service.authRepository.FetchPasswordResetTokenForUpdate(tx, hashResetToken(req.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, invalidToken
+		}
+		return "", nil, err
+	}
+
+	if resetToken.UsedAt.Valid || resetToken.ExpiredAt.Before(time.Now()) {
+		return "", nil, invalidToken
+	}
+
+	hashedPassword, err := hashPassword(req.NewPassword)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := service.authRepository.UpdateUserPassword(tx, resetToken.UserUUID, hashedPassword, "password_reset"); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, invalidToken
+		}
+		return "", nil, err
+	}
+
+	if err := service.authRepository.MarkPasswordResetTokensUsed(tx, resetToken.UserUUID); err != nil {
+		return "", nil, err
+	}
+
+	families, err := service.authRepository.RevokeOtherUserSessions(tx, resetToken.UserUUID, uuid.Nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+
+	return resetToken.UserUUID.String(), familiesToStrings(families), nil
+}
+
+// Look up the user a super admin wants to act as. Other super admins can't be impersonated,
+// that would hand out their permissions without their password.
+func (service *AuthService) Impersonate(userUUID, actorUUID, actorName, ip string) (dto.UserDataOnLoginDTO, error) {
+	if userUUID == actorUUID {
+		return dto.UserDataOnLoginDTO{}, errors.New("you can't impersonate yourself", 400)
+	}
+
+	parsedUUID, err := uuid.Parse(userUUID)
+	if err != nil {
+		return dto.UserDataOnLoginDTO{}, errors.New("invalid user UUID format", 400)
+	}
+
+	user, err := service.authRepository.FetchLoginUser(parsedUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return dto.UserDataOnLoginDTO{}, errors.New("user not found", 404)
+		}
+		return dto.UserDataOnLoginDTO{}, err
+	}
+
+	if user.RoleCode == "SA" {
+		return dto.UserDataOnLoginDTO{}, errors.New("super admins can't be impersonated", 403)
+	}
+
+	service.auditService.Record(dto.AuditEntry{
+		ActorUUID:  actorUUID,
+		ActorName:  actorName,
+		Action:     AuditActionImpersonationStarted,
+		TargetType: "user",
+		TargetID:   userUUID,
+		IPAddress:  ip,
+		Details: map[string]interface{}{
+			"role_code": user.RoleCode,
+		},
+	})
+
+	return dto.UserDataOnLoginDTO{
+		UserID:   user.ID,
+		UserUUID: user.UUID,
+		Username: user.Username,
+		RoleCode: user.RoleCode,
+	}, nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func familiesToStrings(families []uuid.UUID) []string {
+	sessionUUIDs := make([]string, 0, len(families))
+	for _, family := range families {
+		sessionUUIDs = append(sessionUUIDs, family.String())
+	}
+
+	return sessionUUIDs
+}
+
 func generateImageURL(imagePath string) (string, error) {
 	fileName := filepath.Base(imagePath)
 	allowedExtensions := []string{".jpg", ".jpeg", ".png"}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Just enough of a database/sql driver to hand out transactions that commit and roll back
+type txOnlyDriver struct{}
+type txOnlyConn struct{}
+type txOnlyTx struct{}
+
+func (txOnlyDriver) Open(name string) (driver.Conn, error) { return txOnlyConn{}, nil }
+
+func (txOnlyConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("queries are not supported")
+}
+func (txOnlyConn) Close() error              { return nil }
+func (txOnlyConn) Begin() (driver.Tx, error) { return txOnlyTx{}, nil }
+
+func (txOnlyTx) Commit() error   { return nil }
+func (txOnlyTx) Rollback() error { return nil }
+
+func init() {
+	sql.Register("tx-only", txOnlyDriver{})
+}
+
+// Keeps the refresh token rows in memory, other repository methods aren't used by rotation
+type fakeAuthRepository struct {
+	repositories.AuthRepositoryInterface
+
+	db              *sqlx.DB
+	token           entity.RefreshToken
+	revokedFamilies []uuid.UUID
+	markedUsed      []int64
+	saved           []entity.RefreshToken
+}
+
+func (r *fakeAuthRepository) BeginTransaction() (*sqlx.Tx, error) {
+	return r.db.Beginx()
+}
+
+func (r *fakeAuthRepository) FetchRefreshTokenForUpdate(tx *sqlx.Tx, userUUID, token string) (entity.RefreshToken, error) {
+	if token != r.token.RefreshToken || userUUID != r.token.UserUUID.String() {
+		return entity.RefreshToken{}, sql.ErrNoRows
+	}
+	return r.token, nil
+}
+
+func (r *fakeAuthRepository) SaveRotatedRefreshToken(tx *sqlx.Tx, refreshToken entity.RefreshToken) error {
+	r.saved = append(r.saved, refreshToken)
+	return nil
+}
+
+func (r *fakeAuthRepository) MarkRefreshTokenUsed(tx *sqlx.Tx, id int64) error {
+	r.markedUsed = append(r.markedUsed, id)
+	return nil
+}
+
+func (r *fakeAuthRepository) RevokeRefreshTokenFamily(tx *sqlx.Tx, familyUUID uuid.UUID) error {
+	r.revokedFamilies = append(r.revokedFamilies, familyUUID)
+	return nil
+}
+
+type fakeSessionRevoker struct {
+	sessions []string
+}
+
+func (r *fakeSessionRevoker) RevokeSession(sessionUUID, userUUID string) error {
+	r.sessions = append(r.sessions, sessionUUID)
+	return nil
+}
+
+func newRotationTest(t *testing.T, token entity.RefreshToken) (*AuthService, *fakeAuthRepository, *fakeSessionRevoker) {
+	t.Helper()
+
+	db, err := sqlx.Open("tx-only", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository := &fakeAuthRepository{db: db, token: token}
+	revoker := &fakeSessionRevoker{}
+	service := NewAuthService(repository, nil, nil, nil, nil, revoker)
+
+	return &service, repository, revoker
+}
+
+func storedRefreshToken() entity.RefreshToken {
+	return entity.RefreshToken{
+		ID:               1,
+		UserUUID:         uuid.New(),
+		FamilyUUID:       uuid.New(),
+		RefreshToken:     "presented-token",
+		ExpiredAt:        time.Now().Add(time.Hour),
+		SessionCreatedAt: time.Now().Add(-time.Hour),
+	}
+}
+
+func TestRotateRefreshTokenIssuesTokenOfSameFamily(t *testing.T) {
+	token := storedRefreshToken()
+	service, repository, revoker := newRotationTest(t, token)
+
+	sessionUUID, err := service.RotateRefreshToken(token.UserUUID.String(), token.RefreshToken, "new-token", time.Now().Add(time.Hour), dto.SessionDevice{})
+	if err != nil {
+		t.Fatalf("expected rotation to succeed, got %v", err)
+	}
+
+	if sessionUUID != token.FamilyUUID.String() {
+		t.Fatalf("expected session %s, got %s", token.FamilyUUID, sessionUUID)
+	}
+	if len(repository.markedUsed) != 1 || repository.markedUsed[0] != token.ID {
+		t.Fatalf("expected the presented token to be marked used, got %v", repository.markedUsed)
+	}
+	if len(repository.saved) != 1 || repository.saved[0].FamilyUUID != token.FamilyUUID || repository.saved[0].RefreshToken != "new-token" {
+		t.Fatalf("expected the new token to join the family, got %+v", repository.saved)
+	}
+	if len(repository.revokedFamilies) != 0 || len(revoker.sessions) != 0 {
+		t.Fatal("expected nothing to be revoked")
+	}
+}
+
+func TestRotateRefreshTokenReuseRevokesFamily(t *testing.T) {
+	token := storedRefreshToken()
+	token.Used = true
+	service, repository, revoker := newRotationTest(t, token)
+
+	sessionUUID, err := service.RotateRefreshToken(token.UserUUID.String(), token.RefreshToken, "new-token", time.Now().Add(time.Hour), dto.SessionDevice{})
+	if err != ErrRefreshTokenReused {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	if sessionUUID != token.FamilyUUID.String() {
+		t.Fatalf("expected session %s, got %s", token.FamilyUUID, sessionUUID)
+	}
+	if len(repository.revokedFamilies) != 1 || repository.revokedFamilies[0] != token.FamilyUUID {
+		t.Fatalf("expected family %s to be revoked, got %v", token.FamilyUUID, repository.revokedFamilies)
+	}
+	if len(revoker.sessions) != 1 || revoker.sessions[0] != token.FamilyUUID.String() {
+		t.Fatalf("expected the session's access tokens to be revoked, got %v", revoker.sessions)
+	}
+	if len(repository.saved) != 0 || len(repository.markedUsed) != 0 {
+		t.Fatal("expected no token to be issued for a reused one")
+	}
+}
+
+func TestRotateRefreshTokenRejectsUnusableTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(token *entity.RefreshToken)
+	}{
+		{"revoked", func(token *entity.RefreshToken) { token.Revoked = true }},
+		{"revoked and used", func(token *entity.RefreshToken) { token.Revoked = true; token.Used = true }},
+		{"expired", func(token *entity.RefreshToken) { token.ExpiredAt = time.Now().Add(-time.Minute) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := storedRefreshToken()
+			test.modify(&token)
+			service, repository, revoker := newRotationTest(t, token)
+
+			_, err := service.RotateRefreshToken(token.UserUUID.String(), token.RefreshToken, "new-token", time.Now().Add(time.Hour), dto.SessionDevice{})
+			if err == nil {
+				t.Fatal("expected the token to be rejected")
+			}
+
+			if len(repository.saved) != 0 || len(repository.markedUsed) != 0 {
+				t.Fatal("expected no token to be issued")
+			}
+			if len(repository.revokedFamilies) != 0 || len(revoker.sessions) != 0 {
+				t.Fatal("expected a rejected token not to revoke the session")
+			}
+		})
+	}
+}
+
+func TestRotateRefreshTokenRejectsUnknownToken(t *testing.T) {
+	token := storedRefreshToken()
+	service, repository, _ := newRotationTest(t, token)
+
+	_, err := service.RotateRefreshToken(token.UserUUID.String(), "forged-token", "new-token", time.Now().Add(time.Hour), dto.SessionDevice{})
+	if err == nil {
+		t.Fatal("expected an unknown token to be rejected")
+	}
+	if len(repository.saved) != 0 {
+		t.Fatal("expected no token to be issued")
+	}
+}
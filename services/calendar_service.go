@@ -0,0 +1,509 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultScheduleExportDays = 90
+	maxScheduleExportDays     = 366
+
+	// How far ahead the next pickup of a child is looked for
+	nextPickupSearchDays = 366
+)
+
+type CalendarServiceInterface interface {
+	GetSchoolCalendar(schoolUUID string) (dto.SchoolCalendarResponseDTO, error)
+	UpdateOperatingWeekdays(schoolUUID, username string, req dto.SchoolCalendarWeekdaysRequestDTO) error
+	AddSchoolTerm(schoolUUID, username string, req dto.SchoolTermRequestDTO) (string, error)
+	DeleteSchoolTerm(termUUID, schoolUUID string) error
+	AddSchoolClosure(schoolUUID, username string, req dto.SchoolClosureRequestDTO) (string, error)
+	DeleteSchoolClosure(closureUUID, schoolUUID string) error
+	ImportSchoolCalendar(schoolUUID, username string, reader io.Reader) (dto.CalendarImportResponseDTO, error)
+	ExportRouteSchedules(schoolUUID, routenameUUID string, days int) (string, error)
+	GetNextPickupsByParent(parentUUID string) ([]dto.NextPickupResponseDTO, error)
+	ClosedReason(schoolUUID string, day time.Time) (string, error)
+}
+
+type CalendarService struct {
+	calendarRepository repositories.CalendarRepositoryInterface
+}
+
+func NewCalendarService(calendarRepository repositories.CalendarRepositoryInterface) CalendarServiceInterface {
+	return &CalendarService{
+		calendarRepository: calendarRepository,
+	}
+}
+
+// When a school runs its routes: on its operating weekdays within its terms, except on
+// holidays and closures. A school without terms runs all year.
+type operatingCalendar struct {
+	weekdays map[time.Weekday]bool
+	terms    []entity.SchoolTerm
+	closures []entity.SchoolClosure
+}
+
+// Why no route runs on the day, empty on operating days
+func (calendar operatingCalendar) closedReason(day time.Time) string {
+	day = civilDate(day)
+
+	for _, closure := range calendar.closures {
+		if !day.Before(civilDate(closure.StartDate)) && !day.After(civilDate(closure.EndDate)) {
+			return closure.Name
+		}
+	}
+
+	if !calendar.weekdays[day.Weekday()] {
+		return "not an operating day"
+	}
+
+	if len(calendar.terms) == 0 {
+		return ""
+	}
+	for _, term := range calendar.terms {
+		if !day.Before(civilDate(term.StartDate)) && !day.After(civilDate(term.EndDate)) {
+			return ""
+		}
+	}
+	return "outside of term"
+}
+
+func (s *CalendarService) loadCalendar(schoolUUID string) (operatingCalendar, error) {
+	weekdays := entity.DefaultOperatingWeekdays
+	calendar, err := s.calendarRepository.FetchSchoolCalendar(schoolUUID)
+	if err != nil && err != sql.ErrNoRows {
+		return operatingCalendar{}, err
+	}
+	if err == nil {
+		weekdays = calendar.OperatingWeekdays
+	}
+
+	terms, err := s.calendarRepository.FetchSchoolTerms(schoolUUID)
+	if err != nil {
+		return operatingCalendar{}, err
+	}
+
+	closures, err := s.calendarRepository.FetchSchoolClosures(schoolUUID)
+	if err != nil {
+		return operatingCalendar{}, err
+	}
+
+	operating := operatingCalendar{
+		weekdays: make(map[time.Weekday]bool, len(weekdays)),
+		terms:    terms,
+		closures: closures,
+	}
+	for _, weekday := range weekdays {
+		operating.weekdays[isoWeekday(int(weekday))] = true
+	}
+	return operating, nil
+}
+
+func (s *CalendarService) ClosedReason(schoolUUID string, day time.Time) (string, error) {
+	calendar, err := s.loadCalendar(schoolUUID)
+	if err != nil {
+		return "", err
+	}
+	return calendar.closedReason(day), nil
+}
+
+func (s *CalendarService) GetSchoolCalendar(schoolUUID string) (dto.SchoolCalendarResponseDTO, error) {
+	calendar, err := s.loadCalendar(schoolUUID)
+	if err != nil {
+		return dto.SchoolCalendarResponseDTO{}, err
+	}
+
+	response := dto.SchoolCalendarResponseDTO{
+		OperatingWeekdays: []int{},
+		Terms:             []dto.SchoolTermResponseDTO{},
+		Closures:          []dto.SchoolClosureResponseDTO{},
+	}
+	for weekday := 1; weekday <= 7; weekday++ {
+		if calendar.weekdays[isoWeekday(weekday)] {
+			response.OperatingWeekdays = append(response.OperatingWeekdays, weekday)
+		}
+	}
+	for _, term := range calendar.terms {
+		response.Terms = append(response.Terms, dto.SchoolTermResponseDTO{
+			TermUUID:  term.TermUUID.String(),
+			Name:      term.Name,
+			StartDate: term.StartDate.Format("2006-01-02"),
+			EndDate:   term.EndDate.Format("2006-01-02"),
+		})
+	}
+	for _, closure := range calendar.closures {
+		response.Closures = append(response.Closures, dto.SchoolClosureResponseDTO{
+			ClosureUUID: closure.ClosureUUID.String(),
+			Name:        closure.Name,
+			Type:        closure.Type,
+			StartDate:   closure.StartDate.Format("2006-01-02"),
+			EndDate:     closure.EndDate.Format("2006-01-02"),
+		})
+	}
+
+	return response, nil
+}
+
+func (s *CalendarService) UpdateOperatingWeekdays(schoolUUID, username string, req dto.SchoolCalendarWeekdaysRequestDTO) error {
+	unique := make(map[int]bool)
+	var weekdays pq.Int64Array
+	for _, weekday := range req.OperatingWeekdays {
+		if !unique[weekday] {
+			unique[weekday] = true
+			weekdays = append(weekdays, int64(weekday))
+		}
+	}
+	sort.Slice(weekdays, func(i, j int) bool { return weekdays[i] < weekdays[j] })
+
+	return s.calendarRepository.SaveOperatingWeekdays(entity.SchoolCalendar{
+		SchoolUUID:        uuid.MustParse(schoolUUID),
+		OperatingWeekdays: weekdays,
+		CreatedAt:         sql.NullTime{Time: time.Now(), Valid: true},
+		CreatedBy:         sql.NullString{String: username, Valid: true},
+	})
+}
+
+func (s *CalendarService) AddSchoolTerm(schoolUUID, username string, req dto.SchoolTermRequestDTO) (string, error) {
+	startDate, endDate, err := parseDateRange(req.StartDate, req.EndDate)
+	if err != nil {
+		return "", err
+	}
+
+	term := newSchoolTerm(schoolUUID, username, req.Name, startDate, endDate, sql.NullString{})
+	if err := s.saveInTransaction(func(tx *sqlx.Tx) error { return s.calendarRepository.SaveSchoolTerm(tx, term) }); err != nil {
+		return "", err
+	}
+	return term.TermUUID.String(), nil
+}
+
+func (s *CalendarService) DeleteSchoolTerm(termUUID, schoolUUID string) error {
+	deleted, err := s.calendarRepository.DeleteSchoolTerm(termUUID, schoolUUID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("term not found", 404)
+	}
+	return nil
+}
+
+func (s *CalendarService) AddSchoolClosure(schoolUUID, username string, req dto.SchoolClosureRequestDTO) (string, error) {
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+	startDate, endDate, err := parseDateRange(req.StartDate, req.EndDate)
+	if err != nil {
+		return "", err
+	}
+
+	closureType := req.Type
+	if closureType == "" {
+		closureType = entity.ClosureTypeHoliday
+	}
+
+	closure := newSchoolClosure(schoolUUID, username, req.Name, closureType, startDate, endDate, sql.NullString{})
+	if err := s.saveInTransaction(func(tx *sqlx.Tx) error { return s.calendarRepository.SaveSchoolClosure(tx, closure) }); err != nil {
+		return "", err
+	}
+	return closure.ClosureUUID.String(), nil
+}
+
+func (s *CalendarService) DeleteSchoolClosure(closureUUID, schoolUUID string) error {
+	deleted, err := s.calendarRepository.DeleteSchoolClosure(closureUUID, schoolUUID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("closure not found", 404)
+	}
+	return nil
+}
+
+// Imports the events of an iCalendar file. Events categorized as TERM become terms, those
+// categorized as CLOSURE one-off closures and every other event a holiday. Importing the same
+// file again updates the events imported before instead of adding them twice.
+func (s *CalendarService) ImportSchoolCalendar(schoolUUID, username string, reader io.Reader) (dto.CalendarImportResponseDTO, error) {
+	events, skipped, err := parseICalEvents(reader)
+	if err != nil {
+		return dto.CalendarImportResponseDTO{}, errors.New("invalid calendar file: "+err.Error(), 400)
+	}
+
+	tx, err := s.calendarRepository.BeginTransaction()
+	if err != nil {
+		return dto.CalendarImportResponseDTO{}, err
+	}
+	defer tx.Rollback()
+
+	response := dto.CalendarImportResponseDTO{Skipped: skipped}
+	for _, event := range events {
+		if event.Status == "CANCELLED" {
+			response.Skipped++
+			continue
+		}
+
+		name := event.Summary
+		if name == "" {
+			name = "Untitled"
+		}
+		sourceUID := sql.NullString{String: event.UID, Valid: true}
+
+		switch {
+		case contains(event.Categories, "TERM"):
+			term := newSchoolTerm(schoolUUID, username, name, event.StartDate, event.EndDate, sourceUID)
+			if err := s.calendarRepository.SaveSchoolTerm(tx, term); err != nil {
+				return dto.CalendarImportResponseDTO{}, err
+			}
+			response.Terms++
+		default:
+			closureType := entity.ClosureTypeHoliday
+			if contains(event.Categories, "CLOSURE") {
+				closureType = entity.ClosureTypeClosure
+			}
+			closure := newSchoolClosure(schoolUUID, username, name, closureType, event.StartDate, event.EndDate, sourceUID)
+			if err := s.calendarRepository.SaveSchoolClosure(tx, closure); err != nil {
+				return dto.CalendarImportResponseDTO{}, err
+			}
+			response.Closures++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.CalendarImportResponseDTO{}, err
+	}
+	return response, nil
+}
+
+// An iCalendar file with one event per departure of the school's routes, or of a single
+// route, on every operating day from today on
+func (s *CalendarService) ExportRouteSchedules(schoolUUID, routenameUUID string, days int) (string, error) {
+	if days <= 0 {
+		days = defaultScheduleExportDays
+	}
+	if days > maxScheduleExportDays {
+		return "", errors.New(fmt.Sprintf("at most %d days can be exported", maxScheduleExportDays), 400)
+	}
+
+	routes, err := s.calendarRepository.FetchRouteSchedules(schoolUUID, routenameUUID)
+	if err != nil {
+		return "", err
+	}
+	if routenameUUID != "" && len(routes) == 0 {
+		return "", errors.New("route not found", 404)
+	}
+
+	calendar, err := s.loadCalendar(schoolUUID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	today := civilDate(now)
+	stamp := now.UTC().Format("20060102T150405Z")
+
+	var builder strings.Builder
+	writeICalLine(&builder, "BEGIN:VCALENDAR")
+	writeICalLine(&builder, "VERSION:2.0")
+	writeICalLine(&builder, "PRODID:-//Shuttle//Route Schedules//EN")
+	writeICalLine(&builder, "CALSCALE:GREGORIAN")
+
+	for offset := 0; offset < days; offset++ {
+		day := today.AddDate(0, 0, offset)
+		if calendar.closedReason(day) != "" {
+			continue
+		}
+
+		for _, route := range routes {
+			legs := []struct {
+				direction string
+				departure sql.NullString
+				summary   string
+			}{
+				{entity.RouteDirectionMorning, route.MorningDepartureTime, route.RouteName + " - morning pickup"},
+				{entity.RouteDirectionAfternoon, route.AfternoonDepartureTime, route.RouteName + " - afternoon drop-off"},
+			}
+
+			for _, leg := range legs {
+				minute, ok := parseDepartureMinute(leg.departure.String)
+				if !ok {
+					continue
+				}
+				departure := day.Add(time.Duration(minute) * time.Minute)
+
+				writeICalLine(&builder, "BEGIN:VEVENT")
+				writeICalLine(&builder, fmt.Sprintf("UID:%s-%s-%s@shuttle", route.RouteNameUUID, leg.direction, day.Format("20060102")))
+				writeICalLine(&builder, "DTSTAMP:"+stamp)
+				writeICalLine(&builder, "DTSTART:"+departure.Format("20060102T150405"))
+				writeICalLine(&builder, "SUMMARY:"+escapeICalText(leg.summary))
+				writeICalLine(&builder, "CATEGORIES:"+strings.ToUpper(leg.direction))
+				writeICalLine(&builder, "END:VEVENT")
+			}
+		}
+	}
+
+	writeICalLine(&builder, "END:VCALENDAR")
+	return builder.String(), nil
+}
+
+// The next departure of each child's route on an operating day of its school. Children without
+// a route, or whose route has no operating day ahead, get an entry without date.
+func (s *CalendarService) GetNextPickupsByParent(parentUUID string) ([]dto.NextPickupResponseDTO, error) {
+	children, err := s.calendarRepository.FetchChildRouteSchedules(parentUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	calendars := make(map[uuid.UUID]operatingCalendar)
+	pickups := make([]dto.NextPickupResponseDTO, 0, len(children))
+
+	for _, child := range children {
+		pickup := dto.NextPickupResponseDTO{
+			StudentUUID:      child.StudentUUID.String(),
+			StudentFirstName: child.StudentFirstName,
+			StudentLastName:  child.StudentLastName,
+		}
+
+		if child.RouteNameUUID.Valid {
+			routenameUUID := child.RouteNameUUID.UUID.String()
+			pickup.RouteNameUUID = &routenameUUID
+			pickup.RouteName = &child.RouteName.String
+
+			calendar, loaded := calendars[child.SchoolUUID]
+			if !loaded {
+				calendar, err = s.loadCalendar(child.SchoolUUID.String())
+				if err != nil {
+					return nil, err
+				}
+				calendars[child.SchoolUUID] = calendar
+			}
+
+			if direction, date, departure, found := nextDeparture(calendar, child.MorningDepartureTime, child.AfternoonDepartureTime, now); found {
+				formatted := date.Format("2006-01-02")
+				pickup.Direction = &direction
+				pickup.Date = &formatted
+				pickup.DepartureTime = departure
+			}
+		}
+
+		pickups = append(pickups, pickup)
+	}
+
+	return pickups, nil
+}
+
+// The first departure still ahead on an operating day. A route without departure times only
+// tells the day.
+func nextDeparture(calendar operatingCalendar, morning, afternoon sql.NullString, now time.Time) (string, time.Time, *string, bool) {
+	today := civilDate(now)
+	currentMinute := now.Hour()*60 + now.Minute()
+
+	for offset := 0; offset <= nextPickupSearchDays; offset++ {
+		day := today.AddDate(0, 0, offset)
+		if calendar.closedReason(day) != "" {
+			continue
+		}
+
+		if !morning.Valid && !afternoon.Valid {
+			return entity.RouteDirectionMorning, day, nil, true
+		}
+
+		for _, leg := range []struct {
+			direction string
+			departure sql.NullString
+		}{
+			{entity.RouteDirectionMorning, morning},
+			{entity.RouteDirectionAfternoon, afternoon},
+		} {
+			minute, ok := parseDepartureMinute(leg.departure.String)
+			if !ok || (offset == 0 && minute <= currentMinute) {
+				continue
+			}
+			departure := leg.departure.String
+			return leg.direction, day, &departure, true
+		}
+	}
+
+	return "", time.Time{}, nil, false
+}
+
+func (s *CalendarService) saveInTransaction(save func(tx *sqlx.Tx) error) error {
+	tx, err := s.calendarRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := save(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func newSchoolTerm(schoolUUID, username, name string, startDate, endDate time.Time, sourceUID sql.NullString) entity.SchoolTerm {
+	return entity.SchoolTerm{
+		TermID:     time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		TermUUID:   uuid.New(),
+		SchoolUUID: uuid.MustParse(schoolUUID),
+		Name:       name,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		SourceUID:  sourceUID,
+		CreatedAt:  sql.NullTime{Time: time.Now(), Valid: true},
+		CreatedBy:  sql.NullString{String: username, Valid: true},
+	}
+}
+
+func newSchoolClosure(schoolUUID, username, name, closureType string, startDate, endDate time.Time, sourceUID sql.NullString) entity.SchoolClosure {
+	return entity.SchoolClosure{
+		ClosureID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		ClosureUUID: uuid.New(),
+		SchoolUUID:  uuid.MustParse(schoolUUID),
+		Name:        name,
+		Type:        closureType,
+		StartDate:   startDate,
+		EndDate:     endDate,
+		SourceUID:   sourceUID,
+		CreatedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+		CreatedBy:   sql.NullString{String: username, Valid: true},
+	}
+}
+
+func parseDateRange(start, end string) (time.Time, time.Time, error) {
+	startDate, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid start date", 400)
+	}
+	endDate, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid end date", 400)
+	}
+	if endDate.Before(startDate) {
+		return time.Time{}, time.Time{}, errors.New("end date can't be before the start date", 400)
+	}
+	return startDate, endDate, nil
+}
+
+// The calendar day of a time, as midnight UTC so days of any source compare equal
+func civilDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// ISO weekdays start at monday as 1 and end at sunday as 7
+func isoWeekday(weekday int) time.Weekday {
+	return time.Weekday(weekday % 7)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The parts of an iCalendar (RFC 5545) VEVENT the school calendar is built from
+type icalEvent struct {
+	UID        string
+	Summary    string
+	Categories []string
+	Status     string
+	StartDate  time.Time // civil date
+	EndDate    time.Time // civil date, inclusive
+}
+
+// Reads the events of an iCalendar file. Times are reduced to the day they fall on, ignoring
+// time zones, and the exclusive end of an all-day event becomes the last day it covers.
+// Events without a usable start date are returned as skipped.
+func parseICalEvents(reader io.Reader) (events []icalEvent, skipped int, err error) {
+	lines, err := unfoldICalLines(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var current *icalEvent
+	var startValue, endValue string
+	calendarFound := false
+
+	for _, line := range lines {
+		name, value, ok := splitICalLine(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			calendarFound = true
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			current = &icalEvent{}
+			startValue, endValue = "", ""
+		case name == "END" && strings.EqualFold(value, "VEVENT") && current != nil:
+			if event, ok := finishICalEvent(*current, startValue, endValue); ok {
+				events = append(events, event)
+			} else {
+				skipped++
+			}
+			current = nil
+		case current == nil:
+			continue
+		case name == "UID":
+			current.UID = value
+		case name == "SUMMARY":
+			current.Summary = unescapeICalText(value)
+		case name == "STATUS":
+			current.Status = strings.ToUpper(value)
+		case name == "CATEGORIES":
+			for _, category := range strings.Split(value, ",") {
+				current.Categories = append(current.Categories, strings.ToUpper(strings.TrimSpace(unescapeICalText(category))))
+			}
+		case name == "DTSTART":
+			startValue = value
+		case name == "DTEND":
+			endValue = value
+		}
+	}
+
+	if !calendarFound {
+		return nil, 0, fmt.Errorf("not an iCalendar file")
+	}
+	return events, skipped, nil
+}
+
+func finishICalEvent(event icalEvent, startValue, endValue string) (icalEvent, bool) {
+	start, _, ok := parseICalDate(startValue)
+	if !ok {
+		return icalEvent{}, false
+	}
+	event.StartDate = start
+	event.EndDate = start
+
+	if end, endIsDate, ok := parseICalDate(endValue); ok {
+		// DTEND is exclusive, an event ending at midnight doesn't cover that day
+		if endIsDate || strings.HasSuffix(strings.TrimSuffix(endValue, "Z"), "T000000") {
+			end = end.AddDate(0, 0, -1)
+		}
+		if !end.Before(start) {
+			event.EndDate = end
+		}
+	}
+
+	if event.UID == "" {
+		event.UID = fmt.Sprintf("%s-%s-%s", event.StartDate.Format("20060102"), event.EndDate.Format("20060102"), event.Summary)
+	}
+	return event, true
+}
+
+// Accepts DATE (20250102) and DATE-TIME (20250102T080000 with an optional Z) values
+func parseICalDate(value string) (time.Time, bool, bool) {
+	if len(value) < 8 {
+		return time.Time{}, false, false
+	}
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	return date, len(value) == 8, true
+}
+
+// Joins folded lines, a line starting with a space or tab continues the previous one
+func unfoldICalLines(reader io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// Splits "NAME;PARAM=x:value" into the name and the value, parameters are dropped. Colons
+// inside quoted parameter values don't end the name.
+func splitICalLine(line string) (name string, value string, ok bool) {
+	inQuotes := false
+	for i, char := range line {
+		switch char {
+		case '"':
+			inQuotes = !inQuotes
+		case ':':
+			if inQuotes {
+				continue
+			}
+			name, _, _ = strings.Cut(line[:i], ";")
+			return strings.ToUpper(name), line[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+func unescapeICalText(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+func escapeICalText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
+
+// Writes one content line, folded at 75 octets without splitting a UTF-8 character.
+// Continuation lines lose one octet to the leading space.
+func writeICalLine(builder *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		builder.WriteString(line[:cut])
+		builder.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	builder.WriteString(line)
+	builder.WriteString("\r\n")
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/dto"
+)
+
+const (
+	loginFailureWindow     = time.Hour // failures older than this are forgotten
+	loginEmailFreeFailures = 3
+	loginIPFreeFailures    = 10
+	loginMaxBackoff        = 15 * time.Minute
+	accountLockThreshold   = 10
+	accountLockDuration    = 30 * time.Minute
+)
+
+// Returned by Login while an account is locked or an email/IP has to wait before trying again
+type LoginBlockedError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account is temporarily locked after too many failed logins, try again in %d minutes", int(math.Ceil(e.RetryAfter.Minutes())))
+	}
+	return fmt.Sprintf("too many failed logins, try again in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+func loginEmailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// No delay for the first free failures, then 1s, 2s, 4s, ... up to loginMaxBackoff
+func loginBackoff(failures, freeFailures int) time.Duration {
+	if failures <= freeFailures {
+		return 0
+	}
+
+	exponent := failures - freeFailures - 1
+	if exponent >= 20 {
+		return loginMaxBackoff
+	}
+
+	backoff := time.Second << exponent
+	if backoff > loginMaxBackoff {
+		return loginMaxBackoff
+	}
+	return backoff
+}
+
+func (service *AuthService) checkLoginAllowed(email, ip string) error {
+	attempts, err := service.authRepository.FetchLoginAttempts([]string{loginEmailKey(email), loginIPKey(ip)})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var blocked *LoginBlockedError
+	for _, attempt := range attempts {
+		if attempt.LockedUntil.Valid && attempt.LockedUntil.Time.After(now) {
+			return &LoginBlockedError{RetryAfter: attempt.LockedUntil.Time.Sub(now), Locked: true}
+		}
+		if attempt.BlockedUntil.Valid && attempt.BlockedUntil.Time.After(now) {
+			if retryAfter := attempt.BlockedUntil.Time.Sub(now); blocked == nil || retryAfter > blocked.RetryAfter {
+				blocked = &LoginBlockedError{RetryAfter: retryAfter}
+			}
+		}
+	}
+
+	if blocked != nil {
+		return blocked
+	}
+	return nil
+}
+
+func (service *AuthService) recordLoginFailure(email, ip string) {
+	now := time.Now()
+
+	emailKey := loginEmailKey(email)
+	failures, err := service.authRepository.RecordLoginFailure(emailKey, loginFailureWindow)
+	if err != nil {
+		logger.LogError(err, "Failed to record login failure", map[string]interface{}{"email": email})
+	} else {
+		var lockedUntil sql.NullTime
+		if failures >= accountLockThreshold {
+			lockedUntil = sql.NullTime{Time: now.Add(accountLockDuration), Valid: true}
+		}
+		blockedUntil := sql.NullTime{Time: now.Add(loginBackoff(failures, loginEmailFreeFailures)), Valid: true}
+
+		newlyLocked, err := service.authRepository.UpdateLoginBlock(emailKey, blockedUntil, lockedUntil)
+		if err != nil {
+			logger.LogError(err, "Failed to update login block", map[string]interface{}{"email": email})
+		}
+
+		// Failures keep counting after a lock runs out, so every lock started here is audited, not just the first
+		if newlyLocked {
+			logger.LogWarn("Account locked after too many failed logins", map[string]interface{}{"email": email, "ip": ip})
+			service.auditService.Record(dto.AuditEntry{
+				Action:     AuditActionAccountLocked,
+				TargetType: "email",
+				TargetID:   strings.ToLower(strings.TrimSpace(email)),
+				IPAddress:  ip,
+				Details: map[string]interface{}{
+					"failures":     failures,
+					"locked_until": lockedUntil.Time.Format(time.RFC3339),
+				},
+			})
+		}
+	}
+
+	ipKey := loginIPKey(ip)
+	failures, err = service.authRepository.RecordLoginFailure(ipKey, loginFailureWindow)
+	if err != nil {
+		logger.LogError(err, "Failed to record login failure", map[string]interface{}{"ip": ip})
+		return
+	}
+	blockedUntil := sql.NullTime{Time: now.Add(loginBackoff(failures, loginIPFreeFailures)), Valid: true}
+	if _, err := service.authRepository.UpdateLoginBlock(ipKey, blockedUntil, sql.NullTime{}); err != nil {
+		logger.LogError(err, "Failed to update login block", map[string]interface{}{"ip": ip})
+	}
+}
+
+func (service *AuthService) clearLoginFailures(email string) {
+	if err := service.authRepository.DeleteLoginAttempt(loginEmailKey(email)); err != nil {
+		logger.LogError(err, "Failed to clear login failures", map[string]interface{}{"email": email})
+	}
+}
+
+// Lift a lockout before it runs out, done by a super admin
+func (service *AuthService) UnlockAccount(userUUID, actorUUID, actorName, ip string) error {
+	user, err := service.userRepository.FetchSpecificUser(userUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user not found", 404)
+		}
+		return err
+	}
+
+	if err := service.authRepository.DeleteLoginAttempt(loginEmailKey(user.Email)); err != nil {
+		return err
+	}
+
+	service.auditService.Record(dto.AuditEntry{
+		ActorUUID:  actorUUID,
+		ActorName:  actorName,
+		Action:     AuditActionAccountUnlocked,
+		TargetType: "user",
+		TargetID:   userUUID,
+		IPAddress:  ip,
+	})
+
+	return nil
+}
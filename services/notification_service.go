@@ -0,0 +1,78 @@
+package services
+
+import (
+	"database/sql"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+)
+
+type NotificationServiceInterface interface {
+	RegisterDevice(userUUID string, req dto.DeviceRequest) error
+	UnregisterDevice(userUUID, deviceToken string) error
+	UnregisterAllDevices(userUUID string) error
+}
+
+// Sends a push notification to every registered device of a user, without blocking the caller
+type UserNotifier interface {
+	NotifyUser(userUUID uuid.UUID, title, body string, data map[string]string)
+}
+
+type NotificationService struct {
+	notificationRepository repositories.NotificationRepositoryInterface
+}
+
+func NewNotificationService(notificationRepository repositories.NotificationRepositoryInterface) NotificationServiceInterface {
+	return &NotificationService{
+		notificationRepository: notificationRepository,
+	}
+}
+
+func (s *NotificationService) RegisterDevice(userUUID string, req dto.DeviceRequest) error {
+	userUUIDParsed, err := uuid.Parse(userUUID)
+	if err != nil {
+		return errors.New("invalid user UUID format", 400)
+	}
+
+	device := entity.UserDevice{
+		DeviceID:       time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		UserUUID:       userUUIDParsed,
+		DeviceToken:    req.DeviceToken,
+		DevicePlatform: req.Platform,
+		AppVersion:     sql.NullString{String: req.AppVersion, Valid: req.AppVersion != ""},
+		LastSeenAt:     time.Now(),
+		CreatedAt:      sql.NullTime{Time: time.Now(), Valid: true},
+	}
+
+	return s.notificationRepository.SaveUserDevice(device)
+}
+
+func (s *NotificationService) UnregisterDevice(userUUID, deviceToken string) error {
+	userUUIDParsed, err := uuid.Parse(userUUID)
+	if err != nil {
+		return errors.New("invalid user UUID format", 400)
+	}
+
+	if err := s.notificationRepository.DeleteUserDevice(userUUIDParsed, deviceToken); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("device not found", 404)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *NotificationService) UnregisterAllDevices(userUUID string) error {
+	userUUIDParsed, err := uuid.Parse(userUUID)
+	if err != nil {
+		return errors.New("invalid user UUID format", 400)
+	}
+
+	return s.notificationRepository.DeleteUserDevices(userUUIDParsed)
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+
+	"github.com/google/uuid"
+)
+
+const (
+	otpCodeTTL         = 5 * time.Minute
+	otpMaxAttempts     = 5 // wrong guesses before a code stops working
+	otpResendInterval  = time.Minute
+	otpMaxCodesPerHour = 5
+)
+
+// Sends text messages, implemented by utils.TwilioSMSSender, utils.ConsoleSMSSender and utils.FakeSMSSender.
+// Without one OTP login is disabled.
+type SMSSender interface {
+	Send(phone, message string) error
+}
+
+var errOTPLoginDisabled = errors.New("login with a phone number is not available", 503)
+
+// Returned by RequestOTP when a phone asked for codes too often
+type OTPRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OTPRateLimitError) Error() string {
+	return fmt.Sprintf("too many codes requested, try again in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Phones are stored with or without separators, only digits and a leading + matter
+func normalizePhone(phone string) string {
+	var builder strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+// Text a login code to a parent or driver. Unknown numbers and numbers shared by
+// several accounts get no code but the same response, so the endpoint can't be
+// used to find out which numbers are registered.
+func (service *AuthService) RequestOTP(phone string) error {
+	if service.smsSender == nil {
+		return errOTPLoginDisabled
+	}
+	phone = normalizePhone(phone)
+
+	// Limited before the lookup, so unknown numbers run into the same limit as registered ones
+	if err := service.checkOTPRateLimit(phone); err != nil {
+		return err
+	}
+	if err := service.authRepository.SaveOTPRequest(entity.OTPRequest{
+		ID:          time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		Phone:       phone,
+		RequestedAt: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	users, err := service.authRepository.FetchLoginUsersByPhone(phone)
+	if err != nil {
+		return err
+	}
+	if len(users) != 1 {
+		if len(users) > 1 {
+			logger.LogWarn("Phone number belongs to several accounts, OTP login refused", map[string]interface{}{
+				"phone": phone,
+			})
+		}
+		return nil
+	}
+
+	userUUID, err := uuid.Parse(users[0].UUID)
+	if err != nil {
+		return err
+	}
+
+	code, err := generateOTPCode()
+	if err != nil {
+		return err
+	}
+
+	otp := entity.OTPCode{
+		ID:        time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		UserUUID:  userUUID,
+		Phone:     phone,
+		CodeHash:  hashOTPCode(phone, code),
+		ExpiredAt: time.Now().Add(otpCodeTTL),
+		CreatedAt: time.Now(),
+	}
+	if err := service.authRepository.SaveOTPCode(otp); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your Shuttle login code is %s. It expires in %d minutes, don't share it with anyone.", code, int(otpCodeTTL.Minutes()))
+
+	// Sent in the background so the response time doesn't tell whether the number is registered
+	go func() {
+		if err := service.smsSender.Send(phone, message); err != nil {
+			logger.LogError(err, "Failed to send OTP code", map[string]interface{}{
+				"user_uuid": users[0].UUID,
+			})
+		}
+	}()
+
+	return nil
+}
+
+func (service *AuthService) checkOTPRateLimit(phone string) error {
+	now := time.Now()
+
+	recent, err := service.authRepository.CountOTPRequestsSince(phone, now.Add(-otpResendInterval))
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return &OTPRateLimitError{RetryAfter: otpResendInterval}
+	}
+
+	hourly, err := service.authRepository.CountOTPRequestsSince(phone, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if hourly >= otpMaxCodesPerHour {
+		return &OTPRateLimitError{RetryAfter: time.Hour}
+	}
+
+	return nil
+}
+
+// Check the latest code sent to the phone and return the user to issue tokens for
+func (service *AuthService) VerifyOTP(phone, code string) (dto.UserDataOnLoginDTO, error) {
+	if service.smsSender == nil {
+		return dto.UserDataOnLoginDTO{}, errOTPLoginDisabled
+	}
+	invalidCode := errors.New("invalid or expired code", 401)
+	phone = normalizePhone(phone)
+
+	tx, err := service.authRepository.BeginTransaction()
+	if err != nil {
+		return dto.UserDataOnLoginDTO{}, err
+	}
+	defer tx.Rollback()
+
+	otp, err := service.authRepository.FetchLatestOTPCodeForUpdate(tx, phone)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return dto.UserDataOnLoginDTO{}, invalidCode
+		}
+		return dto.UserDataOnLoginDTO{}, err
+	}
+
+	if otp.ConsumedAt.Valid || otp.ExpiredAt.Before(time.Now()) || otp.Attempts >= otpMaxAttempts {
+		return dto.UserDataOnLoginDTO{}, invalidCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(otp.CodeHash), []byte(hashOTPCode(phone, code))) != 1 {
+		if err := service.authRepository.IncrementOTPAttempts(tx, otp.ID); err != nil {
+			return dto.UserDataOnLoginDTO{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return dto.UserDataOnLoginDTO{}, err
+		}
+		return dto.UserDataOnLoginDTO{}, invalidCode
+	}
+
+	if err := service.authRepository.ConsumeOTPCode(tx, otp.ID); err != nil {
+		return dto.UserDataOnLoginDTO{}, err
+	}
+
+	user, err := service.authRepository.FetchLoginUser(otp.UserUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return dto.UserDataOnLoginDTO{}, invalidCode
+		}
+		return dto.UserDataOnLoginDTO{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.UserDataOnLoginDTO{}, err
+	}
+
+	return dto.UserDataOnLoginDTO{
+		UserID:   user.ID,
+		UserUUID: user.UUID,
+		Username: user.Username,
+		RoleCode: user.RoleCode,
+	}, nil
+}
+
+func generateOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Salted with the phone so equal codes of different numbers don't share a hash
+func hashOTPCode(phone, code string) string {
+	sum := sha256.Sum256([]byte(phone + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
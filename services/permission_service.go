@@ -0,0 +1,290 @@
+package services
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+)
+
+const (
+	PermissionUserRead         = "user:read"
+	PermissionUserWrite        = "user:write"
+	PermissionUserDelete       = "user:delete"
+	PermissionUserUnlock       = "user:unlock"
+	PermissionUserImpersonate  = "user:impersonate"
+	PermissionSchoolRead       = "school:read"
+	PermissionSchoolWrite      = "school:write"
+	PermissionSchoolDelete     = "school:delete"
+	PermissionVehicleRead      = "vehicle:read"
+	PermissionVehicleWrite     = "vehicle:write"
+	PermissionVehicleDelete    = "vehicle:delete"
+	PermissionDriverRead       = "driver:read"
+	PermissionDriverWrite      = "driver:write"
+	PermissionDriverDelete     = "driver:delete"
+	PermissionStudentRead      = "student:read"
+	PermissionStudentWrite     = "student:write"
+	PermissionStudentDelete    = "student:delete"
+	PermissionRouteRead        = "route:read"
+	PermissionRouteWrite       = "route:write"
+	PermissionRouteDelete      = "route:delete"
+	PermissionCalendarRead     = "calendar:read"
+	PermissionCalendarWrite    = "calendar:write"
+	PermissionShuttleRead      = "shuttle:read"
+	PermissionShuttleWrite     = "shuttle:write"
+	PermissionChildrenRead     = "children:read"
+	PermissionChildrenWrite    = "children:write"
+	PermissionDeviceWrite      = "device:write"
+	PermissionPermissionManage = "permission:manage"
+
+	AuditActionRolePermissionsUpdated = "role_permissions_updated"
+
+	// Other instances pick up changed mappings within this time
+	permissionCacheTTL = 30 * time.Second
+)
+
+// Every permission a route can require. New entries are added to the permissions table on startup,
+// which roles get them is decided in the database.
+var permissionRegistry = []dto.PermissionResponse{
+	{Code: PermissionUserRead, Description: "View super admins, school admins and drivers of every school"},
+	{Code: PermissionUserWrite, Description: "Add and update users of every school"},
+	{Code: PermissionUserDelete, Description: "Delete users of every school"},
+	{Code: PermissionUserUnlock, Description: "Unlock accounts locked after failed logins"},
+	{Code: PermissionUserImpersonate, Description: "Act as a school admin, driver or parent"},
+	{Code: PermissionSchoolRead, Description: "View schools"},
+	{Code: PermissionSchoolWrite, Description: "Add and update schools"},
+	{Code: PermissionSchoolDelete, Description: "Delete schools"},
+	{Code: PermissionVehicleRead, Description: "View vehicles"},
+	{Code: PermissionVehicleWrite, Description: "Add and update vehicles"},
+	{Code: PermissionVehicleDelete, Description: "Delete vehicles"},
+	{Code: PermissionDriverRead, Description: "View drivers of the own school"},
+	{Code: PermissionDriverWrite, Description: "Add and update drivers of the own school"},
+	{Code: PermissionDriverDelete, Description: "Delete drivers of the own school"},
+	{Code: PermissionStudentRead, Description: "View students and their parents"},
+	{Code: PermissionStudentWrite, Description: "Add and update students and their parents"},
+	{Code: PermissionStudentDelete, Description: "Delete students"},
+	{Code: PermissionRouteRead, Description: "View routes"},
+	{Code: PermissionRouteWrite, Description: "Add and update routes"},
+	{Code: PermissionRouteDelete, Description: "Delete routes"},
+	{Code: PermissionCalendarRead, Description: "View the operating calendar and route schedules of the own school"},
+	{Code: PermissionCalendarWrite, Description: "Change and import the operating calendar of the own school"},
+	{Code: PermissionShuttleRead, Description: "Track shuttles and view their history"},
+	{Code: PermissionShuttleWrite, Description: "Start shuttles and update their status"},
+	{Code: PermissionChildrenRead, Description: "View own children"},
+	{Code: PermissionChildrenWrite, Description: "Update own children"},
+	{Code: PermissionDeviceWrite, Description: "Register devices for push notifications"},
+	{Code: PermissionPermissionManage, Description: "View and change role permissions"},
+}
+
+var permissionRoles = []string{"SA", "AS", "D", "P"}
+
+type PermissionServiceInterface interface {
+	SyncRegistry() error
+	HasPermission(roleCode, permission string) bool
+	GetPermissions() ([]dto.PermissionResponse, error)
+	GetRolePermissions() ([]dto.RolePermissionsResponse, error)
+	UpdateRolePermissions(roleCode string, permissions []string, actorUUID, actorName, ip string) error
+}
+
+type PermissionService struct {
+	permissionRepository repositories.PermissionRepositoryInterface
+	auditService         AuditServiceInterface
+
+	mutex       sync.RWMutex
+	reloadMutex sync.Mutex
+	roles       map[string]map[string]bool
+	loadedAt    time.Time
+}
+
+func NewPermissionService(permissionRepository repositories.PermissionRepositoryInterface, auditService AuditServiceInterface) *PermissionService {
+	return &PermissionService{
+		permissionRepository: permissionRepository,
+		auditService:         auditService,
+	}
+}
+
+func (s *PermissionService) SyncRegistry() error {
+	for _, permission := range permissionRegistry {
+		err := s.permissionRepository.SavePermission(entity.Permission{
+			Code:        permission.Code,
+			Description: permission.Description,
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.reload()
+}
+
+func (s *PermissionService) reload() error {
+	rolePermissions, err := s.permissionRepository.FetchAllRolePermissions()
+	if err != nil {
+		return err
+	}
+
+	roles := make(map[string]map[string]bool)
+	for _, rolePermission := range rolePermissions {
+		if roles[rolePermission.RoleCode] == nil {
+			roles[rolePermission.RoleCode] = make(map[string]bool)
+		}
+		roles[rolePermission.RoleCode][rolePermission.PermissionCode] = true
+	}
+
+	s.mutex.Lock()
+	s.roles = roles
+	s.loadedAt = time.Now()
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Answers from the cache and reloads it once it is older than permissionCacheTTL. If the
+// database can't be reached the last known mappings are kept, before the first load nothing is allowed.
+func (s *PermissionService) HasPermission(roleCode, permission string) bool {
+	s.mutex.RLock()
+	stale := time.Since(s.loadedAt) > permissionCacheTTL
+	s.mutex.RUnlock()
+
+	// Only one request reloads, the others answer from the cache meanwhile
+	if stale && s.reloadMutex.TryLock() {
+		if err := s.reload(); err != nil {
+			logger.LogError(err, "Failed to reload role permissions", nil)
+		}
+		s.reloadMutex.Unlock()
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.roles[roleCode][permission]
+}
+
+func (s *PermissionService) GetPermissions() ([]dto.PermissionResponse, error) {
+	permissions, err := s.permissionRepository.FetchAllPermissions()
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.PermissionResponse, 0, len(permissions))
+	for _, permission := range permissions {
+		response = append(response, dto.PermissionResponse{
+			Code:        permission.Code,
+			Description: permission.Description,
+		})
+	}
+
+	return response, nil
+}
+
+func (s *PermissionService) GetRolePermissions() ([]dto.RolePermissionsResponse, error) {
+	rolePermissions, err := s.permissionRepository.FetchAllRolePermissions()
+	if err != nil {
+		return nil, err
+	}
+
+	byRole := make(map[string][]string)
+	for _, rolePermission := range rolePermissions {
+		byRole[rolePermission.RoleCode] = append(byRole[rolePermission.RoleCode], rolePermission.PermissionCode)
+	}
+
+	response := make([]dto.RolePermissionsResponse, 0, len(permissionRoles))
+	for _, roleCode := range permissionRoles {
+		permissions := byRole[roleCode]
+		if permissions == nil {
+			permissions = []string{}
+		}
+		response = append(response, dto.RolePermissionsResponse{
+			RoleCode:    roleCode,
+			Permissions: permissions,
+		})
+	}
+
+	return response, nil
+}
+
+// Replaces every permission of a role
+func (s *PermissionService) UpdateRolePermissions(roleCode string, permissions []string, actorUUID, actorName, ip string) error {
+	if !contains(permissionRoles, roleCode) {
+		return errors.New("role not found", 404)
+	}
+
+	granted := make(map[string]bool)
+	for _, permission := range permissions {
+		if !isRegisteredPermission(permission) {
+			return errors.New("unknown permission "+permission, 400)
+		}
+		granted[permission] = true
+	}
+
+	// Otherwise nobody could ever change the mappings again without touching the database
+	if roleCode == "SA" && !granted[PermissionPermissionManage] {
+		return errors.New("super admins can't lose the "+PermissionPermissionManage+" permission", 409)
+	}
+
+	codes := make([]string, 0, len(granted))
+	for permission := range granted {
+		codes = append(codes, permission)
+	}
+	sort.Strings(codes)
+
+	tx, err := s.permissionRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.permissionRepository.DeleteRolePermissions(tx, roleCode); err != nil {
+		return err
+	}
+
+	for _, permission := range codes {
+		err := s.permissionRepository.SaveRolePermission(tx, entity.RolePermission{
+			RoleCode:       roleCode,
+			PermissionCode: permission,
+			CreatedAt:      time.Now(),
+			CreatedBy:      sql.NullString{String: actorName, Valid: actorName != ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := s.reload(); err != nil {
+		logger.LogError(err, "Failed to reload role permissions", nil)
+	}
+
+	s.auditService.Record(dto.AuditEntry{
+		ActorUUID:  actorUUID,
+		ActorName:  actorName,
+		Action:     AuditActionRolePermissionsUpdated,
+		TargetType: "role",
+		TargetID:   roleCode,
+		IPAddress:  ip,
+		Details: map[string]interface{}{
+			"permissions": codes,
+		},
+	})
+
+	return nil
+}
+
+func isRegisteredPermission(code string) bool {
+	for _, permission := range permissionRegistry {
+		if permission.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"time"
+
+	"shuttle/models/entity"
+)
+
+// Today's shuttle statuses that tell which leg a driver is on, from the most to the least
+// telling, so one student still on the road decides the leg even if the others are home
+var routeDirectionShuttleStatuses = []struct {
+	status    string
+	direction string
+}{
+	{entity.ShuttleStatusGoingToHome, entity.RouteDirectionAfternoon},
+	{entity.ShuttleStatusGoingToSchool, entity.RouteDirectionMorning},
+	{entity.ShuttleStatusWaitingToBeTakenToHome, entity.RouteDirectionAfternoon},
+	{entity.ShuttleStatusWaitingToBeTakenToSchool, entity.RouteDirectionMorning},
+	{entity.ShuttleStatusAtSchool, entity.RouteDirectionAfternoon},
+}
+
+func isValidRouteDirection(direction string) bool {
+	return contains(entity.RouteDirections, direction)
+}
+
+// The leg a driver is on. A leg in progress wins, a finished morning leg means the afternoon
+// one is next. Without shuttles to go by, the day is split halfway between both departure
+// times, or at the afternoon departure or noon when they aren't both set.
+func currentRouteDirection(schedule entity.DriverRouteSchedule, now time.Time) string {
+	for _, candidate := range routeDirectionShuttleStatuses {
+		if contains(schedule.ShuttleStatuses, candidate.status) {
+			return candidate.direction
+		}
+	}
+
+	switchMinute := 12 * 60
+	afternoon, afternoonSet := parseDepartureMinute(schedule.AfternoonDepartureTime.String)
+	if afternoonSet {
+		switchMinute = afternoon
+		if morning, morningSet := parseDepartureMinute(schedule.MorningDepartureTime.String); morningSet && morning < afternoon {
+			switchMinute = (morning + afternoon) / 2
+		}
+	}
+
+	if now.Hour()*60+now.Minute() >= switchMinute {
+		return entity.RouteDirectionAfternoon
+	}
+	return entity.RouteDirectionMorning
+}
+
+// Minutes since midnight of an HH:MM departure time
+func parseDepartureMinute(departure string) (int, bool) {
+	parsed, err := time.Parse("15:04", departure)
+	if err != nil {
+		return 0, false
+	}
+	return parsed.Hour()*60 + parsed.Minute(), true
+}
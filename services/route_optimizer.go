@@ -0,0 +1,221 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/utils"
+
+	"github.com/google/uuid"
+)
+
+// Length of a pickup run: from the first pickup through every other one and on to the school.
+// Where the driver starts from isn't known, so the way to the first pickup isn't counted.
+func pickupPathDistance(points []utils.GeoPoint, order []int, school utils.GeoPoint) float64 {
+	if len(order) == 0 {
+		return 0
+	}
+
+	distance := 0.0
+	for i := 0; i+1 < len(order); i++ {
+		distance += points[order[i]].DistanceTo(points[order[i+1]])
+	}
+	return distance + points[order[len(order)-1]].DistanceTo(school)
+}
+
+// Near-optimal pickup order ending at the school. Nearest neighbour is grown backwards from
+// the school, so the last pickup is the one closest to it, then 2-opt removes crossings.
+func optimizePickupOrder(points []utils.GeoPoint, school utils.GeoPoint) []int {
+	n := len(points)
+	if n < 2 {
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		return order
+	}
+
+	visited := make([]bool, n)
+	reversed := make([]int, 0, n)
+	current := school
+	for len(reversed) < n {
+		nearest, nearestDistance := -1, math.Inf(1)
+		for i, point := range points {
+			if visited[i] {
+				continue
+			}
+			if distance := current.DistanceTo(point); distance < nearestDistance {
+				nearest, nearestDistance = i, distance
+			}
+		}
+		visited[nearest] = true
+		reversed = append(reversed, nearest)
+		current = points[nearest]
+	}
+
+	order := make([]int, n)
+	for i, index := range reversed {
+		order[n-1-i] = index
+	}
+
+	// The point after the last pickup is always the school
+	pointAt := func(position int) utils.GeoPoint {
+		if position == n {
+			return school
+		}
+		return points[order[position]]
+	}
+
+	const epsilon = 1e-9
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < n-1; i++ {
+			for j := i + 1; j < n; j++ {
+				// Reversing order[i..j] swaps edges (i-1, i) and (j, j+1) for (i-1, j) and (i, j+1).
+				// The run has no edge before its first pickup.
+				before := pointAt(j).DistanceTo(pointAt(j + 1))
+				after := pointAt(i).DistanceTo(pointAt(j + 1))
+				if i > 0 {
+					before += pointAt(i - 1).DistanceTo(pointAt(i))
+					after += pointAt(i - 1).DistanceTo(pointAt(j))
+				}
+
+				if after < before-epsilon {
+					for left, right := i, j; left < right; left, right = left+1, right-1 {
+						order[left], order[right] = order[right], order[left]
+					}
+					improved = true
+				}
+			}
+		}
+	}
+
+	return order
+}
+
+// Order every driver's students of a route by pickup distance and, unless it's a dry run,
+// store the new order of the leg. Students without a usable pickup point keep their
+// relative order after the optimized ones. The afternoon leg runs the same path the other
+// way round, from school back to the students' homes.
+func (service *routeService) OptimizeRoute(routenameUUID, schoolUUID, username, direction string, dryRun bool) (dto.RouteOptimizationResponseDTO, error) {
+	stops, err := service.routeRepository.FetchRouteStops(routenameUUID, schoolUUID, direction)
+	if err != nil {
+		return dto.RouteOptimizationResponseDTO{}, err
+	}
+	if len(stops) == 0 {
+		return dto.RouteOptimizationResponseDTO{}, fmt.Errorf("route not found")
+	}
+
+	school, ok := utils.ParseGeoPoint(stops[0].SchoolPoint.String)
+	if !ok {
+		return dto.RouteOptimizationResponseDTO{}, fmt.Errorf("school point is not set")
+	}
+
+	var driverOrder []uuid.UUID
+	stopsByDriver := make(map[uuid.UUID][]entity.RouteStop)
+	for _, stop := range stops {
+		if _, exists := stopsByDriver[stop.DriverUUID]; !exists {
+			driverOrder = append(driverOrder, stop.DriverUUID)
+		}
+		stopsByDriver[stop.DriverUUID] = append(stopsByDriver[stop.DriverUUID], stop)
+	}
+
+	response := dto.RouteOptimizationResponseDTO{
+		RouteNameUUID: routenameUUID,
+		Direction:     direction,
+		DryRun:        dryRun,
+	}
+
+	for _, driverUUID := range driverOrder {
+		driverStops := stopsByDriver[driverUUID]
+
+		var located []entity.RouteStop
+		var points []utils.GeoPoint
+		var unlocated []entity.RouteStop
+		for _, stop := range driverStops {
+			if point, ok := utils.ParseGeoPoint(stop.PickupPoint.String); ok {
+				located = append(located, stop)
+				points = append(points, point)
+			} else {
+				unlocated = append(unlocated, stop)
+			}
+		}
+
+		currentOrder := make([]int, len(points))
+		for i := range currentOrder {
+			currentOrder[i] = i
+		}
+		optimizedOrder := optimizePickupOrder(points, school)
+
+		// A drop-off order is as long as the pickup order it reverses
+		if direction == entity.RouteDirectionAfternoon {
+			reverseOrder(currentOrder)
+		}
+
+		assignment := dto.DriverRouteOptimizationDTO{
+			DriverUUID:        driverUUID.String(),
+			DistanceBeforeKm:  math.Round(pickupPathDistance(points, currentOrder, school)*1000) / 1000,
+			DistanceAfterKm:   math.Round(pickupPathDistance(points, optimizedOrder, school)*1000) / 1000,
+			UnlocatedStudents: []string{},
+		}
+
+		if direction == entity.RouteDirectionAfternoon {
+			reverseOrder(optimizedOrder)
+		}
+
+		ordered := make([]entity.RouteStop, 0, len(driverStops))
+		for _, index := range optimizedOrder {
+			ordered = append(ordered, located[index])
+		}
+		for _, stop := range unlocated {
+			ordered = append(ordered, stop)
+			assignment.UnlocatedStudents = append(assignment.UnlocatedStudents, stop.StudentUUID.String())
+		}
+
+		for position, stop := range ordered {
+			assignment.Students = append(assignment.Students, dto.OptimizedStudentDTO{
+				StudentUUID:      stop.StudentUUID.String(),
+				StudentFirstName: stop.StudentFirstName.String,
+				StudentLastName:  stop.StudentLastName.String,
+				PreviousOrder:    int(stop.StudentOrder.Int64),
+				StudentOrder:     position + 1,
+			})
+		}
+
+		response.Assignments = append(response.Assignments, assignment)
+	}
+
+	if dryRun {
+		return response, nil
+	}
+
+	tx, err := service.routeRepository.BeginTransaction()
+	if err != nil {
+		return dto.RouteOptimizationResponseDTO{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, assignment := range response.Assignments {
+		driverUUID := uuid.MustParse(assignment.DriverUUID)
+		for _, student := range assignment.Students {
+			err := service.routeRepository.UpdateStudentOrder(tx, routenameUUID, direction, driverUUID, uuid.MustParse(student.StudentUUID), student.StudentOrder, username)
+			if err != nil {
+				return dto.RouteOptimizationResponseDTO{}, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dto.RouteOptimizationResponseDTO{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return response, nil
+}
+
+func reverseOrder(order []int) {
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+}
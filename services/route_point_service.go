@@ -0,0 +1,330 @@
+package services
+
+import (
+	"database/sql"
+	"time"
+
+	"shuttle/errors"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type RoutePointServiceInterface interface {
+	GetRoutePoints(routenameUUID, schoolUUID, direction string) ([]dto.RoutePointResponseDTO, error)
+	AddRoutePoint(routenameUUID, schoolUUID, username string, req dto.RoutePointRequestDTO) (string, error)
+	UpdateRoutePoint(routenameUUID, pointUUID, schoolUUID, username string, req dto.RoutePointRequestDTO) error
+	DeleteRoutePoint(routenameUUID, pointUUID, schoolUUID, username string) error
+	ReorderRoutePoints(routenameUUID, schoolUUID, username, direction string, pointUUIDs []uuid.UUID) error
+	AssignRoutePointStudents(routenameUUID, pointUUID, schoolUUID string, studentUUIDs []uuid.UUID) error
+}
+
+type RoutePointService struct {
+	routePointRepository repositories.RoutePointRepositoryInterface
+}
+
+func NewRoutePointService(routePointRepository repositories.RoutePointRepositoryInterface) RoutePointServiceInterface {
+	return &RoutePointService{
+		routePointRepository: routePointRepository,
+	}
+}
+
+// Stops in visiting order, each with the students picked up or dropped off there. Without a
+// direction the morning stops come first, then the afternoon ones.
+func (s *RoutePointService) GetRoutePoints(routenameUUID, schoolUUID, direction string) ([]dto.RoutePointResponseDTO, error) {
+	if direction != "" && !isValidRouteDirection(direction) {
+		return nil, errors.New("direction must be morning or afternoon", 400)
+	}
+
+	exists, err := s.routePointRepository.RouteExists(routenameUUID, schoolUUID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("route not found", 404)
+	}
+
+	points, err := s.routePointRepository.FetchRoutePoints(routenameUUID, direction)
+	if err != nil {
+		return nil, err
+	}
+
+	students, err := s.routePointRepository.FetchRoutePointStudents(routenameUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	studentsByPoint := make(map[uuid.UUID][]dto.RoutePointStudentDTO)
+	for _, student := range students {
+		studentsByPoint[student.RoutePointUUID] = append(studentsByPoint[student.RoutePointUUID], dto.RoutePointStudentDTO{
+			StudentUUID:      student.StudentUUID.String(),
+			StudentFirstName: student.StudentFirstName.String,
+			StudentLastName:  student.StudentLastName.String,
+		})
+	}
+
+	pointsDTO := make([]dto.RoutePointResponseDTO, 0, len(points))
+	for _, point := range points {
+		pointStudents := studentsByPoint[point.RoutePointUUID]
+		if pointStudents == nil {
+			pointStudents = []dto.RoutePointStudentDTO{}
+		}
+		pointsDTO = append(pointsDTO, dto.RoutePointResponseDTO{
+			RoutePointUUID: point.RoutePointUUID.String(),
+			Direction:      point.Direction,
+			Name:           point.Name,
+			Order:          point.Order,
+			Latitude:       point.Latitude,
+			Longitude:      point.Longitude,
+			Students:       pointStudents,
+		})
+	}
+
+	return pointsDTO, nil
+}
+
+// Appends the stop to its leg, or inserts it at the requested position and moves the following
+// stops back
+func (s *RoutePointService) AddRoutePoint(routenameUUID, schoolUUID, username string, req dto.RoutePointRequestDTO) (string, error) {
+	direction := req.Direction
+	if direction == "" {
+		direction = entity.RouteDirectionMorning
+	}
+
+	tx, err := s.routePointRepository.BeginTransaction()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	allPoints, err := s.lockRoutePoints(tx, routenameUUID, schoolUUID)
+	if err != nil {
+		return "", err
+	}
+	points := routePointsOfDirection(allPoints, direction)
+
+	order := req.Order
+	if order == 0 || order > len(points) {
+		order = len(points) + 1
+	}
+
+	// Walked backwards so no two stops share an order at any point
+	for i := len(points) - 1; i >= 0; i-- {
+		if points[i].Order >= order {
+			if err := s.routePointRepository.UpdateRoutePointOrder(tx, points[i].RoutePointUUID, points[i].Order+1, username); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	point := entity.RoutePoint{
+		RoutePointID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		RoutePointUUID: uuid.New(),
+		RouteNameUUID:  uuid.MustParse(routenameUUID),
+		Direction:      direction,
+		Name:           req.Name,
+		Order:          order,
+		Latitude:       *req.Latitude,
+		Longitude:      *req.Longitude,
+		CreatedAt:      sql.NullTime{Time: time.Now(), Valid: true},
+		CreatedBy:      sql.NullString{String: username, Valid: true},
+	}
+	if err := s.routePointRepository.SaveRoutePoint(tx, point); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return point.RoutePointUUID.String(), nil
+}
+
+// Changes name and location, the position is changed with ReorderRoutePoints and the leg
+// can't be changed at all
+func (s *RoutePointService) UpdateRoutePoint(routenameUUID, pointUUID, schoolUUID, username string, req dto.RoutePointRequestDTO) error {
+	tx, err := s.routePointRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	points, err := s.lockRoutePoints(tx, routenameUUID, schoolUUID)
+	if err != nil {
+		return err
+	}
+
+	point, err := findRoutePoint(points, pointUUID)
+	if err != nil {
+		return err
+	}
+
+	point.Name = req.Name
+	point.Latitude = *req.Latitude
+	point.Longitude = *req.Longitude
+	point.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	point.UpdatedBy = sql.NullString{String: username, Valid: true}
+
+	if err := s.routePointRepository.UpdateRoutePoint(tx, point); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Students of a deleted stop are left without one on that leg, the stops after it move up
+func (s *RoutePointService) DeleteRoutePoint(routenameUUID, pointUUID, schoolUUID, username string) error {
+	tx, err := s.routePointRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	points, err := s.lockRoutePoints(tx, routenameUUID, schoolUUID)
+	if err != nil {
+		return err
+	}
+
+	point, err := findRoutePoint(points, pointUUID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.routePointRepository.UnassignRoutePointStudents(tx, point.Direction, point.RoutePointUUID); err != nil {
+		return err
+	}
+	if err := s.routePointRepository.DeleteRoutePoint(tx, point.RoutePointUUID, username); err != nil {
+		return err
+	}
+
+	for _, other := range routePointsOfDirection(points, point.Direction) {
+		if other.Order > point.Order {
+			if err := s.routePointRepository.UpdateRoutePointOrder(tx, other.RoutePointUUID, other.Order-1, username); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Sets the visiting order of every stop of a leg at once, the list has to contain each stop of
+// the leg exactly once
+func (s *RoutePointService) ReorderRoutePoints(routenameUUID, schoolUUID, username, direction string, pointUUIDs []uuid.UUID) error {
+	if direction == "" {
+		direction = entity.RouteDirectionMorning
+	}
+
+	tx, err := s.routePointRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	allPoints, err := s.lockRoutePoints(tx, routenameUUID, schoolUUID)
+	if err != nil {
+		return err
+	}
+	points := routePointsOfDirection(allPoints, direction)
+
+	known := make(map[uuid.UUID]bool, len(points))
+	for _, point := range points {
+		known[point.RoutePointUUID] = true
+	}
+
+	seen := make(map[uuid.UUID]bool, len(pointUUIDs))
+	for _, pointUUID := range pointUUIDs {
+		if !known[pointUUID] {
+			return errors.New("route point "+pointUUID.String()+" does not belong to the "+direction+" leg of this route", 400)
+		}
+		if seen[pointUUID] {
+			return errors.New("route point "+pointUUID.String()+" is listed more than once", 400)
+		}
+		seen[pointUUID] = true
+	}
+	if len(seen) != len(points) {
+		return errors.New("every route point of the "+direction+" leg has to be listed", 400)
+	}
+
+	for i, pointUUID := range pointUUIDs {
+		if err := s.routePointRepository.UpdateRoutePointOrder(tx, pointUUID, i+1, username); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Makes the stop the place exactly these students are picked up or dropped off at on its leg,
+// students no longer listed are left without a stop. Every student has to be assigned to the route.
+func (s *RoutePointService) AssignRoutePointStudents(routenameUUID, pointUUID, schoolUUID string, studentUUIDs []uuid.UUID) error {
+	tx, err := s.routePointRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	points, err := s.lockRoutePoints(tx, routenameUUID, schoolUUID)
+	if err != nil {
+		return err
+	}
+
+	point, err := findRoutePoint(points, pointUUID)
+	if err != nil {
+		return err
+	}
+
+	unique := make(map[uuid.UUID]bool, len(studentUUIDs))
+	for _, studentUUID := range studentUUIDs {
+		unique[studentUUID] = true
+	}
+
+	if err := s.routePointRepository.UnassignRoutePointStudents(tx, point.Direction, point.RoutePointUUID); err != nil {
+		return err
+	}
+
+	assigned, err := s.routePointRepository.AssignStudentsToRoutePoint(tx, routenameUUID, point.Direction, point.RoutePointUUID, studentUUIDs)
+	if err != nil {
+		return err
+	}
+	if assigned != int64(len(unique)) {
+		return errors.New("every student has to be assigned to this route", 400)
+	}
+
+	return tx.Commit()
+}
+
+// Locks the route of the school admin's school and returns the stops of both legs
+func (s *RoutePointService) lockRoutePoints(tx *sqlx.Tx, routenameUUID, schoolUUID string) ([]entity.RoutePoint, error) {
+	exists, err := s.routePointRepository.LockRoute(tx, routenameUUID, schoolUUID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("route not found", 404)
+	}
+
+	return s.routePointRepository.FetchRoutePointsForUpdate(tx, routenameUUID)
+}
+
+func routePointsOfDirection(points []entity.RoutePoint, direction string) []entity.RoutePoint {
+	var filtered []entity.RoutePoint
+	for _, point := range points {
+		if point.Direction == direction {
+			filtered = append(filtered, point)
+		}
+	}
+	return filtered
+}
+
+func findRoutePoint(points []entity.RoutePoint, pointUUID string) (entity.RoutePoint, error) {
+	for _, point := range points {
+		if point.RoutePointUUID.String() == pointUUID {
+			return point, nil
+		}
+	}
+	return entity.RoutePoint{}, errors.New("route point not found", 404)
+}
@@ -13,21 +13,24 @@ import (
 type RouteServiceInterface interface {
 	GetAllRoutesByAS(schoolUUID string) ([]dto.RoutesResponseDTO, error)
 	GetSpecRouteByAS(routeNameUUID, driverUUID string) (dto.RoutesResponseDTO, error)
-	GetAllRoutesByDriver(driverUUID string) ([]dto.RouteResponseByDriverDTO, error)
+	GetAllRoutesByDriver(driverUUID, direction string) (dto.DriverRouteResponseDTO, error)
 	AddRoute(route dto.RoutesRequestDTO, schoolUUID, username string) error
 	GetSchoolUUIDByUserUUID(userUUID string) (string, error)
 	GetDriverUUIDByRouteName(routeNameUUID string) (string, error)
 	UpdateRoute(route dto.RoutesRequestDTO, routenameUUID, schoolUUID, username string) error 
 	DeleteRoute(routenameUUID, schoolUUID, username string) error
+	OptimizeRoute(routenameUUID, schoolUUID, username, direction string, dryRun bool) (dto.RouteOptimizationResponseDTO, error)
 }
 
 type routeService struct {
 	routeRepository repositories.RouteRepositoryInterface
+	calendarService CalendarServiceInterface
 }
 
-func NewRouteService(routeRepository repositories.RouteRepositoryInterface) RouteServiceInterface {
+func NewRouteService(routeRepository repositories.RouteRepositoryInterface, calendarService CalendarServiceInterface) RouteServiceInterface {
 	return &routeService{
 		routeRepository: routeRepository,
+		calendarService: calendarService,
 	}
 }
 
@@ -60,6 +63,8 @@ func (s *routeService) GetSpecRouteByAS(routeNameUUID, driverUUID string) (dto.R
 	routeResponse.RouteNameUUID = routes[0].RouteNameUUID
 	routeResponse.RouteName = routes[0].RouteName
 	routeResponse.RouteDescription = routes[0].RouteDescription
+	routeResponse.MorningDepartureTime = routes[0].MorningDepartureTime
+	routeResponse.AfternoonDepartureTime = routes[0].AfternoonDepartureTime
 
 	if routes[0].DriverUUID == uuid.Nil {
 		routeResponse.RouteAssignment = nil
@@ -78,6 +83,7 @@ func (s *routeService) GetSpecRouteByAS(routeNameUUID, driverUUID string) (dto.R
 			StudentLastName:  defaultString(route.StudentLastName),
 			StudentStatus: route.StudentStatus,
 			StudentOrder:     route.StudentOrder,
+			AfternoonStudentOrder: route.AfternoonStudentOrder,
 		}
 		driverInfo.Students = append(driverInfo.Students, student)
 	}
@@ -93,12 +99,77 @@ func defaultString(str string) string {
 	return str
 }
 
-func (service *routeService) GetAllRoutesByDriver(driverUUID string) ([]dto.RouteResponseByDriverDTO, error) {
-	routes, err := service.routeRepository.FetchAllRoutesByDriver(driverUUID)
+// Returns the driver's students of one leg in visiting order together with the stops they are
+// collected at or dropped off at. Students without a stop are only part of the flat list.
+// Without a direction the leg the driver is currently on is picked. On days the school
+// doesn't operate no students are returned.
+func (service *routeService) GetAllRoutesByDriver(driverUUID, direction string) (dto.DriverRouteResponseDTO, error) {
+	now := time.Now()
+	schedule, err := service.routeRepository.FetchDriverRouteSchedule(driverUUID)
+	if err != nil && err != sql.ErrNoRows {
+		return dto.DriverRouteResponseDTO{}, err
+	}
+	if direction == "" {
+		direction = currentRouteDirection(schedule, now)
+	}
+
+	response := dto.DriverRouteResponseDTO{
+		OperatingDay: true,
+		Direction:    direction,
+		Routes:       []dto.RouteResponseByDriverDTO{},
+		Stops:        []dto.DriverRouteStopDTO{},
+	}
+	departure := schedule.MorningDepartureTime
+	if direction == entity.RouteDirectionAfternoon {
+		departure = schedule.AfternoonDepartureTime
+	}
+	if departure.Valid {
+		response.DepartureTime = &departure.String
+	}
+
+	if err == nil {
+		reason, err := service.calendarService.ClosedReason(schedule.SchoolUUID.String(), now)
+		if err != nil {
+			return dto.DriverRouteResponseDTO{}, err
+		}
+		if reason != "" {
+			response.OperatingDay = false
+			response.ClosedReason = reason
+			return response, nil
+		}
+	}
+
+	routes, err := service.routeRepository.FetchAllRoutesByDriver(driverUUID, direction)
 	if err != nil {
-		return nil, err
+		return dto.DriverRouteResponseDTO{}, err
 	}
-	return routes, nil
+
+	stops := []dto.DriverRouteStopDTO{}
+	for _, route := range routes {
+		if route.RoutePointUUID == nil {
+			continue
+		}
+		// Rows come sorted by stop, so a student either joins the last stop or opens a new one
+		if len(stops) == 0 || stops[len(stops)-1].RoutePointUUID != *route.RoutePointUUID {
+			stop := dto.DriverRouteStopDTO{RoutePointUUID: *route.RoutePointUUID}
+			if route.RoutePointName != nil {
+				stop.Name = *route.RoutePointName
+			}
+			if route.RoutePointOrder != nil {
+				stop.Order = *route.RoutePointOrder
+			}
+			if route.RoutePointLatitude != nil && route.RoutePointLongitude != nil {
+				stop.Latitude = *route.RoutePointLatitude
+				stop.Longitude = *route.RoutePointLongitude
+			}
+			stops = append(stops, stop)
+		}
+		stops[len(stops)-1].Students = append(stops[len(stops)-1].Students, route)
+	}
+
+	response.Routes = routes
+	response.Stops = stops
+	return response, nil
 }
 
 func (service *routeService) AddRoute(route dto.RoutesRequestDTO, schoolUUID, username string) error {
@@ -108,6 +179,8 @@ func (service *routeService) AddRoute(route dto.RoutesRequestDTO, schoolUUID, us
 		SchoolUUID:       uuid.MustParse(schoolUUID),
 		RouteName:        route.RouteName,
 		RouteDescription: route.RouteDescription,
+		MorningDepartureTime:   sql.NullString{String: route.MorningDepartureTime, Valid: route.MorningDepartureTime != ""},
+		AfternoonDepartureTime: sql.NullString{String: route.AfternoonDepartureTime, Valid: route.AfternoonDepartureTime != ""},
 		CreatedAt:        sql.NullTime{Time: time.Now(), Valid: true},
 		CreatedBy:        sql.NullString{String: username, Valid: true},
 	}
@@ -164,6 +237,7 @@ for _, assignment := range route.RouteAssignment {
 				DriverUUID:    assignment.DriverUUID,
 				StudentUUID:   student.StudentUUID,
 				StudentOrder:  student.StudentOrder,
+				AfternoonStudentOrder: student.AfternoonStudentOrder,
 				SchoolUUID:    uuid.MustParse(schoolUUID),
 				RouteNameUUID: routeEntity.RouteNameUUID.String(),
 				CreatedAt:     sql.NullTime{Time: time.Now(), Valid: true},
@@ -207,6 +281,8 @@ func (service *routeService) UpdateRoute(route dto.RoutesRequestDTO, routenameUU
 		SchoolUUID:       uuid.MustParse(schoolUUID),
 		RouteName:        route.RouteName,
 		RouteDescription: route.RouteDescription,
+		MorningDepartureTime:   sql.NullString{String: route.MorningDepartureTime, Valid: route.MorningDepartureTime != ""},
+		AfternoonDepartureTime: sql.NullString{String: route.AfternoonDepartureTime, Valid: route.AfternoonDepartureTime != ""},
 		UpdatedAt:        sql.NullTime{Time: time.Now(), Valid: true},
 		UpdatedBy:        sql.NullString{String: username, Valid: true},
 	}
@@ -235,6 +311,7 @@ func (service *routeService) UpdateRoute(route dto.RoutesRequestDTO, routenameUU
 				DriverUUID:    assignment.DriverUUID,
 				StudentUUID:   student.StudentUUID,
 				StudentOrder:  student.StudentOrder,
+				AfternoonStudentOrder: student.AfternoonStudentOrder,
 				SchoolUUID:    uuid.MustParse(schoolUUID),
 				CreatedAt:     sql.NullTime{Time: time.Now(), Valid: true},
 				CreatedBy:     sql.NullString{String: username, Valid: true},
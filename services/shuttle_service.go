@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"shuttle/errors"
 	"shuttle/models/dto"
 	"shuttle/models/entity"
 	"shuttle/repositories"
@@ -14,20 +15,37 @@ import (
 
 type ShuttleServiceInterface interface {
 	GetShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.ShuttleResponse, error)
-	GetAllShuttleByParent(parentUUID uuid.UUID) ([]dto.ShuttleAllResponse, error)
+	GetAllShuttleByParent(parentUUID uuid.UUID, from, to time.Time) ([]dto.ShuttleAllResponse, error)
 	GetAllShuttleByDriver(driverUUID uuid.UUID) ([]dto.ShuttleAllResponse, error)
 	GetSpecShuttle(shuttleUUID uuid.UUID) ([]dto.ShuttleSpecResponse, error)
 	AddShuttle(req dto.ShuttleRequest, driverUUID, createdBy string) error
-	EditShuttleStatus(shuttleUUID, status string) error
+	EditShuttleStatus(shuttleUUID string, req dto.ShuttleStatusRequest, driverUUID, changedBy string) error
+	RecordStudentEvent(shuttleUUID, event string, req dto.ShuttleStudentEventRequest, driverUUID, changedBy string) error
+	GetShuttleTimeline(shuttleUUID uuid.UUID, userUUID, schoolUUID string) (dto.ShuttleTimelineResponse, error)
+	GetStudentTimeline(studentUUID uuid.UUID, userUUID, schoolUUID string, date time.Time) (dto.StudentTimelineResponse, error)
+	GetShuttleTrail(shuttleUUID uuid.UUID, userUUID, schoolUUID string, from, to time.Time) (dto.ShuttleTrailResponse, error)
+	GetShuttleLastLocation(shuttleUUID uuid.UUID, userUUID, schoolUUID string) (dto.ShuttleLastLocationResponse, error)
+	GetShuttleETA(shuttleUUID uuid.UUID, userUUID, schoolUUID string) (dto.StudentETAResponse, error)
+}
+
+// Arrival estimates for every student of a driver's trip from the driver's last position,
+// empty when the driver hasn't reported a recent one
+type ETAEstimator interface {
+	EstimateDriverTrip(driverUUID string) ([]dto.StudentETAResponse, error)
+	Invalidate(driverUUID string)
 }
 
 type ShuttleService struct {
 	shuttleRepository repositories.ShuttleRepositoryInterface
+	notifier          UserNotifier
+	etaEstimator      ETAEstimator
 }
 
-func NewShuttleService(shuttleRepository repositories.ShuttleRepositoryInterface) ShuttleServiceInterface {
+func NewShuttleService(shuttleRepository repositories.ShuttleRepositoryInterface, notifier UserNotifier, etaEstimator ETAEstimator) ShuttleServiceInterface {
 	return &ShuttleService{
 		shuttleRepository: shuttleRepository,
+		notifier:          notifier,
+		etaEstimator:      etaEstimator,
 	}
 }
 
@@ -42,6 +60,8 @@ func (s *ShuttleService) GetShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.Sh
 	}
 
 	log.Println("Fetched shuttle data:", shuttles)
+	// Siblings usually ride with the same driver, so every driver's trip is estimated once
+	estimatesByDriver := make(map[string][]dto.StudentETAResponse)
 	responses := make([]dto.ShuttleResponse, 0, len(shuttles))
 	for _, shuttle := range shuttles {
 		response := &dto.ShuttleResponse{
@@ -56,18 +76,46 @@ func (s *ShuttleService) GetShuttleTrackByParent(parentUUID uuid.UUID) ([]dto.Sh
 			CreatedAt:      shuttle.CreatedAt,
 			CurrentDate:    shuttle.CurrentDate,
 		}
+		if contains(activeShuttleStatuses, shuttle.ShuttleStatus) {
+			estimates, estimated := estimatesByDriver[shuttle.DriverUUID]
+			if !estimated {
+				estimates, _ = s.etaEstimator.EstimateDriverTrip(shuttle.DriverUUID)
+				estimatesByDriver[shuttle.DriverUUID] = estimates
+			}
+			response.ETA = findShuttleETA(estimates, shuttle.ShuttleUUID)
+		}
 		responses = append(responses, *response)
 	}
 
 	return responses, nil
 }
 
-func (s *ShuttleService) GetAllShuttleByParent(parentUUID uuid.UUID) ([]dto.ShuttleAllResponse, error) {
+// Nil when the driver's trip has no estimate for the shuttle
+func findShuttleETA(estimates []dto.StudentETAResponse, shuttleUUID string) *dto.StudentETAResponse {
+	for _, estimate := range estimates {
+		if estimate.ShuttleUUID == shuttleUUID {
+			return &estimate
+		}
+	}
+	return nil
+}
+
+// Shuttles created from `from` up to but not including `to`, with their pickup and drop-off events
+func (s *ShuttleService) GetAllShuttleByParent(parentUUID uuid.UUID, from, to time.Time) ([]dto.ShuttleAllResponse, error) {
 	// Fetch data from the repository
-	shuttles, err := s.shuttleRepository.FetchAllShuttleByParent(parentUUID)
+	shuttles, err := s.shuttleRepository.FetchAllShuttleByParent(parentUUID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.shuttleRepository.FetchParentShuttleStatusHistory(parentUUID, from, to)
 	if err != nil {
 		return nil, err
 	}
+	eventsByShuttle := make(map[string][]dto.ShuttleTimelineEvent)
+	for _, event := range toShuttleTimelineEvents(history) {
+		eventsByShuttle[event.ShuttleUUID] = append(eventsByShuttle[event.ShuttleUUID], event)
+	}
 
 	// Transform the data if needed (DTO is already in the required format)
 	responses := make([]dto.ShuttleAllResponse, len(shuttles))
@@ -86,11 +134,29 @@ func (s *ShuttleService) GetAllShuttleByParent(parentUUID uuid.UUID) ([]dto.Shut
 			CreatedAt:        shuttle.CreatedAt,
 			UpdatedAt:        shuttle.UpdatedAt,
 		}
+		applyRecapEvents(&responses[i], eventsByShuttle[shuttle.ShuttleUUID])
 	}
 
 	return responses, nil
 }
 
+// Events come oldest first, so a step recorded twice keeps its latest time
+func applyRecapEvents(response *dto.ShuttleAllResponse, events []dto.ShuttleTimelineEvent) {
+	for i := range events {
+		event := &events[i]
+		switch event.Status {
+		case entity.ShuttleStatusGoingToSchool:
+			response.PickedUpToSchool = event
+		case entity.ShuttleStatusAtSchool:
+			response.DroppedOffAtSchool = event
+		case entity.ShuttleStatusGoingToHome:
+			response.PickedUpFromSchool = event
+		case entity.ShuttleStatusHome:
+			response.DroppedOffAtHome = event
+		}
+	}
+}
+
 func (s *ShuttleService) GetAllShuttleByDriver(driverUUID uuid.UUID) ([]dto.ShuttleAllResponse, error) {
 	// Fetch data from the repository
 	shuttles, err := s.shuttleRepository.FetchAllShuttleByDriver(driverUUID)
@@ -156,6 +222,7 @@ func (s *ShuttleService) GetSpecShuttle(shuttleUUID uuid.UUID) ([]dto.ShuttleSpe
 			VehicleType:       shuttle.VehicleType,
 			VehicleColor:      shuttle.VehicleColor,
 			VehicleNumber:     shuttle.VehicleNumber,
+			AllowedNextStatuses: AllowedNextShuttleStatuses(shuttle.ShuttleStatus),
 		}
 		responses = append(responses, response)
 	}
@@ -187,6 +254,13 @@ func (s *ShuttleService) AddShuttle(req dto.ShuttleRequest, driverUUID, createdB
 		log.Println("AddShuttle: Set default status to 'waiting_to_be_taken_to_school'")
 	}
 
+	if !isValidShuttleStatus(req.Status) {
+		return errors.New(fmt.Sprintf("invalid shuttle status '%s'", req.Status), 400)
+	}
+	if !contains(shuttleInitialStatuses, req.Status) {
+		return errors.New(fmt.Sprintf("a new shuttle can't start as '%s', it has to start as '%s' or '%s'", req.Status, entity.ShuttleStatusWaitingToBeTakenToSchool, entity.ShuttleStatusWaitingToBeTakenToHome), 400)
+	}
+
 	// Log: Create shuttle entity
 	shuttle := entity.Shuttle{
 		ShuttleID:   time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
@@ -198,26 +272,360 @@ func (s *ShuttleService) AddShuttle(req dto.ShuttleRequest, driverUUID, createdB
 	}
 	log.Printf("AddShuttle: Created shuttle entity with ShuttleID - %d", shuttle.ShuttleID)
 
+	tx, err := s.shuttleRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Log: Attempt to save shuttle to repository
-	err = s.shuttleRepository.SaveShuttle(shuttle)
+	err = s.shuttleRepository.SaveShuttle(tx, shuttle)
 	if err != nil {
 		log.Println("AddShuttle: Failed to save shuttle")
 		return err
 	}
+
+	history := newShuttleStatusHistory(shuttle.ShuttleUUID, "", shuttle.Status, driverUUID, createdBy)
+	if err := s.shuttleRepository.SaveShuttleStatusHistory(tx, history); err != nil {
+		log.Println("AddShuttle: Failed to save shuttle status history")
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	log.Println("AddShuttle: Shuttle saved successfully")
 
+	s.notifyParent(shuttle.ShuttleUUID, shuttle.Status)
+
 	return nil
 }
 
-func (s *ShuttleService) EditShuttleStatus(shuttleUUID, status string) error {
+func (s *ShuttleService) EditShuttleStatus(shuttleUUID string, req dto.ShuttleStatusRequest, driverUUID, changedBy string) error {
+	status := req.Status
+	if !isValidShuttleStatus(status) {
+		return errors.New(fmt.Sprintf("invalid shuttle status '%s'", status), 400)
+	}
+
+	return s.changeShuttleStatus(shuttleUUID, "", req.Latitude, req.Longitude, driverUUID, changedBy, func(current string) (string, error) {
+		if !canTransitionShuttleStatus(current, status) {
+			return "", errors.New(fmt.Sprintf("cannot change shuttle status from '%s' to '%s'", current, status), 409)
+		}
+		return status, nil
+	})
+}
+
+// Pickups and drop-offs reported over the shuttle socket, stored like any other status change
+func (s *ShuttleService) RecordStudentEvent(shuttleUUID, event string, req dto.ShuttleStudentEventRequest, driverUUID, changedBy string) error {
+	transitions, exists := shuttleEventTransitions[event]
+	if !exists {
+		return errors.New(fmt.Sprintf("invalid shuttle event '%s'", event), 400)
+	}
+
+	return s.changeShuttleStatus(shuttleUUID, req.StudentUUID, req.Latitude, req.Longitude, driverUUID, changedBy, func(current string) (string, error) {
+		next, exists := transitions[current]
+		if !exists {
+			return "", errors.New(fmt.Sprintf("cannot record %s while the shuttle is '%s'", event, current), 409)
+		}
+		return next, nil
+	})
+}
+
+// Locks the shuttle, lets nextStatus pick the new status from the current one, then stores it with
+// its history entry and tells the parent. studentUUID, when given, has to be the shuttle's student.
+func (s *ShuttleService) changeShuttleStatus(shuttleUUID, studentUUID string, latitude, longitude *float64, driverUUID, changedBy string, nextStatus func(current string) (string, error)) error {
 	shuttleUUIDParsed, err := uuid.Parse(shuttleUUID)
+	if err != nil {
+		return errors.New("invalid shuttle UUID format", 400)
+	}
+
+	tx, err := s.shuttleRepository.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	shuttle, err := s.shuttleRepository.FetchShuttleForUpdate(tx, shuttleUUIDParsed)
+	if err != nil {
+		return err
+	}
+
+	if shuttle.DriverUUID.String() != driverUUID {
+		return errors.New("you are not the driver of this shuttle", 403)
+	}
+
+	if studentUUID != "" && shuttle.StudentUUID.String() != studentUUID {
+		return errors.New("the student is not on this shuttle", 400)
+	}
+
+	status, err := nextStatus(shuttle.Status)
 	if err != nil {
 		return err
 	}
 
-	if err := s.shuttleRepository.UpdateShuttleStatus(shuttleUUIDParsed, status); err != nil {
+	if err := s.shuttleRepository.UpdateShuttleStatus(tx, shuttleUUIDParsed, status); err != nil {
 		return err
 	}
 
+	history := newShuttleStatusHistory(shuttleUUIDParsed, shuttle.Status, status, driverUUID, changedBy)
+	if latitude != nil && longitude != nil {
+		history.Latitude = sql.NullFloat64{Float64: *latitude, Valid: true}
+		history.Longitude = sql.NullFloat64{Float64: *longitude, Valid: true}
+	} else if location, err := s.shuttleRepository.FetchShuttleLastLocation(shuttleUUIDParsed); err == nil && time.Since(location.RecordedAt) <= statusLocationMaxAge {
+		history.Latitude = sql.NullFloat64{Float64: location.Latitude, Valid: true}
+		history.Longitude = sql.NullFloat64{Float64: location.Longitude, Valid: true}
+	}
+
+	if err := s.shuttleRepository.SaveShuttleStatusHistory(tx, history); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The cached trip still has the student at the old status
+	s.etaEstimator.Invalidate(driverUUID)
+	s.notifyParent(shuttleUUIDParsed, status)
+
 	return nil
 }
+
+var shuttleStatusMessages = map[string]string{
+	entity.ShuttleStatusHome:                     "%s has arrived home",
+	entity.ShuttleStatusWaitingToBeTakenToSchool: "%s is waiting to be picked up for school",
+	entity.ShuttleStatusGoingToSchool:            "%s has been picked up and is on the way to school",
+	entity.ShuttleStatusAtSchool:                 "%s has arrived at school",
+	entity.ShuttleStatusWaitingToBeTakenToHome:   "%s is waiting to be picked up from school",
+	entity.ShuttleStatusGoingToHome:              "%s has been picked up and is on the way home",
+}
+
+// Failing to notify never fails the status change, the dispatcher retries on its own
+func (s *ShuttleService) notifyParent(shuttleUUID uuid.UUID, status string) {
+	if s.notifier == nil {
+		return
+	}
+
+	access, err := s.shuttleRepository.FetchShuttleAccess(shuttleUUID)
+	if err != nil {
+		log.Printf("notifyParent: Failed to fetch shuttle access for %s - %s", shuttleUUID.String(), err.Error())
+		return
+	}
+	parentUUID, err := uuid.Parse(access.ParentUUID.String)
+	if !access.ParentUUID.Valid || err != nil {
+		return
+	}
+
+	studentName := "Your child"
+	if access.StudentFirstName.Valid && access.StudentFirstName.String != "" {
+		studentName = access.StudentFirstName.String
+	}
+
+	s.notifier.NotifyUser(parentUUID, "Shuttle update", fmt.Sprintf(shuttleStatusMessages[status], studentName), map[string]string{
+		"type":         "shuttle_status",
+		"shuttle_uuid": shuttleUUID.String(),
+		"status":       status,
+	})
+}
+
+// A status change without coordinates borrows the shuttle's last reported position if it is at most this old
+const statusLocationMaxAge = 2 * time.Minute
+
+func newShuttleStatusHistory(shuttleUUID uuid.UUID, fromStatus, toStatus, changedByUUID, changedBy string) entity.ShuttleStatusHistory {
+	return entity.ShuttleStatusHistory{
+		HistoryID:     time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		ShuttleUUID:   shuttleUUID,
+		FromStatus:    sql.NullString{String: fromStatus, Valid: fromStatus != ""},
+		ToStatus:      toStatus,
+		ChangedByUUID: sql.NullString{String: changedByUUID, Valid: changedByUUID != ""},
+		ChangedBy:     sql.NullString{String: changedBy, Valid: changedBy != ""},
+		ChangedAt:     time.Now(),
+	}
+}
+
+// Parents may only view their own child's shuttle, school admins (schoolUUID set) any shuttle of their school
+func (s *ShuttleService) checkShuttleViewer(shuttleUUID uuid.UUID, userUUID, schoolUUID string) error {
+	_, err := s.fetchViewableShuttle(shuttleUUID, userUUID, schoolUUID)
+	return err
+}
+
+// Same check as checkShuttleViewer, handing back the access row for callers that need more of it
+func (s *ShuttleService) fetchViewableShuttle(shuttleUUID uuid.UUID, userUUID, schoolUUID string) (entity.ShuttleAccess, error) {
+	access, err := s.shuttleRepository.FetchShuttleAccess(shuttleUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.ShuttleAccess{}, errors.New("shuttle not found", 404)
+		}
+		return entity.ShuttleAccess{}, err
+	}
+
+	if schoolUUID != "" {
+		if !access.SchoolUUID.Valid || access.SchoolUUID.String != schoolUUID {
+			return entity.ShuttleAccess{}, errors.New("you don't have access to this shuttle", 403)
+		}
+		return access, nil
+	}
+
+	if !access.ParentUUID.Valid || access.ParentUUID.String != userUUID {
+		return entity.ShuttleAccess{}, errors.New("you don't have access to this shuttle", 403)
+	}
+
+	return access, nil
+}
+
+func (s *ShuttleService) GetShuttleTrail(shuttleUUID uuid.UUID, userUUID, schoolUUID string, from, to time.Time) (dto.ShuttleTrailResponse, error) {
+	if err := s.checkShuttleViewer(shuttleUUID, userUUID, schoolUUID); err != nil {
+		return dto.ShuttleTrailResponse{}, err
+	}
+
+	locations, err := s.shuttleRepository.FetchShuttleTrail(shuttleUUID, from, to)
+	if err != nil {
+		return dto.ShuttleTrailResponse{}, err
+	}
+
+	points := make([]dto.ShuttleLocationResponse, 0, len(locations))
+	for _, location := range locations {
+		points = append(points, toShuttleLocationResponse(location))
+	}
+
+	return dto.ShuttleTrailResponse{
+		ShuttleUUID: shuttleUUID.String(),
+		From:        from.Format(time.RFC3339),
+		To:          to.Format(time.RFC3339),
+		Points:      points,
+	}, nil
+}
+
+func (s *ShuttleService) GetShuttleLastLocation(shuttleUUID uuid.UUID, userUUID, schoolUUID string) (dto.ShuttleLastLocationResponse, error) {
+	if err := s.checkShuttleViewer(shuttleUUID, userUUID, schoolUUID); err != nil {
+		return dto.ShuttleLastLocationResponse{}, err
+	}
+
+	location, err := s.shuttleRepository.FetchShuttleLastLocation(shuttleUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return dto.ShuttleLastLocationResponse{}, errors.New("no location has been reported for this shuttle yet", 404)
+		}
+		return dto.ShuttleLastLocationResponse{}, err
+	}
+
+	return dto.ShuttleLastLocationResponse{
+		ShuttleUUID:             shuttleUUID.String(),
+		ShuttleLocationResponse: toShuttleLocationResponse(location),
+		AgeSeconds:              int64(time.Since(location.RecordedAt).Seconds()),
+	}, nil
+}
+
+func (s *ShuttleService) GetShuttleETA(shuttleUUID uuid.UUID, userUUID, schoolUUID string) (dto.StudentETAResponse, error) {
+	access, err := s.fetchViewableShuttle(shuttleUUID, userUUID, schoolUUID)
+	if err != nil {
+		return dto.StudentETAResponse{}, err
+	}
+
+	estimates, err := s.etaEstimator.EstimateDriverTrip(access.DriverUUID.String())
+	if err != nil {
+		return dto.StudentETAResponse{}, err
+	}
+
+	if estimate := findShuttleETA(estimates, access.ShuttleUUID.String()); estimate != nil {
+		return *estimate, nil
+	}
+
+	return dto.StudentETAResponse{}, errors.New("no arrival estimate is available for this shuttle right now", 404)
+}
+
+func (s *ShuttleService) GetShuttleTimeline(shuttleUUID uuid.UUID, userUUID, schoolUUID string) (dto.ShuttleTimelineResponse, error) {
+	if err := s.checkShuttleViewer(shuttleUUID, userUUID, schoolUUID); err != nil {
+		return dto.ShuttleTimelineResponse{}, err
+	}
+
+	history, err := s.shuttleRepository.FetchShuttleStatusHistory(shuttleUUID)
+	if err != nil {
+		return dto.ShuttleTimelineResponse{}, err
+	}
+
+	return dto.ShuttleTimelineResponse{
+		ShuttleUUID: shuttleUUID.String(),
+		Events:      toShuttleTimelineEvents(history),
+	}, nil
+}
+
+// The timeline of a student covers every shuttle of that student within the given day
+func (s *ShuttleService) GetStudentTimeline(studentUUID uuid.UUID, userUUID, schoolUUID string, date time.Time) (dto.StudentTimelineResponse, error) {
+	access, err := s.shuttleRepository.FetchStudentAccess(studentUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return dto.StudentTimelineResponse{}, errors.New("student not found", 404)
+		}
+		return dto.StudentTimelineResponse{}, err
+	}
+
+	if schoolUUID != "" {
+		if !access.SchoolUUID.Valid || access.SchoolUUID.String != schoolUUID {
+			return dto.StudentTimelineResponse{}, errors.New("you don't have access to this student", 403)
+		}
+	} else if !access.ParentUUID.Valid || access.ParentUUID.String != userUUID {
+		return dto.StudentTimelineResponse{}, errors.New("you don't have access to this student", 403)
+	}
+
+	from := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	history, err := s.shuttleRepository.FetchStudentStatusHistory(studentUUID, from, from.AddDate(0, 0, 1))
+	if err != nil {
+		return dto.StudentTimelineResponse{}, err
+	}
+
+	return dto.StudentTimelineResponse{
+		StudentUUID: studentUUID.String(),
+		Date:        from.Format("2006-01-02"),
+		Events:      toShuttleTimelineEvents(history),
+	}, nil
+}
+
+func toShuttleTimelineEvents(history []entity.ShuttleStatusHistory) []dto.ShuttleTimelineEvent {
+	events := make([]dto.ShuttleTimelineEvent, 0, len(history))
+	for _, h := range history {
+		event := dto.ShuttleTimelineEvent{
+			ShuttleUUID: h.ShuttleUUID.String(),
+			Status:      h.ToStatus,
+			ChangedAt:   h.ChangedAt.Format(time.RFC3339),
+		}
+		if h.FromStatus.Valid {
+			fromStatus := h.FromStatus.String
+			event.FromStatus = &fromStatus
+		}
+		if h.ChangedByUUID.Valid {
+			driverUUID := h.ChangedByUUID.String
+			event.DriverUUID = &driverUUID
+		}
+		if h.ChangedBy.Valid {
+			changedBy := h.ChangedBy.String
+			event.ChangedBy = &changedBy
+		}
+		if h.Latitude.Valid && h.Longitude.Valid {
+			latitude, longitude := h.Latitude.Float64, h.Longitude.Float64
+			event.Latitude = &latitude
+			event.Longitude = &longitude
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func toShuttleLocationResponse(location entity.ShuttleLocation) dto.ShuttleLocationResponse {
+	response := dto.ShuttleLocationResponse{
+		Latitude:   location.Latitude,
+		Longitude:  location.Longitude,
+		RecordedAt: location.RecordedAt.Format(time.RFC3339),
+	}
+	if location.Speed.Valid {
+		speed := location.Speed.Float64
+		response.Speed = &speed
+	}
+	if location.Heading.Valid {
+		heading := location.Heading.Float64
+		response.Heading = &heading
+	}
+
+	return response
+}
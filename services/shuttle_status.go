@@ -0,0 +1,56 @@
+package services
+
+import "shuttle/models/entity"
+
+// A shuttle goes around the same loop every school day, each status has exactly one successor
+var shuttleStatusTransitions = map[string]string{
+	entity.ShuttleStatusHome:                     entity.ShuttleStatusWaitingToBeTakenToSchool,
+	entity.ShuttleStatusWaitingToBeTakenToSchool: entity.ShuttleStatusGoingToSchool,
+	entity.ShuttleStatusGoingToSchool:            entity.ShuttleStatusAtSchool,
+	entity.ShuttleStatusAtSchool:                 entity.ShuttleStatusWaitingToBeTakenToHome,
+	entity.ShuttleStatusWaitingToBeTakenToHome:   entity.ShuttleStatusGoingToHome,
+	entity.ShuttleStatusGoingToHome:              entity.ShuttleStatusHome,
+}
+
+// A pickup or drop-off reported by the driver means the next step of whichever leg the shuttle is on
+var shuttleEventTransitions = map[string]map[string]string{
+	entity.ShuttleEventStudentPickedUp: {
+		entity.ShuttleStatusWaitingToBeTakenToSchool: entity.ShuttleStatusGoingToSchool,
+		entity.ShuttleStatusWaitingToBeTakenToHome:   entity.ShuttleStatusGoingToHome,
+	},
+	entity.ShuttleEventStudentDroppedOff: {
+		entity.ShuttleStatusGoingToSchool: entity.ShuttleStatusAtSchool,
+		entity.ShuttleStatusGoingToHome:   entity.ShuttleStatusHome,
+	},
+}
+
+// A new shuttle starts at the beginning of a leg, the morning one or the afternoon one for
+// students who are only taken home
+var shuttleInitialStatuses = []string{
+	entity.ShuttleStatusWaitingToBeTakenToSchool,
+	entity.ShuttleStatusWaitingToBeTakenToHome,
+}
+
+func AllowedNextShuttleStatuses(current string) []string {
+	next, exists := shuttleStatusTransitions[current]
+	if !exists {
+		return []string{}
+	}
+	return []string{next}
+}
+
+func canTransitionShuttleStatus(from, to string) bool {
+	return contains(AllowedNextShuttleStatuses(from), to)
+}
+
+func isValidShuttleStatus(status string) bool {
+	return contains(entity.ShuttleStatuses, status)
+}
+
+// Statuses in which the driver still has to reach the student or the school, the ones an ETA is given for
+var activeShuttleStatuses = []string{
+	entity.ShuttleStatusWaitingToBeTakenToSchool,
+	entity.ShuttleStatusGoingToSchool,
+	entity.ShuttleStatusWaitingToBeTakenToHome,
+	entity.ShuttleStatusGoingToHome,
+}
@@ -32,6 +32,7 @@ type UserServiceInterface interface {
 	GetAllDriverFromAllSchools(page int, limit int, sortField string, sortDirection string) ([]dto.UserResponseDTO, int, error)
 	GetAllDriverForPermittedSchool(page int, limit int, sortField string, sortDirection string, schoolUUID string) ([]dto.UserResponseDTO, int, error)
 	GetSpecDriverFromAllSchools(uuid string) (dto.UserResponseDTO, error)
+	GetOnlineDriversForPermittedSchool(schoolUUID string) ([]dto.DriverPresenceResponseDTO, error)
 
 	AddUser(req dto.UserRequestsDTO, user_name string) (uuid.UUID, error)
 	UpdateUser(id string, user dto.UserRequestsDTO, user_name string, file []byte) error
@@ -308,6 +309,32 @@ func (service *UserService) GetAllDriverForPermittedSchool(page int, limit int,
 }
 
 
+func (service *UserService) GetOnlineDriversForPermittedSchool(schoolUUID string) ([]dto.DriverPresenceResponseDTO, error) {
+	drivers, err := service.userRepository.FetchOnlineDriversForPermittedSchool(schoolUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	driversDTO := make([]dto.DriverPresenceResponseDTO, 0, len(drivers))
+	for _, driver := range drivers {
+		driverDTO := dto.DriverPresenceResponseDTO{
+			UUID:        driver.UserUUID.String(),
+			Username:    driver.Username,
+			FirstName:   driver.FirstName.String,
+			LastName:    driver.LastName.String,
+			Phone:       driver.Phone.String,
+			VehicleUUID: driver.VehicleUUID.String,
+			Status:      driver.Status,
+		}
+		if driver.LastActive.Valid {
+			driverDTO.LastActive = driver.LastActive.Time.Format(time.RFC3339)
+		}
+		driversDTO = append(driversDTO, driverDTO)
+	}
+
+	return driversDTO, nil
+}
+
 func (service *UserService) GetSpecSuperAdmin(uuid string) (dto.UserResponseDTO, error) {
 	user, err := service.userRepository.FetchSpecSuperAdmin(uuid)
 	if err != nil {
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"shuttle/databases"
+	"shuttle/logger"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Fan-out for shuttle groups. Every instance keeps its own sockets, the broker decides how a
+// published message reaches the subscribers connected to other instances.
+type Broker interface {
+	Join(shuttleUUID string, client *Client)
+	Leave(shuttleUUID string, client *Client)
+	Publish(shuttleUUID string, message []byte) error
+}
+
+////////////////////////////////////// IN MEMORY //////////////////////////////////////
+
+// Single-instance broker, publishing only reaches sockets held by this process
+type InMemoryBroker struct {
+	groups map[string]map[*Client]struct{}
+	mutex  sync.RWMutex
+}
+
+func NewInMemoryBroker() *InMemoryBroker {
+	return &InMemoryBroker{
+		groups: make(map[string]map[*Client]struct{}),
+	}
+}
+
+func (b *InMemoryBroker) Join(shuttleUUID string, client *Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, exists := b.groups[shuttleUUID]; !exists {
+		b.groups[shuttleUUID] = make(map[*Client]struct{})
+	}
+	b.groups[shuttleUUID][client] = struct{}{}
+}
+
+func (b *InMemoryBroker) Leave(shuttleUUID string, client *Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if group, exists := b.groups[shuttleUUID]; exists {
+		delete(group, client)
+		if len(group) == 0 {
+			delete(b.groups, shuttleUUID)
+		}
+	}
+}
+
+func (b *InMemoryBroker) Publish(shuttleUUID string, message []byte) error {
+	b.deliver(shuttleUUID, message)
+	return nil
+}
+
+// Hand the message to every local subscriber's queue. The lock only covers taking a snapshot of
+// the group, and subscribers whose queue is full are evicted rather than waited on.
+func (b *InMemoryBroker) deliver(shuttleUUID string, message []byte) {
+	b.mutex.RLock()
+	clients := make([]*Client, 0, len(b.groups[shuttleUUID]))
+	for client := range b.groups[shuttleUUID] {
+		clients = append(clients, client)
+	}
+	b.mutex.RUnlock()
+
+	for _, client := range clients {
+		if !client.Send(message) {
+			logger.LogWarn("Evicting slow WebSocket subscriber", map[string]interface{}{"ShuttleUUID": shuttleUUID, "UserUUID": client.UserUUID})
+			b.Leave(shuttleUUID, client)
+			client.CloseWithCode(websocket.CloseTryAgainLater, "Subscriber is too slow")
+		}
+	}
+}
+
+////////////////////////////////////// POSTGRES //////////////////////////////////////
+
+const (
+	shuttleBrokerChannel = "shuttle_broadcast"
+	maxNotifyPayload     = 7900 // Postgres caps NOTIFY payloads at 8000 bytes
+)
+
+type brokerEnvelope struct {
+	NodeID      string `json:"node_id"`
+	ShuttleUUID string `json:"shuttle_uuid"`
+	Message     string `json:"message"`
+}
+
+// Multi-instance broker relaying messages through Postgres LISTEN/NOTIFY. Messages are delivered
+// to local sockets straight away and ignored when they come back from the database.
+type PostgresBroker struct {
+	*InMemoryBroker
+	db       *sqlx.DB
+	listener *pq.Listener
+	nodeID   string
+}
+
+func NewPostgresBroker(db *sqlx.DB) (*PostgresBroker, error) {
+	listener := pq.NewListener(databases.PostgresURI(), 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.LogError(err, "Shuttle broker listener event", map[string]interface{}{"event": event})
+		}
+	})
+	if err := listener.Listen(shuttleBrokerChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", shuttleBrokerChannel, err)
+	}
+
+	broker := &PostgresBroker{
+		InMemoryBroker: NewInMemoryBroker(),
+		db:             db,
+		listener:       listener,
+		nodeID:         uuid.New().String(),
+	}
+	go broker.listen()
+
+	return broker, nil
+}
+
+func (b *PostgresBroker) Publish(shuttleUUID string, message []byte) error {
+	b.deliver(shuttleUUID, message)
+
+	payload, err := json.Marshal(brokerEnvelope{
+		NodeID:      b.nodeID,
+		ShuttleUUID: shuttleUUID,
+		Message:     string(message),
+	})
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return fmt.Errorf("message too large to relay: %d bytes", len(payload))
+	}
+
+	if _, err := b.db.Exec("SELECT pg_notify($1, $2)", shuttleBrokerChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", shuttleBrokerChannel, err)
+	}
+
+	return nil
+}
+
+func (b *PostgresBroker) listen() {
+	for {
+		select {
+		case notification := <-b.listener.Notify:
+			// nil is sent after the listener reconnects, anything published meanwhile is lost
+			if notification == nil {
+				continue
+			}
+
+			var envelope brokerEnvelope
+			if err := json.Unmarshal([]byte(notification.Extra), &envelope); err != nil {
+				logger.LogError(err, "Invalid shuttle broker payload", nil)
+				continue
+			}
+			if envelope.NodeID == b.nodeID {
+				continue
+			}
+
+			b.deliver(envelope.ShuttleUUID, []byte(envelope.Message))
+		case <-time.After(90 * time.Second):
+			if err := b.listener.Ping(); err != nil {
+				logger.LogError(err, "Shuttle broker listener ping failed", nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package utils
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultETAAverageSpeedKmh = 25.0
+	defaultETAStopDwell       = 60 * time.Second
+	defaultETARouteFactor     = 1.3
+
+	// Trip stops are cached between fixes so a driver pinging every few seconds doesn't query them each time
+	etaTripCacheTTL = 15 * time.Second
+	// Positions older than this say nothing about when the driver arrives
+	etaLocationMaxAge = 10 * time.Minute
+	// Stops closer than this are treated as one, so the driver only waits there once
+	etaSameStopKm = 0.02
+)
+
+const (
+	ETATargetPickup = "pickup"
+	ETATargetSchool = "school"
+	ETATargetHome   = "home"
+)
+
+// Straight-line distances are stretched by RouteFactor to approximate the road and driven at
+// AverageSpeedKmh, every stop before the target adds StopDwell
+type ETAConfig struct {
+	AverageSpeedKmh float64
+	StopDwell       time.Duration
+	RouteFactor     float64
+}
+
+// Estimates when the driver reaches each student of the trip from the driver's last position
+type ETAEstimator struct {
+	shuttleRepository repositories.ShuttleRepositoryInterface
+	config            ETAConfig
+
+	trips     map[string]cachedTrip
+	fetching  map[string]*tripFetch
+	tripMutex sync.Mutex
+
+	publishing   map[string]bool
+	publishMutex sync.Mutex
+}
+
+type cachedTrip struct {
+	stops     []entity.TripStop
+	fetchedAt time.Time
+}
+
+// A trip query in flight, callers missing the cache meanwhile wait for it instead of running their own
+type tripFetch struct {
+	done  chan struct{}
+	stops []entity.TripStop
+	err   error
+}
+
+type etaWaypoint struct {
+	point  GeoPoint
+	target string
+	stops  []entity.TripStop
+}
+
+func NewETAEstimator(shuttleRepository repositories.ShuttleRepositoryInterface, config ETAConfig) *ETAEstimator {
+	return &ETAEstimator{
+		shuttleRepository: shuttleRepository,
+		config:            config,
+		trips:             make(map[string]cachedTrip),
+		fetching:          make(map[string]*tripFetch),
+		publishing:        make(map[string]bool),
+	}
+}
+
+// ETA_AVERAGE_SPEED_KMH, ETA_STOP_DWELL_SECONDS and ETA_ROUTE_FACTOR, unset or invalid values fall back to the defaults
+func NewETAEstimatorFromConfig(shuttleRepository repositories.ShuttleRepositoryInterface) *ETAEstimator {
+	config := ETAConfig{
+		AverageSpeedKmh: viper.GetFloat64("ETA_AVERAGE_SPEED_KMH"),
+		StopDwell:       time.Duration(viper.GetInt("ETA_STOP_DWELL_SECONDS")) * time.Second,
+		RouteFactor:     viper.GetFloat64("ETA_ROUTE_FACTOR"),
+	}
+	if config.AverageSpeedKmh <= 0 {
+		config.AverageSpeedKmh = defaultETAAverageSpeedKmh
+	}
+	if !viper.IsSet("ETA_STOP_DWELL_SECONDS") || config.StopDwell < 0 {
+		config.StopDwell = defaultETAStopDwell
+	}
+	if config.RouteFactor < 1 {
+		config.RouteFactor = defaultETARouteFactor
+	}
+
+	return NewETAEstimator(shuttleRepository, config)
+}
+
+// Estimates for every student of the driver's trip from the driver's last stored position,
+// nothing when the driver hasn't reported a recent one
+func (e *ETAEstimator) EstimateDriverTrip(driverUUID string) ([]dto.StudentETAResponse, error) {
+	location, err := e.shuttleRepository.FetchDriverLastLocation(driverUUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if time.Since(location.RecordedAt) > etaLocationMaxAge {
+		return nil, nil
+	}
+
+	stops, err := e.tripStops(driverUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.estimate(stops, location, time.Now()), nil
+}
+
+// Recomputes the trip from a fresh fix in the background and pushes each shuttle group the estimate
+// of its own student. Only one publish runs per driver, fixes arriving meanwhile are dropped since
+// the next one carries a newer position anyway.
+func (e *ETAEstimator) Publish(location entity.ShuttleLocation) {
+	if !location.DriverUUID.Valid {
+		return
+	}
+
+	driverUUID := location.DriverUUID.String
+	e.publishMutex.Lock()
+	if e.publishing[driverUUID] {
+		e.publishMutex.Unlock()
+		return
+	}
+	e.publishing[driverUUID] = true
+	e.publishMutex.Unlock()
+
+	go func() {
+		defer func() {
+			e.publishMutex.Lock()
+			delete(e.publishing, driverUUID)
+			e.publishMutex.Unlock()
+		}()
+		e.publish(location)
+	}()
+}
+
+func (e *ETAEstimator) publish(location entity.ShuttleLocation) {
+	stops, err := e.tripStops(location.DriverUUID.String)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch trip stops", map[string]interface{}{"DriverUUID": location.DriverUUID.String})
+		return
+	}
+
+	byShuttle := make(map[string][]StudentETA)
+	for _, estimate := range e.estimate(stops, location, location.RecordedAt) {
+		byShuttle[estimate.ShuttleUUID] = append(byShuttle[estimate.ShuttleUUID], StudentETA{
+			StudentUUID: estimate.StudentUUID,
+			Target:      estimate.Target,
+			ETASeconds:  estimate.ETASeconds,
+			DistanceKm:  estimate.DistanceKm,
+			ArrivalAt:   estimate.ArrivalAt,
+		})
+	}
+
+	for shuttleUUID, students := range byShuttle {
+		message, err := NewEnvelope(MessageTypeETAUpdate, shuttleUUID, ETAUpdatePayload{Students: students})
+		if err != nil {
+			logger.LogError(err, "Failed to encode ETA update", map[string]interface{}{"ShuttleUUID": shuttleUUID})
+			continue
+		}
+		BroadcastToShuttleGroup(shuttleUUID, message)
+	}
+}
+
+// Drops the cached trip so the next fix sees status changes right away
+func (e *ETAEstimator) Invalidate(driverUUID string) {
+	e.tripMutex.Lock()
+	defer e.tripMutex.Unlock()
+	delete(e.trips, driverUUID)
+	// A query already in flight may have read the old status, its result must not be cached
+	delete(e.fetching, driverUUID)
+}
+
+func (e *ETAEstimator) tripStops(driverUUID string) ([]entity.TripStop, error) {
+	e.tripMutex.Lock()
+	if trip, exists := e.trips[driverUUID]; exists && time.Since(trip.fetchedAt) < etaTripCacheTTL {
+		e.tripMutex.Unlock()
+		return trip.stops, nil
+	}
+	if fetch, exists := e.fetching[driverUUID]; exists {
+		e.tripMutex.Unlock()
+		<-fetch.done
+		return fetch.stops, fetch.err
+	}
+	fetch := &tripFetch{done: make(chan struct{})}
+	e.fetching[driverUUID] = fetch
+	e.tripMutex.Unlock()
+
+	fetch.stops, fetch.err = e.shuttleRepository.FetchDriverTripStops(driverUUID)
+
+	e.tripMutex.Lock()
+	defer e.tripMutex.Unlock()
+	defer close(fetch.done)
+	if e.fetching[driverUUID] != fetch {
+		return fetch.stops, fetch.err
+	}
+	delete(e.fetching, driverUUID)
+	if fetch.err != nil {
+		return nil, fetch.err
+	}
+
+	for cachedDriver, cached := range e.trips {
+		if time.Since(cached.fetchedAt) >= etaTripCacheTTL {
+			delete(e.trips, cachedDriver)
+		}
+	}
+	e.trips[driverUUID] = cachedTrip{stops: fetch.stops, fetchedAt: time.Now()}
+
+	return fetch.stops, nil
+}
+
+// Walks the remaining waypoints in order from the driver's position, each student gets the
+// arrival at their own waypoint; students without a usable point get no estimate
+func (e *ETAEstimator) estimate(stops []entity.TripStop, from entity.ShuttleLocation, now time.Time) []dto.StudentETAResponse {
+	waypoints := tripWaypoints(stops)
+	locationAge := int64(math.Max(0, now.Sub(from.RecordedAt).Seconds()))
+
+	estimates := make([]dto.StudentETAResponse, 0, len(stops))
+	position := GeoPoint{Latitude: from.Latitude, Longitude: from.Longitude}
+	distanceKm := 0.0
+	elapsed := time.Duration(0)
+	for i, waypoint := range waypoints {
+		if i > 0 {
+			elapsed += e.config.StopDwell
+		}
+		legKm := position.DistanceTo(waypoint.point) * e.config.RouteFactor
+		distanceKm += legKm
+		elapsed += time.Duration(legKm / e.config.AverageSpeedKmh * float64(time.Hour))
+		position = waypoint.point
+
+		arrivalAt := from.RecordedAt.Add(elapsed)
+		for _, stop := range waypoint.stops {
+			estimates = append(estimates, dto.StudentETAResponse{
+				ShuttleUUID:        stop.ShuttleUUID.String(),
+				StudentUUID:        stop.StudentUUID.String(),
+				ShuttleStatus:      stop.Status,
+				Target:             waypoint.target,
+				ETASeconds:         int64(math.Max(0, arrivalAt.Sub(now).Seconds())),
+				DistanceKm:         math.Round(distanceKm*100) / 100,
+				ArrivalAt:          arrivalAt.Format(time.RFC3339),
+				LocationAgeSeconds: locationAge,
+			})
+		}
+	}
+
+	return estimates
+}
+
+// The leg is decided like the driver's route list does: a student on the road first, then one waiting.
+// Morning: pickups in route order, then the school. Afternoon: the school for those still waiting there,
+// then drop-offs in the afternoon order.
+func tripWaypoints(stops []entity.TripStop) []etaWaypoint {
+	statuses := make(map[string]bool)
+	var school etaWaypoint
+	hasSchool := false
+	for _, stop := range stops {
+		statuses[stop.Status] = true
+		if !hasSchool {
+			if point, ok := ParseGeoPoint(stop.SchoolPoint.String); ok {
+				school = etaWaypoint{point: point, target: ETATargetSchool}
+				hasSchool = true
+			}
+		}
+	}
+
+	afternoon := statuses[entity.ShuttleStatusGoingToHome] ||
+		(!statuses[entity.ShuttleStatusGoingToSchool] && statuses[entity.ShuttleStatusWaitingToBeTakenToHome])
+
+	var waypoints []etaWaypoint
+	if !afternoon {
+		pickups := filterTripStops(stops, entity.ShuttleStatusWaitingToBeTakenToSchool)
+		sort.SliceStable(pickups, func(i, j int) bool {
+			return lessTripStop(pickups[i].MorningPointOrder, pickups[j].MorningPointOrder, pickups[i].StudentOrder, pickups[j].StudentOrder, false)
+		})
+		for _, stop := range pickups {
+			waypoints = appendStopWaypoint(waypoints, stop, stop.MorningLatitude, stop.MorningLongitude, ETATargetPickup)
+		}
+		if hasSchool {
+			school.stops = filterTripStops(stops, entity.ShuttleStatusGoingToSchool)
+			waypoints = append(waypoints, school)
+		}
+		return waypoints
+	}
+
+	if hasSchool {
+		if waiting := filterTripStops(stops, entity.ShuttleStatusWaitingToBeTakenToHome); len(waiting) > 0 {
+			school.stops = waiting
+			waypoints = append(waypoints, school)
+		}
+	}
+	dropOffs := filterTripStops(stops, entity.ShuttleStatusGoingToHome)
+	sort.SliceStable(dropOffs, func(i, j int) bool {
+		if dropOffs[i].AfternoonStudentOrder.Valid || dropOffs[j].AfternoonStudentOrder.Valid {
+			return lessTripStop(dropOffs[i].AfternoonPointOrder, dropOffs[j].AfternoonPointOrder, dropOffs[i].AfternoonStudentOrder, dropOffs[j].AfternoonStudentOrder, false)
+		}
+		return lessTripStop(dropOffs[i].AfternoonPointOrder, dropOffs[j].AfternoonPointOrder, dropOffs[i].StudentOrder, dropOffs[j].StudentOrder, true)
+	})
+	for _, stop := range dropOffs {
+		waypoints = appendStopWaypoint(waypoints, stop, stop.AfternoonLatitude, stop.AfternoonLongitude, ETATargetHome)
+	}
+
+	return waypoints
+}
+
+func filterTripStops(stops []entity.TripStop, status string) []entity.TripStop {
+	var filtered []entity.TripStop
+	for _, stop := range stops {
+		if stop.Status == status {
+			filtered = append(filtered, stop)
+		}
+	}
+	return filtered
+}
+
+// Stop order first, then the student order, unordered ones last. Without an afternoon order
+// students are dropped off in the reverse of their pickup order.
+func lessTripStop(pointA, pointB, orderA, orderB sql.NullInt64, reverse bool) bool {
+	if pointA.Valid != pointB.Valid {
+		return pointA.Valid
+	}
+	if pointA.Valid && pointA.Int64 != pointB.Int64 {
+		return pointA.Int64 < pointB.Int64
+	}
+	if orderA.Valid != orderB.Valid {
+		return orderA.Valid
+	}
+	if reverse {
+		return orderA.Int64 > orderB.Int64
+	}
+	return orderA.Int64 < orderB.Int64
+}
+
+// The route stop of the leg wins over the student's own pickup point, students at the same
+// spot as the previous waypoint share it
+func appendStopWaypoint(waypoints []etaWaypoint, stop entity.TripStop, stopLatitude, stopLongitude sql.NullFloat64, target string) []etaWaypoint {
+	point := GeoPoint{Latitude: stopLatitude.Float64, Longitude: stopLongitude.Float64}
+	if !stopLatitude.Valid || !stopLongitude.Valid {
+		var ok bool
+		if point, ok = ParseGeoPoint(stop.StudentPickupPoint.String); !ok {
+			return waypoints
+		}
+	}
+
+	if last := len(waypoints) - 1; last >= 0 && waypoints[last].target == target && waypoints[last].point.DistanceTo(point) < etaSameStopKm {
+		waypoints[last].stops = append(waypoints[last].stops, stop)
+		return waypoints
+	}
+
+	return append(waypoints, etaWaypoint{point: point, target: target, stops: []entity.TripStop{stop}})
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+)
+
+const earthRadiusKm = 6371.0
+
+// Pickup points and school points are stored as {"latitude": ..., "longitude": ...}
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// False when the stored point is missing, left at (0, 0) or out of range
+func ParseGeoPoint(raw string) (GeoPoint, bool) {
+	var point GeoPoint
+	if raw == "" || json.Unmarshal([]byte(raw), &point) != nil {
+		return GeoPoint{}, false
+	}
+	if point.Latitude == 0 && point.Longitude == 0 {
+		return GeoPoint{}, false
+	}
+	if math.Abs(point.Latitude) > 90 || math.Abs(point.Longitude) > 180 {
+		return GeoPoint{}, false
+	}
+	return point, true
+}
+
+func (p GeoPoint) DistanceTo(other GeoPoint) float64 {
+	return HaversineDistance(p.Latitude, p.Longitude, other.Latitude, other.Longitude)
+}
+
+// Great-circle distance between two coordinates, in kilometres
+func HaversineDistance(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Initial bearing from the first coordinate to the second, in degrees clockwise from north
+func Bearing(lat1, lng1, lat2, lng2 float64) float64 {
+	dLng := toRadians(lng2 - lng1)
+	y := math.Sin(dLng) * math.Cos(toRadians(lat2))
+	x := math.Cos(toRadians(lat1))*math.Sin(toRadians(lat2)) -
+		math.Sin(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Cos(dLng)
+
+	return math.Mod(toDegrees(math.Atan2(y, x))+360, 360)
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func toDegrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// A key that verifies tokens carrying its kid, and signs new ones if the private half is known
+type signingKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+// Keys for signing and verifying tokens.
+//
+// Without JWT_SIGNING_KEY_ID tokens are signed with HS256 and JWT_SECRET as before. Otherwise
+// every <kid>.pem in JWT_KEYS_DIR is loaded (RSA keys sign with RS256, Ed25519 keys with EdDSA)
+// and new tokens are signed with the key named by JWT_SIGNING_KEY_ID. To rotate, add the new key,
+// point JWT_SIGNING_KEY_ID at it, and keep the old file (the public half is enough) until the
+// last token it signed has expired. JWT_SECRET keeps validating HS256 tokens without a kid while it is set.
+type keyRing struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	secret  []byte
+}
+
+func loadKeyRing(secret []byte, dir, signingKeyID string) (*keyRing, error) {
+	ring := &keyRing{
+		keys:   make(map[string]*signingKey),
+		secret: secret,
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			key, err := loadSigningKey(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load JWT key %s: %w", file, err)
+			}
+			ring.keys[key.ID] = key
+		}
+	}
+
+	if signingKeyID == "" {
+		if len(secret) == 0 {
+			return nil, errors.New("JWT_SECRET or JWT_SIGNING_KEY_ID has to be set")
+		}
+		return ring, nil
+	}
+
+	key, ok := ring.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("JWT signing key %q not found in %q", signingKeyID, dir)
+	}
+	if key.PrivateKey == nil {
+		return nil, fmt.Errorf("JWT signing key %q has no private key", signingKeyID)
+	}
+	ring.signing = key
+
+	return ring, nil
+}
+
+func loadSigningKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	key := &signingKey{ID: strings.TrimSuffix(filepath.Base(file), ".pem")}
+
+	switch block.Type {
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		var parsed interface{}
+		if block.Type == "RSA PRIVATE KEY" {
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch private := parsed.(type) {
+		case *rsa.PrivateKey:
+			key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, private, &private.PublicKey
+		case ed25519.PrivateKey:
+			key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodEdDSA, private, private.Public()
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", parsed)
+		}
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		switch public := parsed.(type) {
+		case *rsa.PublicKey:
+			key.Method, key.PublicKey = jwt.SigningMethodRS256, public
+		case ed25519.PublicKey:
+			key.Method, key.PublicKey = jwt.SigningMethodEdDSA, public
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", parsed)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	return key, nil
+}
+
+func (ring *keyRing) sign(claims jwt.MapClaims) (string, error) {
+	if ring.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ring.secret)
+	}
+
+	token := jwt.NewWithClaims(ring.signing.Method, claims)
+	token.Header["kid"] = ring.signing.ID
+
+	return token.SignedString(ring.signing.PrivateKey)
+}
+
+// Picks the verification key by kid and refuses any algorithm other than the key's own,
+// so a token can't be verified with an RSA public key used as an HMAC secret
+func (ring *keyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if token.Method != jwt.SigningMethodHS256 || len(ring.secret) == 0 {
+			return nil, errors.New("token has no key ID")
+		}
+		return ring.secret, nil
+	}
+
+	key, ok := ring.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method != key.Method {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+
+	return key.PublicKey, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Public halves of every asymmetric key, including retired ones that still verify tokens
+func (ring *keyRing) jwks() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range ring.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+		switch public := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
+
+// JSON Web Key Set other services use to verify access tokens
+func JWKS() JWKSet {
+	return signingKeys.jwks()
+}
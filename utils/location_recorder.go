@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+)
+
+const (
+	locationQueueSize     = 1024
+	locationBatchSize     = 100
+	locationFlushInterval = 2 * time.Second
+	lastFixRetention      = 6 * time.Hour
+)
+
+// Writes accepted shuttle coordinates to shuttle_locations in batches, off the WebSocket read loop
+type LocationRecorder struct {
+	shuttleRepository repositories.ShuttleRepositoryInterface
+	queue             chan entity.ShuttleLocation
+
+	lastFixes map[uuid.UUID]entity.ShuttleLocation
+	lastMutex sync.Mutex
+}
+
+func NewLocationRecorder(shuttleRepository repositories.ShuttleRepositoryInterface) *LocationRecorder {
+	recorder := &LocationRecorder{
+		shuttleRepository: shuttleRepository,
+		queue:             make(chan entity.ShuttleLocation, locationQueueSize),
+		lastFixes:         make(map[uuid.UUID]entity.ShuttleLocation),
+	}
+	go recorder.run()
+
+	return recorder
+}
+
+// Queue a location for persistence, filling in speed and heading from the previous fix
+// when the driver's device didn't send them. Never blocks the caller.
+func (r *LocationRecorder) Record(location entity.ShuttleLocation) {
+	if location.LocationID == 0 {
+		location.LocationID = time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6)
+	}
+	if location.RecordedAt.IsZero() {
+		location.RecordedAt = time.Now()
+	}
+
+	r.lastMutex.Lock()
+	if previous, exists := r.lastFixes[location.ShuttleUUID]; exists {
+		elapsed := location.RecordedAt.Sub(previous.RecordedAt).Hours()
+		if !location.Speed.Valid && elapsed > 0 {
+			distance := HaversineDistance(previous.Latitude, previous.Longitude, location.Latitude, location.Longitude)
+			location.Speed = sql.NullFloat64{Float64: distance / elapsed, Valid: true}
+		}
+		if !location.Heading.Valid && (previous.Latitude != location.Latitude || previous.Longitude != location.Longitude) {
+			location.Heading = sql.NullFloat64{Float64: Bearing(previous.Latitude, previous.Longitude, location.Latitude, location.Longitude), Valid: true}
+		}
+	}
+	r.lastFixes[location.ShuttleUUID] = location
+	r.lastMutex.Unlock()
+
+	select {
+	case r.queue <- location:
+	default:
+		logger.LogWarn("Location queue is full, dropping location", map[string]interface{}{
+			"ShuttleUUID": location.ShuttleUUID.String(),
+		})
+	}
+}
+
+func (r *LocationRecorder) run() {
+	ticker := time.NewTicker(locationFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]entity.ShuttleLocation, 0, locationBatchSize)
+	for {
+		select {
+		case location := <-r.queue:
+			batch = append(batch, location)
+			if len(batch) >= locationBatchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+			r.pruneLastFixes()
+		}
+	}
+}
+
+func (r *LocationRecorder) flush(batch []entity.ShuttleLocation) {
+	if err := r.shuttleRepository.SaveShuttleLocations(batch); err != nil {
+		logger.LogError(err, "Failed to save shuttle locations", map[string]interface{}{
+			"count": len(batch),
+		})
+	}
+
+	latest := make(map[uuid.UUID]entity.ShuttleLocation)
+	for _, location := range batch {
+		if current, exists := latest[location.ShuttleUUID]; !exists || !location.RecordedAt.Before(current.RecordedAt) {
+			latest[location.ShuttleUUID] = location
+		}
+	}
+
+	lastLocations := make([]entity.ShuttleLocation, 0, len(latest))
+	for _, location := range latest {
+		lastLocations = append(lastLocations, location)
+	}
+
+	if err := r.shuttleRepository.UpsertShuttleLastLocations(lastLocations); err != nil {
+		logger.LogError(err, "Failed to save last shuttle locations", map[string]interface{}{
+			"count": len(lastLocations),
+		})
+	}
+}
+
+// Last known position of the shuttle. The driver may have moved on to another instance since this
+// one saw a fix, and this instance's newest fix may not be flushed yet, so the newer of the two wins.
+func (r *LocationRecorder) LastLocation(shuttleUUID uuid.UUID) (entity.ShuttleLocation, bool) {
+	r.lastMutex.Lock()
+	location, exists := r.lastFixes[shuttleUUID]
+	r.lastMutex.Unlock()
+
+	stored, err := r.shuttleRepository.FetchShuttleLastLocation(shuttleUUID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.LogError(err, "Failed to fetch last shuttle location", map[string]interface{}{
+				"ShuttleUUID": shuttleUUID.String(),
+			})
+		}
+		return location, exists
+	}
+
+	if exists && location.RecordedAt.After(stored.RecordedAt) {
+		return location, true
+	}
+	return stored, true
+}
+
+func (r *LocationRecorder) pruneLastFixes() {
+	r.lastMutex.Lock()
+	defer r.lastMutex.Unlock()
+
+	for shuttleUUID, location := range r.lastFixes {
+		if time.Since(location.RecordedAt) > lastFixRetention {
+			delete(r.lastFixes, shuttleUUID)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+)
+
+const (
+	notificationQueueSize    = 256
+	notificationWorkers      = 4
+	notificationMaxAttempts  = 4
+	notificationRetryBackoff = 2 * time.Second
+	notificationSendTimeout  = 10 * time.Second
+)
+
+type notificationJob struct {
+	userUUID uuid.UUID
+	message  PushMessage
+}
+
+// Sends push notifications in the background, retrying with backoff and moving
+// messages that still fail to notification_dead_letters
+type NotificationDispatcher struct {
+	notifier               Notifier
+	notificationRepository repositories.NotificationRepositoryInterface
+	queue                  chan notificationJob
+	retryBackoff           time.Duration
+}
+
+func NewNotificationDispatcher(notifier Notifier, notificationRepository repositories.NotificationRepositoryInterface) *NotificationDispatcher {
+	dispatcher := &NotificationDispatcher{
+		notifier:               notifier,
+		notificationRepository: notificationRepository,
+		queue:                  make(chan notificationJob, notificationQueueSize),
+		retryBackoff:           notificationRetryBackoff,
+	}
+	for i := 0; i < notificationWorkers; i++ {
+		go dispatcher.run()
+	}
+
+	return dispatcher
+}
+
+// Queue a notification for every device registered by the user. Never blocks the caller.
+func (d *NotificationDispatcher) NotifyUser(userUUID uuid.UUID, title, body string, data map[string]string) {
+	devices, err := d.notificationRepository.FetchUserDevices(userUUID)
+	if err != nil {
+		logger.LogError(err, "Failed to fetch user devices", map[string]interface{}{
+			"UserUUID": userUUID.String(),
+		})
+		return
+	}
+
+	for _, device := range devices {
+		job := notificationJob{
+			userUUID: userUUID,
+			message: PushMessage{
+				Token: device.DeviceToken,
+				Title: title,
+				Body:  body,
+				Data:  data,
+			},
+		}
+
+		select {
+		case d.queue <- job:
+		default:
+			logger.LogWarn("Notification queue is full, moving notification to dead letters", map[string]interface{}{
+				"UserUUID": userUUID.String(),
+			})
+			d.deadLetter(job, 0, errors.New("notification queue is full"))
+		}
+	}
+}
+
+func (d *NotificationDispatcher) run() {
+	for job := range d.queue {
+		d.deliver(job)
+	}
+}
+
+func (d *NotificationDispatcher) deliver(job notificationJob) {
+	var err error
+	for attempt := 1; attempt <= notificationMaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), notificationSendTimeout)
+		err = d.notifier.Send(ctx, job.message)
+		cancel()
+
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrInvalidDeviceToken) {
+			logger.LogWarn("Removing device token reported invalid by the push provider", map[string]interface{}{
+				"UserUUID": job.userUUID.String(),
+			})
+			if err := d.notificationRepository.DeleteDeviceToken(job.message.Token); err != nil {
+				logger.LogError(err, "Failed to remove invalid device token", map[string]interface{}{
+					"UserUUID": job.userUUID.String(),
+				})
+			}
+			return
+		}
+		if errors.Is(err, ErrNotificationRejected) {
+			logger.LogError(err, "Push provider rejected notification", map[string]interface{}{
+				"UserUUID": job.userUUID.String(),
+				"attempts": attempt,
+			})
+			d.deadLetter(job, attempt, err)
+			return
+		}
+		if attempt < notificationMaxAttempts {
+			time.Sleep(d.retryBackoff * time.Duration(1<<(attempt-1)))
+		}
+	}
+
+	logger.LogError(err, "Failed to send notification", map[string]interface{}{
+		"UserUUID": job.userUUID.String(),
+		"attempts": notificationMaxAttempts,
+	})
+	d.deadLetter(job, notificationMaxAttempts, err)
+}
+
+func (d *NotificationDispatcher) deadLetter(job notificationJob, attempts int, cause error) {
+	deadLetter := entity.NotificationDeadLetter{
+		DeadLetterID: time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6),
+		UserUUID:     sql.NullString{String: job.userUUID.String(), Valid: true},
+		DeviceToken:  job.message.Token,
+		Title:        job.message.Title,
+		Body:         job.message.Body,
+		Attempts:     attempts,
+		LastError:    sql.NullString{String: cause.Error(), Valid: true},
+		CreatedAt:    time.Now(),
+	}
+	if len(job.message.Data) > 0 {
+		if data, err := json.Marshal(job.message.Data); err == nil {
+			deadLetter.Data = sql.NullString{String: string(data), Valid: true}
+		}
+	}
+
+	if err := d.notificationRepository.SaveNotificationDeadLetter(deadLetter); err != nil {
+		logger.LogError(err, "Failed to save notification dead letter", map[string]interface{}{
+			"UserUUID": job.userUUID.String(),
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"shuttle/models/entity"
+	"shuttle/repositories"
+
+	"github.com/google/uuid"
+)
+
+// Keeps every message in memory instead of sending it. Errs are returned by the first sends in
+// order, every send after them succeeds.
+type FakeNotifier struct {
+	Errs []error
+
+	mutex    sync.Mutex
+	attempts int
+	sent     []PushMessage
+}
+
+func (n *FakeNotifier) Send(ctx context.Context, message PushMessage) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.attempts++
+	if len(n.Errs) > 0 {
+		err := n.Errs[0]
+		n.Errs = n.Errs[1:]
+		return err
+	}
+	n.sent = append(n.sent, message)
+
+	return nil
+}
+
+func (n *FakeNotifier) Attempts() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.attempts
+}
+
+func (n *FakeNotifier) Sent() []PushMessage {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return append([]PushMessage(nil), n.sent...)
+}
+
+// Records what the dispatcher deletes and dead-letters, other repository methods aren't used by delivery
+type fakeNotificationRepository struct {
+	repositories.NotificationRepositoryInterface
+
+	deletedTokens []string
+	deadLetters   []entity.NotificationDeadLetter
+}
+
+func (r *fakeNotificationRepository) DeleteDeviceToken(deviceToken string) error {
+	r.deletedTokens = append(r.deletedTokens, deviceToken)
+	return nil
+}
+
+func (r *fakeNotificationRepository) SaveNotificationDeadLetter(deadLetter entity.NotificationDeadLetter) error {
+	r.deadLetters = append(r.deadLetters, deadLetter)
+	return nil
+}
+
+// Workers aren't started, tests call deliver directly
+func newDeliveryTest(notifier *FakeNotifier) (*NotificationDispatcher, *fakeNotificationRepository) {
+	repository := &fakeNotificationRepository{}
+	return &NotificationDispatcher{
+		notifier:               notifier,
+		notificationRepository: repository,
+		retryBackoff:           time.Millisecond,
+	}, repository
+}
+
+func newTestJob() notificationJob {
+	return notificationJob{
+		userUUID: uuid.New(),
+		message: PushMessage{
+			Token: "device-token",
+			Title: "Shuttle update",
+			Body:  "Your child has arrived at school",
+			Data:  map[string]string{"type": "shuttle_status"},
+		},
+	}
+}
+
+func TestDeliverRetriesTransientErrors(t *testing.T) {
+	notifier := &FakeNotifier{Errs: []error{errors.New("unavailable"), errors.New("unavailable")}}
+	dispatcher, repository := newDeliveryTest(notifier)
+
+	dispatcher.deliver(newTestJob())
+
+	if attempts := notifier.Attempts(); attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+	if len(notifier.Sent()) != 1 {
+		t.Fatal("expected the notification to be sent on the last attempt")
+	}
+	if len(repository.deadLetters) != 0 || len(repository.deletedTokens) != 0 {
+		t.Fatal("expected a delivered notification to be neither dead-lettered nor pruned")
+	}
+}
+
+func TestDeliverDeadLettersAfterMaxAttempts(t *testing.T) {
+	errs := make([]error, notificationMaxAttempts)
+	for i := range errs {
+		errs[i] = errors.New("unavailable")
+	}
+	notifier := &FakeNotifier{Errs: errs}
+	dispatcher, repository := newDeliveryTest(notifier)
+	job := newTestJob()
+
+	dispatcher.deliver(job)
+
+	if attempts := notifier.Attempts(); attempts != notificationMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", notificationMaxAttempts, attempts)
+	}
+	if len(repository.deadLetters) != 1 {
+		t.Fatalf("expected one dead letter, got %d", len(repository.deadLetters))
+	}
+	deadLetter := repository.deadLetters[0]
+	if deadLetter.Attempts != notificationMaxAttempts || deadLetter.DeviceToken != job.message.Token || deadLetter.UserUUID.String != job.userUUID.String() {
+		t.Fatalf("unexpected dead letter %+v", deadLetter)
+	}
+	if deadLetter.LastError.String != "unavailable" || deadLetter.Data.String != `{"type":"shuttle_status"}` {
+		t.Fatalf("expected the dead letter to keep the error and data, got %+v", deadLetter)
+	}
+	if len(repository.deletedTokens) != 0 {
+		t.Fatal("expected the device token to be kept")
+	}
+}
+
+func TestDeliverPrunesInvalidToken(t *testing.T) {
+	notifier := &FakeNotifier{Errs: []error{ErrInvalidDeviceToken}}
+	dispatcher, repository := newDeliveryTest(notifier)
+
+	dispatcher.deliver(newTestJob())
+
+	if attempts := notifier.Attempts(); attempts != 1 {
+		t.Fatalf("expected an invalid token not to be retried, got %d attempts", attempts)
+	}
+	if len(repository.deletedTokens) != 1 || repository.deletedTokens[0] != "device-token" {
+		t.Fatalf("expected the device token to be removed, got %v", repository.deletedTokens)
+	}
+	if len(repository.deadLetters) != 0 {
+		t.Fatal("expected no dead letter for an invalid token")
+	}
+}
+
+func TestDeliverDeadLettersRejectedNotification(t *testing.T) {
+	notifier := &FakeNotifier{Errs: []error{ErrNotificationRejected}}
+	dispatcher, repository := newDeliveryTest(notifier)
+
+	dispatcher.deliver(newTestJob())
+
+	if attempts := notifier.Attempts(); attempts != 1 {
+		t.Fatalf("expected a rejected notification not to be retried, got %d attempts", attempts)
+	}
+	if len(repository.deadLetters) != 1 || repository.deadLetters[0].Attempts != 1 {
+		t.Fatalf("expected one dead letter after one attempt, got %+v", repository.deadLetters)
+	}
+	if len(repository.deletedTokens) != 0 {
+		t.Fatal("expected the device token to be kept")
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"shuttle/logger"
+
+	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/messaging"
+)
+
+type PushMessage struct {
+	Token string
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// Returned by a Notifier when the device token will never be accepted again, so retrying is pointless
+var ErrInvalidDeviceToken = errors.New("device token is invalid or no longer registered")
+
+// Returned when the provider refuses the message itself (oversized data, bad field), the token may be fine
+var ErrNotificationRejected = errors.New("notification was rejected by the push provider")
+
+type Notifier interface {
+	Send(ctx context.Context, message PushMessage) error
+}
+
+type FCMNotifier struct {
+	client *messaging.Client
+}
+
+func NewFCMNotifier(app *firebase.App) (*FCMNotifier, error) {
+	client, err := app.Messaging(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &FCMNotifier{client: client}, nil
+}
+
+func (n *FCMNotifier) Send(ctx context.Context, message PushMessage) error {
+	_, err := n.client.Send(ctx, &messaging.Message{
+		Token: message.Token,
+		Notification: &messaging.Notification{
+			Title: message.Title,
+			Body:  message.Body,
+		},
+		Data: message.Data,
+	})
+	if messaging.IsRegistrationTokenNotRegistered(err) {
+		return ErrInvalidDeviceToken
+	}
+	if messaging.IsInvalidArgument(err) {
+		return fmt.Errorf("%w: %v", ErrNotificationRejected, err)
+	}
+
+	return err
+}
+
+// Used when no push provider is configured: messages are logged and dropped, nothing is kept
+type LogNotifier struct{}
+
+func NewLogNotifier() LogNotifier {
+	return LogNotifier{}
+}
+
+func (LogNotifier) Send(ctx context.Context, message PushMessage) error {
+	logger.LogInfo("Push notifications are disabled, dropping notification", map[string]interface{}{
+		"title": message.Title,
+	})
+
+	return nil
+}
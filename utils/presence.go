@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/repositories"
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceIdle    = "idle"
+	PresenceOffline = "offline"
+
+	// Users without activity for this long become idle, and offline after presenceOfflineAfter
+	presenceIdleAfter    = 2 * time.Minute
+	presenceOfflineAfter = 10 * time.Minute
+
+	presenceFlushInterval = 15 * time.Second
+	presenceSweepInterval = 30 * time.Second
+)
+
+// Keeps users.user_status, user_last_active and user_devices.last_seen_at in line with what users
+// actually do. Activity from API calls is collected in memory and written in batches, users with an
+// open WebSocket count as active on every flush, and a sweeper demotes users that went quiet on any
+// instance.
+type presenceTracker struct {
+	mutex     sync.Mutex
+	pending   map[string]time.Time // user UUID -> last activity not written yet
+	connected map[string]int       // user UUID -> open WebSocket connections
+
+	startOnce sync.Once
+}
+
+var presence = &presenceTracker{
+	pending:   make(map[string]time.Time),
+	connected: make(map[string]int),
+}
+
+func (p *presenceTracker) start() {
+	p.startOnce.Do(func() {
+		go p.run()
+	})
+}
+
+func (p *presenceTracker) run() {
+	flushTicker := time.NewTicker(presenceFlushInterval)
+	defer flushTicker.Stop()
+	sweepTicker := time.NewTicker(presenceSweepInterval)
+	defer sweepTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			p.flush()
+		case <-sweepTicker.C:
+			p.sweep()
+		}
+	}
+}
+
+func (p *presenceTracker) flush() {
+	now := time.Now()
+
+	p.mutex.Lock()
+	batch := p.pending
+	p.pending = make(map[string]time.Time)
+	for userUUID := range p.connected {
+		batch[userUUID] = now
+	}
+	p.mutex.Unlock()
+
+	if err := repositories.UpdateUsersLastActive(*db, batch); err != nil {
+		logger.LogError(err, "Failed to update user presence", map[string]interface{}{
+			"users": len(batch),
+		})
+
+		// Keep the activity for the next flush unless something newer came in meanwhile
+		p.mutex.Lock()
+		for userUUID, at := range batch {
+			if pending, exists := p.pending[userUUID]; !exists || pending.Before(at) {
+				p.pending[userUUID] = at
+			}
+		}
+		p.mutex.Unlock()
+		return
+	}
+
+	// Losing a device refresh only makes the device look a little older, it isn't retried
+	if err := repositories.UpdateUserDevicesLastSeen(*db, batch); err != nil {
+		logger.LogError(err, "Failed to update device last seen", map[string]interface{}{
+			"users": len(batch),
+		})
+	}
+}
+
+func (p *presenceTracker) sweep() {
+	now := time.Now()
+
+	if _, err := repositories.UpdateStaleUsersStatus(*db, []string{PresenceOnline, PresenceIdle}, PresenceOffline, now.Add(-presenceOfflineAfter)); err != nil {
+		logger.LogError(err, "Failed to mark inactive users offline", nil)
+	}
+	if _, err := repositories.UpdateStaleUsersStatus(*db, []string{PresenceOnline}, PresenceIdle, now.Add(-presenceIdleAfter)); err != nil {
+		logger.LogError(err, "Failed to mark inactive users idle", nil)
+	}
+}
+
+// Start flushing and sweeping right away, so users left online by a previous run are swept too
+func StartPresenceTracker() {
+	presence.start()
+}
+
+// Record activity of a user, written to the database on the next flush
+func TouchPresence(userUUID string) {
+	presence.start()
+
+	presence.mutex.Lock()
+	presence.pending[userUUID] = time.Now()
+	presence.mutex.Unlock()
+}
+
+// A WebSocket keeps its user online for as long as it is open
+func PresenceConnected(userUUID string) {
+	presence.start()
+
+	presence.mutex.Lock()
+	presence.connected[userUUID]++
+	presence.pending[userUUID] = time.Now()
+	presence.mutex.Unlock()
+}
+
+// Closing the user's last WebSocket makes them idle right away instead of waiting for the sweeper,
+// API calls put them back online on the next flush
+func PresenceDisconnected(userUUID string) {
+	presence.mutex.Lock()
+	presence.connected[userUUID]--
+	if presence.connected[userUUID] > 0 {
+		presence.mutex.Unlock()
+		return
+	}
+	delete(presence.connected, userUUID)
+	// Activity queued while connected would turn the user back online on the next flush
+	delete(presence.pending, userUUID)
+	presence.mutex.Unlock()
+
+	if err := repositories.UpdateUserStatusFrom(*db, userUUID, []string{PresenceOnline}, PresenceIdle, time.Now()); err != nil {
+		logger.LogError(err, "Failed to mark disconnected user idle", map[string]interface{}{
+			"UserUUID": userUUID,
+		})
+	}
+}
+
+// Drop activity that wasn't written yet, so a logout isn't overwritten by the next flush
+func ForgetPresence(userUUID string) {
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+
+	delete(presence.pending, userUUID)
+}
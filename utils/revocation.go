@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"shuttle/logger"
+	"shuttle/models/entity"
+	"shuttle/repositories"
+)
+
+const (
+	revocationSyncInterval = 30 * time.Second
+	// Revocations committed by another instance just before a sync may become visible slightly late
+	revocationSyncOverlap = 5 * time.Second
+)
+
+// Revoked token IDs, persisted in revoked_tokens and cached in memory. Every instance
+// pulls revocations made elsewhere on a short interval, so a revoked token stops
+// working everywhere within revocationSyncInterval and immediately on the instance that revoked it.
+type revocationStore struct {
+	mutex    sync.RWMutex
+	tokens   map[string]time.Time // jti -> token expiry
+	lastSync time.Time
+
+	startOnce sync.Once
+}
+
+var revokedTokens = &revocationStore{tokens: make(map[string]time.Time)}
+
+func (s *revocationStore) start() {
+	s.startOnce.Do(func() {
+		s.sync()
+		go s.run()
+	})
+}
+
+func (s *revocationStore) run() {
+	ticker := time.NewTicker(revocationSyncInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.sync()
+		s.cleanup()
+	}
+}
+
+func (s *revocationStore) IsRevoked(jti string) bool {
+	s.start()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, exists := s.tokens[jti]
+	return exists
+}
+
+func (s *revocationStore) Revoke(jti, userUUID string, expiresAt time.Time) error {
+	s.start()
+
+	err := repositories.SaveRevokedToken(*db, entity.RevokedToken{
+		JTI:       jti,
+		UserUUID:  sql.NullString{String: userUUID, Valid: userUUID != ""},
+		ExpiredAt: expiresAt,
+		RevokedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.tokens[jti] = expiresAt
+	s.mutex.Unlock()
+
+	return nil
+}
+
+func (s *revocationStore) sync() {
+	s.mutex.RLock()
+	since := s.lastSync
+	s.mutex.RUnlock()
+
+	startedAt := time.Now()
+	if !since.IsZero() {
+		since = since.Add(-revocationSyncOverlap)
+	}
+
+	tokens, err := repositories.FetchRevokedTokensSince(*db, since)
+	if err != nil {
+		logger.LogError(err, "Failed to sync revoked tokens", nil)
+		return
+	}
+
+	s.mutex.Lock()
+	for _, token := range tokens {
+		s.tokens[token.JTI] = token.ExpiredAt
+	}
+	s.lastSync = startedAt
+	s.mutex.Unlock()
+}
+
+// Expired tokens are rejected on their own, so their revocations can be forgotten
+func (s *revocationStore) cleanup() {
+	now := time.Now()
+
+	s.mutex.Lock()
+	for jti, expiresAt := range s.tokens {
+		if !expiresAt.After(now) {
+			delete(s.tokens, jti)
+		}
+	}
+	s.mutex.Unlock()
+
+	if _, err := repositories.DeleteExpiredRevokedTokens(*db); err != nil {
+		logger.LogError(err, "Failed to delete expired revoked tokens", nil)
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"shuttle/logger"
+)
+
+// Writes text messages to the application log instead of sending them, for local development
+type ConsoleSMSSender struct{}
+
+func NewConsoleSMSSender() *ConsoleSMSSender {
+	return &ConsoleSMSSender{}
+}
+
+func (s *ConsoleSMSSender) Send(phone, message string) error {
+	logger.LogInfo("SMS", map[string]interface{}{
+		"phone":   phone,
+		"message": message,
+	})
+	return nil
+}
+
+// Sends text messages through Twilio's Messages API
+type TwilioSMSSender struct {
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+func NewTwilioSMSSender(accountSID, authToken, from string) *TwilioSMSSender {
+	return &TwilioSMSSender{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *TwilioSMSSender) Send(phone, message string) error {
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", url.PathEscape(s.accountSID))
+	form := url.Values{"To": {phone}, "From": {s.from}, "Body": {message}}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.accountSID, s.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("twilio responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+type SMSMessage struct {
+	Phone   string
+	Message string
+}
+
+// Keeps every text message in memory, for tests. Set Err to make every send fail.
+type FakeSMSSender struct {
+	Err error
+
+	mutex sync.Mutex
+	sent  []SMSMessage
+}
+
+func NewFakeSMSSender() *FakeSMSSender {
+	return &FakeSMSSender{}
+}
+
+func (s *FakeSMSSender) Send(phone, message string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Err != nil {
+		return s.Err
+	}
+	s.sent = append(s.sent, SMSMessage{Phone: phone, Message: message})
+
+	return nil
+}
+
+func (s *FakeSMSSender) Sent() []SMSMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]SMSMessage(nil), s.sent...)
+}
@@ -4,13 +4,16 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"errors"
 	"io"
+	"strings"
 	"time"
 
 	"shuttle/databases"
 	"shuttle/logger"
+	"shuttle/models/dto"
 	"shuttle/models/entity"
 	"shuttle/repositories"
 
@@ -20,19 +23,32 @@ import (
 	"github.com/spf13/viper"
 )
 
-var jwtSecret []byte
+var signingKeys *keyRing
 var encryptionKey []byte
+var previousEncryptionKeys [][]byte
+var encryptTokens bool
 var db *sqlx.DB
 
-func init() {
-	viper.SetConfigFile(".env")
-	err := viper.ReadInConfig()
+// Loads the signing and encryption keys and connects the token store, called once from main
+// before the server starts; the settings themselves are read when the databases package loads
+func InitTokens() {
+	var err error
+	signingKeys, err = loadKeyRing([]byte(viper.GetString("JWT_SECRET")), viper.GetString("JWT_KEYS_DIR"), viper.GetString("JWT_SIGNING_KEY_ID"))
 	if err != nil {
 		panic(err)
 	}
 
-	jwtSecret = []byte(viper.GetString("JWT_SECRET"))
+	// Old keys only decrypt, so tokens encrypted before a rotation stay valid until they expire
 	encryptionKey = []byte(viper.GetString("ENCRYPTION_KEY"))
+	for _, key := range strings.Split(viper.GetString("PREVIOUS_ENCRYPTION_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			previousEncryptionKeys = append(previousEncryptionKeys, []byte(key))
+		}
+	}
+
+	// Tokens verified by other services through the JWKS endpoint can't be encrypted
+	viper.SetDefault("TOKEN_ENCRYPTION", true)
+	encryptTokens = viper.GetBool("TOKEN_ENCRYPTION")
 
 	db, err = databases.PostgresConnection()
 	if err != nil {
@@ -40,17 +56,19 @@ func init() {
 	}
 }
 
-// Signed Access Token
-func GenerateToken(userID, userUUID, username, role_code string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+const AccessTokenTTL = time.Hour * 6
+
+// Signed Access Token, sessionID ties it to the refresh token family it was issued for
+func GenerateToken(userID, userUUID, username, role_code, sessionID string) (string, error) {
+	signedToken, err := signingKeys.sign(jwt.MapClaims{
 		"sub":       userID,
 		"user_uuid": userUUID,
 		"user_name": username,
 		"role_code": role_code,
-		"exp":       time.Now().Add(time.Hour * 6).Unix(), // 2 hours expiration
+		"sid":       sessionID,
+		"jti":       uuid.NewString(),
+		"exp":       time.Now().Add(AccessTokenTTL).Unix(), // 6 hours expiration
 	})
-
-	signedToken, err := token.SignedString(jwtSecret)
 	if err != nil {
 		return "", err
 	}
@@ -63,18 +81,44 @@ func GenerateToken(userID, userUUID, username, role_code string) (string, error)
 	return encryptedToken, nil
 }
 
+const ImpersonationTokenTTL = time.Minute * 15
+
+// Access token for acting as another user. The "act" claim names the super admin behind it,
+// there is no refresh token so the impersonation ends when it expires.
+func GenerateImpersonationToken(userID, userUUID, username, role_code, actorUUID, actorName string) (string, error) {
+	signedToken, err := signingKeys.sign(jwt.MapClaims{
+		"sub":       userID,
+		"user_uuid": userUUID,
+		"user_name": username,
+		"role_code": role_code,
+		"sid":       uuid.NewString(),
+		"jti":       uuid.NewString(),
+		"act": map[string]interface{}{
+			"sub":  actorUUID,
+			"name": actorName,
+		},
+		"exp": time.Now().Add(ImpersonationTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return encryptToken(signedToken)
+}
+
+const RefreshTokenTTL = time.Hour * 24 * 15
+
 // Same, but with 15 days expiration time and for reissuing access token
 func GenerateRefreshToken(userID, userUUID, username, role_code string) (string, error) {
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	signedRefreshToken, err := signingKeys.sign(jwt.MapClaims{
 		"sub":       userID,
 		"user_uuid": userUUID,
 		"user_name": username,
 		"role_code": role_code,
-		"exp":       time.Now().Add(time.Hour * 24 * 15).Unix(), // 15 days expiration
+		"jti":       uuid.NewString(),
+		"exp":       time.Now().Add(RefreshTokenTTL).Unix(), // 15 days expiration
 	})
-
-	signedRefreshToken, err := refreshToken.SignedString(jwtSecret)
 	if err != nil {
 		return "", err
 	}
@@ -89,6 +133,10 @@ func GenerateRefreshToken(userID, userUUID, username, role_code string) (string,
 
 // AES encryption for tokens
 func encryptToken(token string) (string, error) {
+	if !encryptTokens {
+		return token, nil
+	}
+
 	block, err := aes.NewCipher(encryptionKey)
 	if err != nil {
 		return "", err
@@ -108,13 +156,31 @@ func encryptToken(token string) (string, error) {
 	return base64.URLEncoding.EncodeToString(encryptedToken), nil
 }
 
+// Plain signed tokens are passed through, so switching TOKEN_ENCRYPTION either way keeps
+// issued tokens valid. Encrypted tokens are tried with the current key first, then the previous ones.
 func decryptToken(encryptedToken string) (string, error) {
+	if strings.Count(encryptedToken, ".") == 2 {
+		return encryptedToken, nil
+	}
+
 	encryptedBytes, err := base64.URLEncoding.DecodeString(encryptedToken)
 	if err != nil {
 		return "", err
 	}
 
-	block, err := aes.NewCipher(encryptionKey)
+	decryptedToken, err := decryptWithKey(encryptedBytes, encryptionKey)
+	for _, key := range previousEncryptionKeys {
+		if err == nil {
+			break
+		}
+		decryptedToken, err = decryptWithKey(encryptedBytes, key)
+	}
+
+	return decryptedToken, err
+}
+
+func decryptWithKey(encryptedBytes, key []byte) (string, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -144,9 +210,10 @@ func ValidateToken(encryptedToken string) (jwt.MapClaims, error) {
 		return nil, err
 	}
 
-	token, err := jwt.Parse(decryptedToken, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.Parse(decryptedToken, signingKeys.keyFunc)
+	if token == nil {
+		return nil, err
+	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		return claims, nil
@@ -154,9 +221,9 @@ func ValidateToken(encryptedToken string) (jwt.MapClaims, error) {
 	return nil, err
 }
 
-func SaveRefreshToken(userUUID string, refreshToken string) error {
+func SaveRefreshToken(userUUID string, sessionUUID uuid.UUID, refreshToken string, device dto.SessionDevice) error {
 	ID := time.Now().UnixMilli()*1e6 + int64(uuid.New().ID()%1e6)
-	expiration := time.Now().Add(time.Hour * 24 * 15)
+	expiration := time.Now().Add(RefreshTokenTTL)
 
 	parsedUUID, parseErr := uuid.Parse(userUUID)
 	if parseErr != nil {
@@ -164,10 +231,15 @@ func SaveRefreshToken(userUUID string, refreshToken string) error {
 	}
 
 	err := repositories.SaveRefreshToken(*db, entity.RefreshToken{
-		ID:           ID,
-		UserUUID:     parsedUUID,
-		RefreshToken: refreshToken,
-		ExpiredAt:    expiration,
+		ID:               ID,
+		UserUUID:         parsedUUID,
+		FamilyUUID:       sessionUUID,
+		RefreshToken:     refreshToken,
+		ExpiredAt:        expiration,
+		DeviceName:       sql.NullString{String: device.DeviceName, Valid: device.DeviceName != ""},
+		IPAddress:        sql.NullString{String: device.IPAddress, Valid: device.IPAddress != ""},
+		UserAgent:        sql.NullString{String: device.UserAgent, Valid: device.UserAgent != ""},
+		SessionCreatedAt: time.Now(),
 	})
 	if err != nil {
 		logger.LogError(err, "Failed to save refresh token", map[string]interface{}{
@@ -179,12 +251,114 @@ func SaveRefreshToken(userUUID string, refreshToken string) error {
 	return nil
 }
 
-var InvalidTokens = make(map[string]struct{})
+// Revoke an access token (with or without the "Bearer " prefix) on every instance until it expires
+func InvalidateToken(token string) error {
+	claims, err := ValidateToken(StripBearerPrefix(token))
+	if err != nil {
+		return err
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return errors.New("token ID is missing or invalid")
+	}
+	userUUID, _ := claims["user_uuid"].(string)
+
+	return revokedTokens.Revoke(jti, userUUID, claimExpiry(claims))
+}
+
+// Revoke every access token issued for a session. They all expire within AccessTokenTTL,
+// so the revocation only needs to live that long.
+func RevokeSession(sessionID, userUUID string) error {
+	return revokedTokens.Revoke(sessionRevocationKey(sessionID), userUUID, time.Now().Add(AccessTokenTTL))
+}
 
-func InvalidateToken(token string) {
+// RevokeSession for the services that get it injected
+type SessionTokenRevoker struct{}
+
+func (SessionTokenRevoker) RevokeSession(sessionID, userUUID string) error {
+	return RevokeSession(sessionID, userUUID)
+}
+
+func sessionRevocationKey(sessionID string) string {
+	return "sid:" + sessionID
+}
+
+func claimExpiry(claims jwt.MapClaims) time.Time {
+	if exp, ok := claims["exp"].(float64); ok {
+		return time.Unix(int64(exp), 0)
+	}
+	// Without an expiry the token is valid forever, keep the revocation for as long as a refresh token lives
+	return time.Now().Add(RefreshTokenTTL)
+}
+
+func StripBearerPrefix(token string) string {
 	const bearerPrefix = "Bearer "
 	if len(token) > len(bearerPrefix) && token[:len(bearerPrefix)] == bearerPrefix {
-		token = token[len(bearerPrefix):]
+		return token[len(bearerPrefix):]
+	}
+	return token
+}
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Claims every authenticated request relies on
+type AccessClaims struct {
+	UserID    string
+	UserUUID  string
+	RoleCode  string
+	Username  string
+	TokenID   string
+	SessionID string
+	// Set when a super admin is impersonating the user
+	ActorUUID string
+	ActorName string
+}
+
+// Validate an access token (with or without the "Bearer " prefix) and extract its claims,
+// shared by the HTTP middleware and the WebSocket upgrade so both accept the same tokens
+func ParseAccessToken(token string) (AccessClaims, error) {
+	claims, err := ValidateToken(StripBearerPrefix(token))
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	if claims == nil {
+		return AccessClaims{}, errors.New("token is invalid")
+	}
+
+	var accessClaims AccessClaims
+	var ok bool
+
+	if accessClaims.UserID, ok = claims["sub"].(string); !ok || accessClaims.UserID == "" {
+		return AccessClaims{}, errors.New("user ID is missing or invalid")
+	}
+	if accessClaims.UserUUID, ok = claims["user_uuid"].(string); !ok || accessClaims.UserUUID == "" {
+		return AccessClaims{}, errors.New("user UUID is missing or invalid")
+	}
+	if accessClaims.RoleCode, ok = claims["role_code"].(string); !ok || accessClaims.RoleCode == "" {
+		return AccessClaims{}, errors.New("role code is missing or invalid")
+	}
+	if accessClaims.Username, ok = claims["user_name"].(string); !ok || accessClaims.Username == "" {
+		return AccessClaims{}, errors.New("user name is missing or invalid")
 	}
-	InvalidTokens[token] = struct{}{}
+	if accessClaims.TokenID, ok = claims["jti"].(string); !ok || accessClaims.TokenID == "" {
+		return AccessClaims{}, errors.New("token ID is missing or invalid")
+	}
+
+	if accessClaims.SessionID, ok = claims["sid"].(string); !ok || accessClaims.SessionID == "" {
+		return AccessClaims{}, errors.New("session ID is missing or invalid")
+	}
+
+	if actor, ok := claims["act"].(map[string]interface{}); ok {
+		if accessClaims.ActorUUID, ok = actor["sub"].(string); !ok || accessClaims.ActorUUID == "" {
+			return AccessClaims{}, errors.New("actor is missing or invalid")
+		}
+		accessClaims.ActorName, _ = actor["name"].(string)
+	}
+
+	if revokedTokens.IsRevoked(accessClaims.TokenID) || revokedTokens.IsRevoked(sessionRevocationKey(accessClaims.SessionID)) {
+		return AccessClaims{}, ErrTokenRevoked
+	}
+
+	return accessClaims, nil
 }
@@ -32,12 +32,26 @@ func CustomGenderValidator(fl validator.FieldLevel) bool {
 	return regexp.MustCompile(genderRegex).MatchString(value)
 }
 
+// At least 8 characters with an uppercase letter, a lowercase letter and a digit.
+// bcrypt ignores everything after 72 bytes, so longer passwords are rejected.
+func CustomPasswordValidator(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	if len(value) < 8 || len(value) > 72 {
+		return false
+	}
+
+	return regexp.MustCompile(`[A-Z]`).MatchString(value) &&
+		regexp.MustCompile(`[a-z]`).MatchString(value) &&
+		regexp.MustCompile(`[0-9]`).MatchString(value)
+}
+
 func ValidateStruct(c *fiber.Ctx, v interface{}) error {
 	validate := validator.New()
 	validate.RegisterValidation("phone", CustomPhoneValidator)
 	validate.RegisterValidation("username", CustomUsernameValidator)
 	validate.RegisterValidation("role", CustomRoleValidator)
 	validate.RegisterValidation("gender", CustomGenderValidator)
+	validate.RegisterValidation("password", CustomPasswordValidator)
 
 	if err := validate.Struct(v); err != nil {
 		for _, err := range err.(validator.ValidationErrors) {
@@ -58,6 +72,12 @@ func ValidateStruct(c *fiber.Ctx, v interface{}) error {
 				return fmt.Errorf("the %s field must be at most %s characters", err.Field(), err.Param())
 			case "role":
 				return fmt.Errorf("the %s field must be either superadmin, schooladmin, driver, or parent", err.Field())
+			case "password":
+				return fmt.Errorf("the %s field must be 8 to 72 characters and contain an uppercase letter, a lowercase letter, and a number", err.Field())
+			case "nefield":
+				return fmt.Errorf("the %s field must be different from the %s field", err.Field(), err.Param())
+			default:
+				return fmt.Errorf("the %s field is invalid", err.Field())
 			}
 		}
 	}
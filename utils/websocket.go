@@ -1,32 +1,70 @@
 package utils
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"strings"
 	"sync"
-	// "time"
+	"time"
 
+	customErrors "shuttle/errors"
 	"shuttle/logger"
+	"shuttle/models/dto"
+	"shuttle/models/entity"
 	"shuttle/repositories"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
 )
 
 type WebSocketServiceInterface interface {
 	HandleWebSocketConnection(c *websocket.Conn)
 }
 
+// Status frames from the driver go through the same transition rules, history and parent push
+// as the REST endpoint, implemented by services.ShuttleService
+type ShuttleStatusRecorder interface {
+	EditShuttleStatus(shuttleUUID string, req dto.ShuttleStatusRequest, driverUUID, changedBy string) error
+	RecordStudentEvent(shuttleUUID, event string, req dto.ShuttleStudentEventRequest, driverUUID, changedBy string) error
+}
+
 type WebSocketService struct {
-	userRepository repositories.UserRepositoryInterface
-	authRepository repositories.AuthRepositoryInterface
+	userRepository    repositories.UserRepositoryInterface
+	authRepository    repositories.AuthRepositoryInterface
+	shuttleRepository repositories.ShuttleRepositoryInterface
+	locationRecorder  *LocationRecorder
+	etaEstimator      *ETAEstimator
+	statusRecorder    ShuttleStatusRecorder
 }
 
-func NewWebSocketService(userRepository repositories.UserRepositoryInterface, authRepository repositories.AuthRepositoryInterface) WebSocketServiceInterface {
+func NewWebSocketService(userRepository repositories.UserRepositoryInterface, authRepository repositories.AuthRepositoryInterface, shuttleRepository repositories.ShuttleRepositoryInterface, locationRecorder *LocationRecorder, etaEstimator *ETAEstimator, statusRecorder ShuttleStatusRecorder) WebSocketServiceInterface {
 	return &WebSocketService{
-		userRepository: userRepository,
-		authRepository: authRepository,
+		userRepository:    userRepository,
+		authRepository:    authRepository,
+		shuttleRepository: shuttleRepository,
+		locationRecorder:  locationRecorder,
+		etaEstimator:      etaEstimator,
+		statusRecorder:    statusRecorder,
 	}
 }
 
+// Browsers can't set headers on the upgrade request, so they may send the access token as
+// the second entry of "Sec-WebSocket-Protocol: access_token, <token>"
+const WebSocketTokenSubprotocol = "access_token"
+
+// Application close codes (4000-4999), mirroring the HTTP status they stand for
+const (
+	CloseUnauthorized = 4401
+	CloseForbidden    = 4403
+	CloseNotFound     = 4404
+)
+
+const (
+	ShuttleRolePublisher  = "publisher"
+	ShuttleRoleSubscriber = "subscriber"
+)
+
 var (
 	activeConnections = make(map[string]*websocket.Conn) // Save active WebSocket connections
 	mutex             = &sync.Mutex{}                    // Ensure atomic operations
@@ -52,111 +90,267 @@ func GetConnection(ID string) (*websocket.Conn, bool) {
 }
 
 // Handle WebSocket connection
-var (
-	shuttleGroups = make(map[string]map[string]*websocket.Conn) // Save active WebSocket connections
-	groupMutex    = &sync.Mutex{}                               // Ensure atomic operations
-)
+var shuttleBroker Broker = NewInMemoryBroker()
 
-func AddToShuttleGroup(shuttleUUID, userUUID string, conn *websocket.Conn) {
-	groupMutex.Lock()
-	defer groupMutex.Unlock()
+// Replace the shuttle group broker, call before the server starts accepting connections
+func SetShuttleBroker(broker Broker) {
+	shuttleBroker = broker
+}
+
+func AddToShuttleGroup(shuttleUUID string, client *Client) {
+	shuttleBroker.Join(shuttleUUID, client)
+}
+
+func RemoveFromShuttleGroup(shuttleUUID string, client *Client) {
+	shuttleBroker.Leave(shuttleUUID, client)
+}
 
-	if _, exists := shuttleGroups[shuttleUUID]; !exists {
-		shuttleGroups[shuttleUUID] = make(map[string]*websocket.Conn)
+func BroadcastToShuttleGroup(shuttleUUID string, message []byte) {
+	if err := shuttleBroker.Publish(shuttleUUID, message); err != nil {
+		logger.LogError(err, "WebSocket Broadcast Error", map[string]interface{}{"ShuttleUUID": shuttleUUID})
 	}
-	shuttleGroups[shuttleUUID][userUUID] = conn
 }
 
-func RemoveFromShuttleGroup(shuttleUUID, userUUID string) {
-	groupMutex.Lock()
-	defer groupMutex.Unlock()
+func extractWebSocketToken(c *websocket.Conn) string {
+	if token := c.Headers("Authorization"); token != "" {
+		return token
+	}
 
-	if group, exists := shuttleGroups[shuttleUUID]; exists {
-		delete(group, userUUID)
-		if len(group) == 0 {
-			delete(shuttleGroups, shuttleUUID)
+	for _, protocol := range strings.Split(c.Headers("Sec-WebSocket-Protocol"), ",") {
+		protocol = strings.TrimSpace(protocol)
+		if protocol != "" && protocol != WebSocketTokenSubprotocol {
+			return protocol
 		}
 	}
+
+	return c.Query("token")
 }
 
-func BroadcastToShuttleGroup(shuttleUUID string, message []byte) {
-	groupMutex.Lock()
-	defer groupMutex.Unlock()
+func closeWebSocket(c *websocket.Conn, code int, reason string) {
+	c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.Close()
+}
 
-	if group, exists := shuttleGroups[shuttleUUID]; exists {
-		for _, conn := range group {
-			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				logger.LogError(err, "WebSocket Broadcast Error", nil)
-			}
+// Decide whether the user may join the shuttle group and in which role: only the shuttle's
+// driver publishes, the same rule status changes are checked against, the student's parent and
+// the school's admin only listen
+func (s *WebSocketService) resolveShuttleRole(claims AccessClaims, access entity.ShuttleAccess) (string, bool) {
+	switch claims.RoleCode {
+	case "D":
+		if access.DriverUUID.String() == claims.UserUUID {
+			return ShuttleRolePublisher, true
+		}
+	case "P":
+		if access.ParentUUID.Valid && access.ParentUUID.String == claims.UserUUID {
+			return ShuttleRoleSubscriber, true
+		}
+	case "AS":
+		schoolUUID, err := s.userRepository.FetchPermittedSchoolAccess(claims.UserUUID)
+		if err == nil && access.SchoolUUID.Valid && access.SchoolUUID.String == schoolUUID {
+			return ShuttleRoleSubscriber, true
 		}
 	}
+
+	return "", false
 }
 
 func (s *WebSocketService) HandleWebSocketConnection(c *websocket.Conn) {
-	userUUID := c.Params("id")
+	token := extractWebSocketToken(c)
+	if token == "" {
+		closeWebSocket(c, CloseUnauthorized, "Missing token")
+		return
+	}
+
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		logger.LogWarn("Invalid WebSocket token", map[string]interface{}{"error": err.Error()})
+		closeWebSocket(c, CloseUnauthorized, "Token is invalid")
+		return
+	}
+
+	userUUID := claims.UserUUID
+	if pathUUID := c.Params("id"); pathUUID != "" && pathUUID != userUUID {
+		closeWebSocket(c, CloseForbidden, "Token does not belong to this user")
+		return
+	}
+
 	shuttleUUID := c.Query("shuttle_uuid")
+	parsedShuttleUUID, err := uuid.Parse(shuttleUUID)
+	if err != nil {
+		closeWebSocket(c, websocket.ClosePolicyViolation, "Invalid or missing shuttle_uuid")
+		return
+	}
+
+	access, err := s.shuttleRepository.FetchShuttleAccess(parsedShuttleUUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			closeWebSocket(c, CloseNotFound, "Shuttle not found")
+			return
+		}
+		logger.LogError(err, "Failed to fetch shuttle access", map[string]interface{}{"ShuttleUUID": shuttleUUID})
+		closeWebSocket(c, websocket.CloseInternalServerErr, "Something went wrong, please try again later")
+		return
+	}
 
-	// Validate user access to shuttle group
-	// if !s.userRepository.HasAccessToShuttle(userUUID, shuttleUUID) {
-	// 	c.WriteMessage(websocket.TextMessage, []byte("Unauthorized access to shuttle group"))
-	// 	c.Close()
-	// 	return
-	// }
+	role, ok := s.resolveShuttleRole(claims, access)
+	if !ok {
+		logger.LogWarn("Unauthorized access to shuttle group", map[string]interface{}{"ShuttleUUID": shuttleUUID, "UserUUID": userUUID})
+		closeWebSocket(c, CloseForbidden, "Unauthorized access to shuttle group")
+		return
+	}
 
-	AddToShuttleGroup(shuttleUUID, userUUID, c)
+	// Socket frames bypass the impersonation audit, so an impersonator may not write through them
+	if role == ShuttleRolePublisher && claims.ActorUUID != "" {
+		logger.LogWarn("Impersonated publish to shuttle group refused", map[string]interface{}{"ShuttleUUID": shuttleUUID, "UserUUID": userUUID, "ActorUUID": claims.ActorUUID})
+		closeWebSocket(c, CloseForbidden, "Impersonated sessions can't publish to a shuttle group")
+		return
+	}
+
+	client := NewClient(userUUID, c)
+	AddConnection(userUUID, c)
+	AddToShuttleGroup(shuttleUUID, client)
+	if claims.ActorUUID == "" {
+		PresenceConnected(userUUID)
+	}
 	defer func() {
-		RemoveFromShuttleGroup(shuttleUUID, userUUID)
+		if claims.ActorUUID == "" {
+			PresenceDisconnected(userUUID)
+		}
+		RemoveFromShuttleGroup(shuttleUUID, client)
+		RemoveConnection(userUUID)
+		client.Close()
+		client.Wait()
 		logger.LogInfo("WebSocket Connection Removed from Group", map[string]interface{}{"ShuttleUUID": shuttleUUID, "UserUUID": userUUID})
 	}()
 
-	logger.LogInfo("WebSocket Connection Added to Group", map[string]interface{}{"ShuttleUUID": shuttleUUID, "UserUUID": userUUID})
-	c.WriteMessage(websocket.TextMessage, []byte("Connected to shuttle group"))
+	// A peer that stops answering pings is dropped once the read deadline passes
+	c.SetReadLimit(maxMessageSize)
+	c.SetReadDeadline(time.Now().Add(pongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	logger.LogInfo("WebSocket Connection Added to Group", map[string]interface{}{"ShuttleUUID": shuttleUUID, "UserUUID": userUUID, "Role": role})
+	if connectedMsg, err := NewEnvelope(MessageTypeConnected, shuttleUUID, ConnectedPayload{Role: role}); err == nil {
+		client.Send(connectedMsg)
+	}
+
+	// Late joiners get the last known position right away instead of waiting for the driver's next ping
+	if lastLocation, exists := s.locationRecorder.LastLocation(parsedShuttleUUID); exists {
+		snapshot := LocationPayload{
+			Latitude:   lastLocation.Latitude,
+			Longitude:  lastLocation.Longitude,
+			RecordedAt: lastLocation.RecordedAt.Format(time.RFC3339),
+		}
+		if lastLocation.Speed.Valid {
+			snapshot.Speed = &lastLocation.Speed.Float64
+		}
+		if lastLocation.Heading.Valid {
+			snapshot.Heading = &lastLocation.Heading.Float64
+		}
+		if snapshotMsg, err := NewEnvelope(MessageTypeLocation, shuttleUUID, snapshot); err == nil {
+			client.Send(snapshotMsg)
+		}
+	}
 
 	for {
-		mt, msg, err := c.ReadMessage()
+		_, msg, err := c.ReadMessage()
 		if err != nil {
 			logger.LogError(err, "WebSocket Error Reading Message", nil)
 			break
 		}
 
-		var data struct {
-			Longitude float64 `json:"longitude"`
-			Latitude  float64 `json:"latitude"`
+		if role != ShuttleRolePublisher {
+			client.Send(NewErrorEnvelope(shuttleUUID, 403, "Only the assigned driver can publish to this shuttle group"))
+			continue
+		}
+
+		envelope, err := ParseDriverEnvelope(msg, shuttleUUID)
+		if err != nil {
+			client.Send(NewErrorEnvelope(shuttleUUID, 400, err.Error()))
+			continue
 		}
 
-		if err := json.Unmarshal(msg, &data); err != nil || data.Longitude == 0 || data.Latitude == 0 {
-			errorResponse := struct {
-				Code    int    `json:"code"`
-				Status  string `json:"status"`
-				Message string `json:"message"`
-			}{
-				Code:    400,
-				Status:  "Bad Request",
-				Message: "Invalid message format. Must contain 'longitude' and 'latitude'.",
+		if err := s.handleDriverEnvelope(envelope, parsedShuttleUUID, userUUID, claims.Username); err != nil {
+			code := 500
+			message := "Something went wrong, please try again later"
+			if customErr, ok := err.(*customErrors.CustomError); ok {
+				code, message = customErr.StatusCode, customErr.Message
+			} else if errors.Is(err, sql.ErrNoRows) {
+				code, message = 404, "Shuttle not found"
+			} else {
+				logger.LogError(err, "Failed to handle driver message", map[string]interface{}{"ShuttleUUID": shuttleUUID, "Type": envelope.Type})
 			}
-			responseMsg, _ := json.Marshal(errorResponse)
-			c.WriteMessage(websocket.TextMessage, responseMsg)
+			client.Send(NewErrorEnvelope(shuttleUUID, code, message))
 			continue
 		}
 
-		logger.LogInfo("Broadcasting Message", map[string]interface{}{
-			"ShuttleUUID": shuttleUUID,
-			"UserUUID":    userUUID,
-			"Longitude":   data.Longitude,
-			"Latitude":    data.Latitude,
-		})
-		BroadcastToShuttleGroup(shuttleUUID, msg)
+		if ackMsg, err := NewEnvelope(MessageTypeAck, shuttleUUID, AckPayload{Type: envelope.Type}); err == nil {
+			client.Send(ackMsg)
+		}
+	}
+}
+
+// Status frames are only passed on once they have been stored, locations are recorded off the read loop
+func (s *WebSocketService) handleDriverEnvelope(envelope WSEnvelope, shuttleUUID uuid.UUID, driverUUID, driverName string) error {
+	var location *entity.ShuttleLocation
+	switch envelope.Type {
+	case MessageTypeStatusChange:
+		var payload StatusChangePayload
+		json.Unmarshal(envelope.Payload, &payload)
+
+		if err := s.statusRecorder.EditShuttleStatus(shuttleUUID.String(), dto.ShuttleStatusRequest{Status: payload.Status}, driverUUID, driverName); err != nil {
+			return err
+		}
+	case MessageTypeStudentPickedUp, MessageTypeStudentDroppedOff:
+		var payload StudentEventPayload
+		json.Unmarshal(envelope.Payload, &payload)
 
-		response := struct {
-			Code    int    `json:"code"`
-			Status  string `json:"status"`
-			Message string `json:"message"`
-		}{
-			Code:    200,
-			Status:  "OK",
-			Message: "Message broadcasted to shuttle group",
+		req := dto.ShuttleStudentEventRequest{
+			StudentUUID: payload.StudentUUID,
+			Latitude:    payload.Latitude,
+			Longitude:   payload.Longitude,
+		}
+		if err := s.statusRecorder.RecordStudentEvent(shuttleUUID.String(), envelope.Type, req, driverUUID, driverName); err != nil {
+			return err
+		}
+	case MessageTypeLocation:
+		var payload LocationPayload
+		json.Unmarshal(envelope.Payload, &payload)
+
+		location = &entity.ShuttleLocation{
+			ShuttleUUID: shuttleUUID,
+			DriverUUID:  sql.NullString{String: driverUUID, Valid: true},
+			Latitude:    payload.Latitude,
+			Longitude:   payload.Longitude,
+			RecordedAt:  envelope.Timestamp,
+		}
+		if payload.Speed != nil {
+			location.Speed = sql.NullFloat64{Float64: *payload.Speed, Valid: true}
 		}
-		responseMsg, _ := json.Marshal(response)
-		c.WriteMessage(mt, responseMsg)
+		if payload.Heading != nil {
+			location.Heading = sql.NullFloat64{Float64: *payload.Heading, Valid: true}
+		}
+		s.locationRecorder.Record(*location)
+	}
+
+	message, err := json.Marshal(envelope)
+	if err != nil {
+		logger.LogError(err, "Failed to encode WebSocket message", nil)
+		return nil
+	}
+
+	logger.LogInfo("Broadcasting Message", map[string]interface{}{
+		"ShuttleUUID": envelope.ShuttleUUID,
+		"UserUUID":    driverUUID,
+		"Type":        envelope.Type,
+	})
+	BroadcastToShuttleGroup(envelope.ShuttleUUID, message)
+
+	// Estimates go out after the position itself so subscribers can draw both at once
+	if location != nil {
+		s.etaEstimator.Publish(*location)
 	}
-}
\ No newline at end of file
+
+	return nil
+}
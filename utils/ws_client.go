@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+const (
+	clientSendQueueSize = 64
+	writeWait           = 10 * time.Second
+	pongWait            = 60 * time.Second
+	pingPeriod          = (pongWait * 9) / 10
+	maxMessageSize      = 4096
+)
+
+// The subset of *websocket.Conn the writer goroutine needs, faked in ws_client_test.go
+type wsConn interface {
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// One socket with its own bounded outbound queue and writer goroutine, so a slow subscriber
+// only ever delays itself. All writes after the handshake must go through Send.
+type Client struct {
+	UserUUID string
+	conn     wsConn
+	send     chan []byte
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewClient(userUUID string, conn wsConn) *Client {
+	client := &Client{
+		UserUUID: userUUID,
+		conn:     conn,
+		send:     make(chan []byte, clientSendQueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go client.writePump()
+
+	return client
+}
+
+// Queue a message without blocking, false when the queue is full or the client is closed
+func (c *Client) Send(message []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// Tell the peer why it's being dropped before closing, WriteControl is safe alongside the writer
+func (c *Client) CloseWithCode(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.Close()
+}
+
+// Block until the writer goroutine has exited, the connection must not be touched afterwards
+func (c *Client) Wait() {
+	<-c.stopped
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Close()
+		close(c.stopped)
+	}()
+
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
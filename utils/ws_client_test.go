@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+const testTimeout = 2 * time.Second
+
+// Stands in for a peer: writes either fail, hang until the connection is closed, or succeed
+type fakeConn struct {
+	writeErr error
+	stuck    bool
+
+	mutex      sync.Mutex
+	written    [][]byte
+	closeCodes []int
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{closed: make(chan struct{})}
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if f.stuck {
+		<-f.closed
+		return errors.New("connection closed")
+	}
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.written = append(f.written, data)
+	return nil
+}
+
+func (f *fakeConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if messageType == websocket.CloseMessage && len(data) >= 2 {
+		f.mutex.Lock()
+		f.closeCodes = append(f.closeCodes, int(binary.BigEndian.Uint16(data)))
+		f.mutex.Unlock()
+	}
+	return nil
+}
+
+func (f *fakeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (f *fakeConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeConn) writtenCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.written)
+}
+
+func (f *fakeConn) closedWith(code int) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, closeCode := range f.closeCodes {
+		if closeCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+func waitStopped(t *testing.T, client *Client) {
+	t.Helper()
+
+	stopped := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(testTimeout):
+		t.Fatal("writePump did not exit")
+	}
+}
+
+func runWithin(t *testing.T, what string, fn func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatalf("%s blocked", what)
+	}
+}
+
+func groupSize(broker *InMemoryBroker, shuttleUUID string) int {
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+	return len(broker.groups[shuttleUUID])
+}
+
+func TestSlowClientIsEvictedWhenQueueIsFull(t *testing.T) {
+	broker := NewInMemoryBroker()
+	conn := newFakeConn()
+	conn.stuck = true
+	client := NewClient("slow-subscriber", conn)
+	broker.Join("shuttle", client)
+
+	// One message is taken by the hanging write, the queue holds the next ones until it's full
+	runWithin(t, "Publish", func() {
+		for i := 0; i < clientSendQueueSize+2; i++ {
+			broker.Publish("shuttle", []byte(fmt.Sprintf("message %d", i)))
+		}
+	})
+
+	if !conn.closedWith(websocket.CloseTryAgainLater) {
+		t.Fatalf("expected the slow client to be closed with code %d", websocket.CloseTryAgainLater)
+	}
+	if size := groupSize(broker, "shuttle"); size != 0 {
+		t.Fatalf("expected the slow client to leave the group, %d clients left", size)
+	}
+	waitStopped(t, client)
+}
+
+func TestFullQueueRejectsSend(t *testing.T) {
+	conn := newFakeConn()
+	conn.stuck = true
+	client := NewClient("slow-subscriber", conn)
+	defer func() {
+		client.Close()
+		client.Wait()
+	}()
+
+	accepted := 0
+	for i := 0; i < clientSendQueueSize*2; i++ {
+		if client.Send([]byte("message")) {
+			accepted++
+		}
+	}
+
+	// The writer may already hold one message in its hanging write
+	if accepted < clientSendQueueSize || accepted > clientSendQueueSize+1 {
+		t.Fatalf("expected %d or %d messages to be queued, got %d", clientSendQueueSize, clientSendQueueSize+1, accepted)
+	}
+}
+
+func TestWritePumpExitsOnWriteError(t *testing.T) {
+	conn := newFakeConn()
+	conn.writeErr = errors.New("broken pipe")
+	client := NewClient("dead-subscriber", conn)
+
+	client.Send([]byte("message"))
+	waitStopped(t, client)
+
+	if client.Send([]byte("message")) {
+		t.Fatal("expected Send to fail once the writer has stopped")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Fatal("expected the connection to be closed")
+	}
+}
+
+func TestBroadcastDoesNotBlockOnStuckClient(t *testing.T) {
+	previous := shuttleBroker
+	broker := NewInMemoryBroker()
+	SetShuttleBroker(broker)
+	defer SetShuttleBroker(previous)
+
+	stuckConn := newFakeConn()
+	stuckConn.stuck = true
+	stuck := NewClient("stuck-subscriber", stuckConn)
+	AddToShuttleGroup("shuttle", stuck)
+
+	runWithin(t, "BroadcastToShuttleGroup", func() {
+		for i := 0; i < clientSendQueueSize*3; i++ {
+			BroadcastToShuttleGroup("shuttle", []byte("message"))
+		}
+	})
+
+	if !stuckConn.closedWith(websocket.CloseTryAgainLater) {
+		t.Fatal("expected the stuck client to be evicted")
+	}
+	waitStopped(t, stuck)
+}
+
+func TestBroadcastReachesHealthyClientNextToStuckOne(t *testing.T) {
+	previous := shuttleBroker
+	broker := NewInMemoryBroker()
+	SetShuttleBroker(broker)
+	defer SetShuttleBroker(previous)
+
+	stuckConn := newFakeConn()
+	stuckConn.stuck = true
+	stuck := NewClient("stuck-subscriber", stuckConn)
+	healthyConn := newFakeConn()
+	healthy := NewClient("healthy-subscriber", healthyConn)
+	AddToShuttleGroup("shuttle", stuck)
+	AddToShuttleGroup("shuttle", healthy)
+	defer func() {
+		for _, client := range []*Client{stuck, healthy} {
+			client.Close()
+			client.Wait()
+		}
+	}()
+
+	const messages = 10
+	runWithin(t, "BroadcastToShuttleGroup", func() {
+		for i := 0; i < messages; i++ {
+			BroadcastToShuttleGroup("shuttle", []byte("message"))
+		}
+	})
+
+	deadline := time.Now().Add(testTimeout)
+	for healthyConn.writtenCount() < messages {
+		if time.Now().After(deadline) {
+			t.Fatalf("healthy client got %d of %d messages", healthyConn.writtenCount(), messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
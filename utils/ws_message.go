@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"shuttle/models/entity"
+
+	"github.com/google/uuid"
+)
+
+const WSProtocolVersion = 1
+
+const (
+	MessageTypeConnected         = "connected"
+	MessageTypeAck               = "ack"
+	MessageTypeError             = "error"
+	MessageTypeLocation          = "location"
+	MessageTypeStatusChange      = "status_change"
+	MessageTypeStudentPickedUp   = entity.ShuttleEventStudentPickedUp
+	MessageTypeStudentDroppedOff = entity.ShuttleEventStudentDroppedOff
+	MessageTypeETAUpdate         = "eta_update"
+)
+
+// Every frame on a shuttle channel, in both directions
+type WSEnvelope struct {
+	Version     int             `json:"v"`
+	Type        string          `json:"type"`
+	ShuttleUUID string          `json:"shuttle_uuid"`
+	Timestamp   time.Time       `json:"ts"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+}
+
+type LocationPayload struct {
+	Latitude   float64  `json:"latitude"`
+	Longitude  float64  `json:"longitude"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Heading    *float64 `json:"heading,omitempty"`
+	RecordedAt string   `json:"recorded_at,omitempty"`
+}
+
+type StatusChangePayload struct {
+	Status string `json:"status"`
+}
+
+type StudentEventPayload struct {
+	StudentUUID string   `json:"student_uuid"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+type StudentETA struct {
+	StudentUUID string  `json:"student_uuid"`
+	Target      string  `json:"target"`
+	ETASeconds  int64   `json:"eta_seconds"`
+	DistanceKm  float64 `json:"distance_km"`
+	ArrivalAt   string  `json:"arrival_at"`
+}
+
+type ETAUpdatePayload struct {
+	Students []StudentETA `json:"students"`
+}
+
+type ErrorPayload struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type AckPayload struct {
+	Type string `json:"type"`
+}
+
+type ConnectedPayload struct {
+	Role string `json:"role"`
+}
+
+func NewEnvelope(messageType, shuttleUUID string, payload interface{}) ([]byte, error) {
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(WSEnvelope{
+		Version:     WSProtocolVersion,
+		Type:        messageType,
+		ShuttleUUID: shuttleUUID,
+		Timestamp:   time.Now(),
+		Payload:     rawPayload,
+	})
+}
+
+func NewErrorEnvelope(shuttleUUID string, code int, message string) []byte {
+	envelope, _ := NewEnvelope(MessageTypeError, shuttleUUID, ErrorPayload{Code: code, Message: message})
+	return envelope
+}
+
+// Parse a frame sent by the driver. Apps predating the envelope send bare
+// {"latitude", "longitude"} objects, those are still accepted as location messages.
+func ParseDriverEnvelope(message []byte, shuttleUUID string) (WSEnvelope, error) {
+	var envelope WSEnvelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return WSEnvelope{}, fmt.Errorf("message must be a JSON object")
+	}
+
+	if envelope.Type == "" {
+		var legacy LocationPayload
+		if err := json.Unmarshal(message, &legacy); err != nil || legacy.Latitude == 0 || legacy.Longitude == 0 {
+			return WSEnvelope{}, fmt.Errorf("message must contain 'type' and 'payload'")
+		}
+		envelope = WSEnvelope{Version: WSProtocolVersion, Type: MessageTypeLocation, Payload: message}
+	}
+
+	if envelope.Version != WSProtocolVersion {
+		return WSEnvelope{}, fmt.Errorf("unsupported protocol version %d, expected %d", envelope.Version, WSProtocolVersion)
+	}
+	if envelope.ShuttleUUID != "" && envelope.ShuttleUUID != shuttleUUID {
+		return WSEnvelope{}, fmt.Errorf("shuttle_uuid does not match this channel")
+	}
+
+	switch envelope.Type {
+	case MessageTypeLocation:
+		var payload LocationPayload
+		if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+			return WSEnvelope{}, fmt.Errorf("invalid location payload")
+		}
+		if payload.Latitude < -90 || payload.Latitude > 90 || payload.Longitude < -180 || payload.Longitude > 180 || (payload.Latitude == 0 && payload.Longitude == 0) {
+			return WSEnvelope{}, fmt.Errorf("location payload must contain valid 'latitude' and 'longitude'")
+		}
+	case MessageTypeStatusChange:
+		var payload StatusChangePayload
+		if err := json.Unmarshal(envelope.Payload, &payload); err != nil || !contains(entity.ShuttleStatuses, payload.Status) {
+			return WSEnvelope{}, fmt.Errorf("status_change payload must contain a valid 'status'")
+		}
+	case MessageTypeStudentPickedUp, MessageTypeStudentDroppedOff:
+		var payload StudentEventPayload
+		if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+			return WSEnvelope{}, fmt.Errorf("invalid %s payload", envelope.Type)
+		}
+		if _, err := uuid.Parse(payload.StudentUUID); err != nil {
+			return WSEnvelope{}, fmt.Errorf("%s payload must contain a valid 'student_uuid'", envelope.Type)
+		}
+	case MessageTypeETAUpdate, MessageTypeError, MessageTypeAck, MessageTypeConnected:
+		return WSEnvelope{}, fmt.Errorf("message type '%s' can only be sent by the server", envelope.Type)
+	default:
+		return WSEnvelope{}, fmt.Errorf("unknown message type '%s'", envelope.Type)
+	}
+
+	// Subscribers get the server's view of the channel and clock, not whatever the device sent
+	envelope.ShuttleUUID = shuttleUUID
+	envelope.Timestamp = time.Now()
+
+	return envelope, nil
+}